@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	hutils "github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// ServerAuth 校验请求是否由 server 使用注册时下发的密钥签名
+func ServerAuth(secret string) app.HandlerFunc {
+	return func(ctx context.Context, c *app.RequestContext) {
+		path := utils.SignedPath(string(c.Path()), string(c.Request.URI().QueryString()))
+		if err := utils.VerifySignature(secret, string(c.Method()), path,
+			string(c.GetHeader(utils.HeaderTimestamp)), string(c.GetHeader(utils.HeaderSignature)), c.Request.Body()); err != nil {
+			c.AbortWithStatusJSON(consts.StatusUnauthorized, hutils.H{"error": err.Error()})
+			return
+		}
+		c.Next(ctx)
+	}
+}
+
+// DecryptBody 解密 server 加密后下发的请求体，需要在 ServerAuth 之后执行
+func DecryptBody(secret string) app.HandlerFunc {
+	key := utils.TransportKey(secret)
+	return func(ctx context.Context, c *app.RequestContext) {
+		if len(c.GetHeader(utils.HeaderEncryption)) == 0 {
+			c.Next(ctx)
+			return
+		}
+		body, err := utils.Open(key, c.Request.Body())
+		if err != nil {
+			c.AbortWithStatusJSON(consts.StatusBadRequest, hutils.H{"error": "decrypt body error: " + err.Error()})
+			return
+		}
+		c.Request.SetBody(body)
+		c.Request.Header.SetContentTypeBytes([]byte("application/json"))
+		c.Next(ctx)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	jobexec "cicd-runner/job_exec"
+	"cicd-runner/types"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
@@ -17,7 +18,10 @@ func StartJob(ctx context.Context, c *app.RequestContext) {
 		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
 		return
 	}
-	job.AddJob()
+	if err := job.AddJob(); err != nil {
+		c.JSON(consts.StatusServiceUnavailable, utils.H{"error": err.Error()})
+		return
+	}
 
 	hlog.Infof("start job success")
 
@@ -39,3 +43,25 @@ func CancelJob(ctx context.Context, c *app.RequestContext) {
 	hlog.Infof("cancel job success")
 	c.JSON(consts.StatusOK, utils.H{"data": "success"})
 }
+
+func Drain(ctx context.Context, c *app.RequestContext) {
+	jobexec.RequestDrain()
+
+	hlog.Infof("drain requested")
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+func Upgrade(ctx context.Context, c *app.RequestContext) {
+	var req types.Upgrade
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if err := jobexec.RequestUpgrade(req); err != nil {
+		c.JSON(consts.StatusConflict, utils.H{"error": err.Error()})
+		return
+	}
+
+	hlog.Infof("upgrade to %s requested", req.Version)
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
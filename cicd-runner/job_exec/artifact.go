@@ -0,0 +1,225 @@
+package jobexec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"cicd-runner/utils"
+)
+
+type artifact struct {
+	ID       uint   `json:"id"`
+	StepName string `json:"step_name"`
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// uploadArtifacts 步骤成功后上传匹配的构建产物，目录会上传其中的所有文件
+func (job *JobExec) uploadArtifacts(dir string) error {
+	if len(job.JobRunner.Artifacts) == 0 {
+		return nil
+	}
+
+	var files []string
+	for _, pattern := range job.JobRunner.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return fmt.Errorf("invalid artifact pattern %s: %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			job.AddLog(fmt.Sprintf("no artifact matches: %s", pattern))
+		}
+		for _, match := range matches {
+			// Glob 会跟随上级目录中的符号链接，Walk 不进入符号链接，只需检查匹配项的上级目录
+			if !withinDir(dir, match) {
+				return fmt.Errorf("artifact %s is outside of workspace", match)
+			}
+			if err := checkParents(dir, match); err != nil {
+				return err
+			}
+			if err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("artifact %s is outside of workspace", file)
+		}
+		// 上传前再次检查，避免遍历之后目录被替换为符号链接
+		if err := checkParents(dir, file); err != nil {
+			return err
+		}
+		size, err := uploadArtifact(job.JobRunner.ID, file, filepath.ToSlash(rel))
+		if err != nil {
+			return fmt.Errorf("upload artifact %s error: %s", rel, err)
+		}
+		job.AddLog(fmt.Sprintf("artifact uploaded: %s, size: %d", rel, size))
+	}
+	return nil
+}
+
+// downloadArtifacts 执行命令前下载前序步骤的构建产物到工作目录
+func (job *JobExec) downloadArtifacts(dir string) error {
+	if len(job.JobRunner.ArtifactDownloads) == 0 {
+		return nil
+	}
+
+	artifacts, err := listArtifacts(job.JobRunner.ID)
+	if err != nil {
+		return fmt.Errorf("list artifacts error: %s", err)
+	}
+	if len(artifacts) == 0 {
+		job.AddLog(fmt.Sprintf("no artifact found from steps: %s", strings.Join(job.JobRunner.ArtifactDownloads, ", ")))
+	}
+	for _, a := range artifacts {
+		path := filepath.Join(dir, filepath.FromSlash(a.Name))
+		if rel, err := filepath.Rel(dir, path); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("invalid artifact name: %s", a.Name)
+		}
+		if err := downloadArtifact(job.JobRunner.ID, a, dir, path); err != nil {
+			return fmt.Errorf("download artifact %s error: %s", a.Name, err)
+		}
+		job.AddLog(fmt.Sprintf("artifact downloaded: %s (from %s)", a.Name, a.StepName))
+	}
+	return nil
+}
+
+func uploadArtifact(jobRunnerID uint, file, artifactName string) (int64, error) {
+	checksum, size, err := fileChecksum(file)
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{"name": {artifactName}, "checksum": {checksum}}
+	if err := postFile(fmt.Sprintf("%s/artifacts/%d/upload?%s", serverUrl, jobRunnerID, query.Encode()), file, checksum, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// fileChecksum 和 postFile 读取工作目录中的文件时不跟随符号链接
+func fileChecksum(file string) (string, int64, error) {
+	f, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// postFile 流式上传文件，不读入内存，server 接收时按签名的 sha256 校验内容
+func postFile(url, file, checksum string, size int64) error {
+	f, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	httpReq, _ := http.NewRequest("POST", url, f)
+	httpReq.ContentLength = size
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	utils.SignStreamRequest(httpReq, name, secret, checksum)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status code: %d, body: %s", resp.StatusCode, string(msg))
+	}
+	return nil
+}
+
+func listArtifacts(jobRunnerID uint) ([]artifact, error) {
+	client := &http.Client{Timeout: time.Minute}
+	httpReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/artifacts/%d/list", serverUrl, jobRunnerID), nil)
+	utils.SignRequest(httpReq, name, secret, nil)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	var artifacts []artifact
+	if err := json.NewDecoder(resp.Body).Decode(&artifacts); err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// downloadArtifact 下载构建产物到工作目录 dir 中的 path 并校验 sha256，校验失败时不覆盖已有文件。
+// runner 可能以 root 身份运行，写入时不跟随工作目录中已有的符号链接
+func downloadArtifact(jobRunnerID uint, a artifact, dir, path string) error {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	httpReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/artifacts/%d/download/%d", serverUrl, jobRunnerID, a.ID), nil)
+	utils.SignRequest(httpReq, name, secret, nil)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	if err := checkParents(dir, path); err != nil {
+		return err
+	}
+	if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), resp.Body)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != a.Checksum {
+		os.Remove(tmp)
+		return fmt.Errorf("checksum mismatch, expected: %s, actual: %s", a.Checksum, checksum)
+	}
+	if err := sandboxChown(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// rename 替换的是 path 本身，path 为符号链接时不会写入链接指向的文件
+	return os.Rename(tmp, path)
+}
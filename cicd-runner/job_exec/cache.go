@@ -0,0 +1,597 @@
+package jobexec
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+var (
+	// 本地缓存总大小上限，超出后按最近使用时间淘汰
+	cacheMaxSize int64 = 10 << 30
+	// 单个缓存大小上限，超出则不保存
+	cacheMaxEntrySize int64 = 1 << 30
+	// 是否通过 server 在不同 runner 之间共享缓存
+	cacheShare bool
+
+	hashPattern     = regexp.MustCompile(`\$\{hash\(([^)]+)\)\}`)
+	invalidKeyChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+)
+
+func SetCacheOptions(maxSize, maxEntrySize int64, share bool) {
+	cacheMaxSize = maxSize
+	cacheMaxEntrySize = maxEntrySize
+	cacheShare = share
+}
+
+func cacheRoot() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".cicd-runner", "cache"), nil
+}
+
+// cacheDir 缓存按流水线隔离，不同流水线即使 key 相同也不会互相恢复
+func cacheDir(pipelineID uint) (string, error) {
+	root, err := cacheRoot()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, fmt.Sprint(pipelineID))
+	return dir, os.MkdirAll(dir, os.ModePerm)
+}
+
+// cacheKey 渲染缓存 key 模板，${hash(glob)} 替换为匹配文件内容的哈希，${VAR} 替换为任务变量。
+// prefix 为模板中第一个变量之前的固定部分，精确 key 不存在时用于查找最新的同前缀缓存。
+func (job *JobExec) cacheKey(dir string) (key, prefix string, err error) {
+	tmpl := job.JobRunner.CacheKey
+	if i := strings.Index(tmpl, "${"); i >= 0 {
+		prefix = tmpl[:i]
+	}
+
+	key = hashPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		if err != nil {
+			return ""
+		}
+		var sum string
+		sum, err = hashFiles(dir, hashPattern.FindStringSubmatch(m)[1])
+		return sum
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	envs := make(map[string]string, len(job.Job.Envs))
+	for _, env := range job.Job.Envs {
+		envs[env.Key] = env.Val
+	}
+	key = os.Expand(key, func(k string) string {
+		return envs[k]
+	})
+	return invalidKeyChars.ReplaceAllString(key, "_"), invalidKeyChars.ReplaceAllString(prefix, "_"), nil
+}
+
+// hashFiles 计算逗号分隔的 glob 匹配到的所有文件内容的 sha256
+func hashFiles(dir, patterns string) (string, error) {
+	var files []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		matches, err := filepath.Glob(filepath.Join(dir, strings.TrimSpace(pattern)))
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no file matches hash(%s)", patterns)
+	}
+	sort.Strings(files)
+
+	hash := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// restoreCache 在执行命令前恢复缓存，缓存只是加速手段，失败时只记录日志
+func (job *JobExec) restoreCache(dir string) {
+	if job.JobRunner.CacheKey == "" || len(job.JobRunner.CachePaths) == 0 {
+		return
+	}
+
+	key, prefix, err := job.cacheKey(dir)
+	if err != nil {
+		job.AddLog(fmt.Sprintf("cache key error: %s", err))
+		return
+	}
+	paths, err := job.cachePaths(dir)
+	if err != nil {
+		job.AddLog(fmt.Sprintf("cache path error: %s", err))
+		return
+	}
+	job.cacheKeyRendered = key
+
+	store, err := cacheDir(job.Job.PipelineID)
+	if err != nil {
+		job.AddLog(fmt.Sprintf("cache dir error: %s", err))
+		return
+	}
+
+	file := findCache(store, key, prefix)
+	if file == "" && cacheShare {
+		if file, err = downloadCache(store, job.JobRunner.ID, key, prefix); err != nil {
+			hlog.Warnf("download cache error: %s", err)
+		}
+	}
+	if file == "" {
+		job.AddLog(fmt.Sprintf("cache not found: %s", key))
+		return
+	}
+
+	if err := job.extractCache(file, dir, paths); err != nil {
+		job.AddLog(fmt.Sprintf("restore cache error: %s", err))
+		return
+	}
+	now := time.Now()
+	os.Chtimes(file, now, now)
+	job.AddLog(fmt.Sprintf("cache restored: %s", strings.TrimSuffix(filepath.Base(file), ".tar.gz")))
+}
+
+// saveCache 步骤成功后保存缓存，相同 key 的缓存已存在时跳过
+func (job *JobExec) saveCache(dir string) {
+	if job.cacheKeyRendered == "" {
+		return
+	}
+	key := job.cacheKeyRendered
+
+	paths, err := job.cachePaths(dir)
+	if err != nil {
+		job.AddLog(fmt.Sprintf("cache path error: %s", err))
+		return
+	}
+	store, err := cacheDir(job.Job.PipelineID)
+	if err != nil {
+		job.AddLog(fmt.Sprintf("cache dir error: %s", err))
+		return
+	}
+	file := filepath.Join(store, key+".tar.gz")
+	if _, err := os.Stat(file); err == nil {
+		job.AddLog(fmt.Sprintf("cache already exists: %s", key))
+		return
+	}
+
+	tmp := file + ".tmp"
+	size, err := createCache(tmp, dir, paths)
+	if err != nil {
+		os.Remove(tmp)
+		job.AddLog(fmt.Sprintf("save cache error: %s", err))
+		return
+	}
+	if size > cacheMaxEntrySize {
+		os.Remove(tmp)
+		job.AddLog(fmt.Sprintf("cache %s is too large: %d MB, limit: %d MB", key, size>>20, cacheMaxEntrySize>>20))
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		job.AddLog(fmt.Sprintf("save cache error: %s", err))
+		return
+	}
+	job.AddLog(fmt.Sprintf("cache saved: %s, size: %d MB", key, size>>20))
+
+	evictCache()
+	if cacheShare {
+		if err := uploadCache(file, job.JobRunner.ID, key); err != nil {
+			hlog.Warnf("upload cache error: %s", err)
+		}
+	}
+}
+
+// findCache 优先返回精确匹配的缓存，否则返回同前缀中最新的缓存
+func findCache(store, key, prefix string) string {
+	file := filepath.Join(store, key+".tar.gz")
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+	if prefix == "" {
+		return ""
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(store, prefix+"*.tar.gz"))
+	var newest string
+	var newestTime time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newestTime) {
+			newest, newestTime = match, info.ModTime()
+		}
+	}
+	return newest
+}
+
+// evictCache 按最近使用时间淘汰所有流水线的缓存，直到总大小不超过上限。
+// 同时包含旧版本不区分流水线保存的缓存，让它们逐渐被淘汰
+func evictCache() {
+	root, err := cacheRoot()
+	if err != nil {
+		hlog.Warnf("evict cache error: %s", err)
+		return
+	}
+	legacy, _ := filepath.Glob(filepath.Join(root, "*.tar.gz"))
+	matches, _ := filepath.Glob(filepath.Join(root, "*", "*.tar.gz"))
+	matches = append(matches, legacy...)
+
+	type cacheFile struct {
+		path string
+		info os.FileInfo
+	}
+	files := make([]cacheFile, 0, len(matches))
+	var total int64
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{match, info})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	for _, f := range files {
+		if total <= cacheMaxSize {
+			return
+		}
+		if err := os.Remove(f.path); err != nil {
+			hlog.Warnf("evict cache error: %s", err)
+			continue
+		}
+		total -= f.info.Size()
+		hlog.Infof("evict cache: %s", f.path)
+	}
+}
+
+// cachePaths 按任务的环境变量展开缓存路径并转换为绝对路径。
+// 缓存以 runner 自身的身份保存和恢复，只允许工作目录和任务 HOME 中的路径
+func (job *JobExec) cachePaths(dir string) ([]string, error) {
+	paths := make([]string, 0, len(job.JobRunner.CachePaths))
+	for _, p := range job.JobRunner.CachePaths {
+		path := os.Expand(p, job.getenv)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+		if cacheRootOf(dir, path) == "" {
+			return nil, fmt.Errorf("%s is outside of workspace and HOME", p)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// cacheRootOf 返回 path 所在的工作目录或任务 HOME，都不在时返回空
+func cacheRootOf(dir, path string) string {
+	for _, root := range []string{dir, sandboxHome} {
+		if root != "" && withinDir(root, path) {
+			return root
+		}
+	}
+	return ""
+}
+
+// withinDir 只比较清理后的路径，不解析符号链接，需要配合 checkParents 使用
+func withinDir(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkParents 检查 root 与 path 之间已存在的各级目录都不是符号链接，
+// 避免通过任务创建的符号链接读写 root 以外的文件
+func checkParents(root, path string) error {
+	if path == root {
+		return nil
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return err
+	}
+	cur := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", cur)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", cur)
+		}
+	}
+	return nil
+}
+
+// 归档中工作目录内的路径以 w/ 开头，任务 HOME 中的路径以 a/ 加绝对路径开头
+func archiveName(dir, path string) string {
+	if withinDir(dir, path) {
+		rel, _ := filepath.Rel(dir, path)
+		return "w/" + filepath.ToSlash(rel)
+	}
+	return "a/" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+// restorePath 归档条目恢复到的路径，不在当前步骤声明的缓存路径中的条目返回空
+func restorePath(dir, name string, paths []string) string {
+	var path string
+	switch {
+	case strings.HasPrefix(name, "w/"):
+		path = filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, "w/")))
+	case strings.HasPrefix(name, "a/"):
+		path = filepath.Join("/", filepath.FromSlash(strings.TrimPrefix(name, "a/")))
+	default:
+		return ""
+	}
+	for _, p := range paths {
+		if withinDir(p, path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func createCache(file, dir string, paths []string) (int64, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, p := range paths {
+		if err := checkParents(cacheRootOf(dir, p), p); err != nil {
+			return 0, err
+		}
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			header.Name = archiveName(dir, path)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			src, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(tw, src)
+			return err
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// extractCache 恢复缓存中位于 paths 内的条目。runner 可能以 root 身份运行，
+// 写入时不跟随已有的符号链接，也不恢复指向工作目录和任务 HOME 以外的符号链接
+func (job *JobExec) extractCache(file, dir string, paths []string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := restorePath(dir, header.Name, paths)
+		if path == "" {
+			continue
+		}
+		if err := checkParents(cacheRootOf(dir, path), path); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := removeSymlink(path); err != nil {
+				return err
+			}
+			if err := mkdirAll(path, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			if cacheRootOf(dir, filepath.Clean(target)) == "" {
+				job.AddLog(fmt.Sprintf("skip cache entry %s: symlink to %s is outside of workspace and HOME", header.Name, header.Linkname))
+				continue
+			}
+			if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			os.Remove(path)
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+			if err := sandboxChown(path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			if err := removeSymlink(path); err != nil {
+				return err
+			}
+			dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|syscall.O_NOFOLLOW, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, tr)
+			dst.Close()
+			if err != nil {
+				return err
+			}
+			if err := sandboxChown(path); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// removeSymlink path 是符号链接时删除链接本身
+func removeSymlink(path string) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// mkdirAll 与 os.MkdirAll 相同，新建的目录交给任务用户。调用前需要先通过 checkParents 检查
+func mkdirAll(path string, perm os.FileMode) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := mkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, perm); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return sandboxChown(path)
+}
+
+// downloadCache 从 server 下载缓存到本地缓存目录
+func downloadCache(store string, jobRunnerID uint, key, prefix string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	query := url.Values{"job_runner_id": {fmt.Sprint(jobRunnerID)}, "key": {key}, "prefix": {prefix}}
+	httpReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/cache/download?%s", serverUrl, query.Encode()), nil)
+	utils.SignRequest(httpReq, name, secret, nil)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("download cache failed, status code: %d", resp.StatusCode)
+	}
+
+	matched := invalidKeyChars.ReplaceAllString(cmp.Or(resp.Header.Get("X-Cicd-Cache-Key"), key), "_")
+	file := filepath.Join(store, matched+".tar.gz")
+	tmp := file + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, resp.Body)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	evictCache()
+	return file, nil
+}
+
+// uploadCache 把缓存上传到 server，供其他 runner 使用
+func uploadCache(file string, jobRunnerID uint, key string) error {
+	checksum, size, err := fileChecksum(file)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"job_runner_id": {fmt.Sprint(jobRunnerID)}, "key": {key}}
+	return postFile(fmt.Sprintf("%s/cache/upload?%s", serverUrl, query.Encode()), file, checksum, size)
+}
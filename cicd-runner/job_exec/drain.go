@@ -0,0 +1,130 @@
+package jobexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cicd-runner/types"
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// 超过宽限期后取消任务，等待任务退出和日志发送完成的最长时间
+const flushTimeout = 30 * time.Second
+
+var (
+	ErrDraining = errors.New("runner is draining, no new jobs accepted")
+
+	drainMutex   sync.Mutex
+	draining     bool
+	active       int
+	drainOnce    sync.Once
+	drainRequest = make(chan struct{})
+	running      sync.WaitGroup
+	flushed      = make(chan struct{}, 2)
+)
+
+func jobDone() {
+	drainMutex.Lock()
+	active--
+	drainMutex.Unlock()
+	running.Done()
+}
+
+func Draining() bool {
+	drainMutex.Lock()
+	defer drainMutex.Unlock()
+	return draining
+}
+
+// RequestDrain 由 server 触发排空，通知主进程开始退出流程
+func RequestDrain() {
+	drainOnce.Do(func() {
+		close(drainRequest)
+	})
+}
+
+func DrainRequested() <-chan struct{} {
+	return drainRequest
+}
+
+// Drain 停止接收新任务，在宽限期内等待当前任务结束，超时则取消，最后把未发送的日志和事件发送完
+func Drain(grace time.Duration) {
+	drainMutex.Lock()
+	draining = true
+	drainMutex.Unlock()
+
+	hlog.Infof("runner draining, grace period: %s", grace)
+	reportStatus(types.RunnerDraining)
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		hlog.Info("all jobs finished")
+	case <-time.After(grace):
+		hlog.Warnf("grace period %s exceeded, cancel running jobs", grace)
+		mutex.Lock()
+		for id, cancel := range jobCancelFunc {
+			if job, ok := jobMap[id]; ok {
+				job.AddLog("Runner is shutting down, job canceled")
+			}
+			cancel()
+		}
+		mutex.Unlock()
+
+		select {
+		case <-done:
+		case <-time.After(flushTimeout):
+			hlog.Warn("wait canceled jobs timeout")
+		}
+	}
+
+	flush()
+	reportStatus(types.RunnerOffline)
+}
+
+// flush 在队列末尾放入标记，等待之前的日志和事件全部发送
+func flush() {
+	logChan <- nil
+	eventChan <- nil
+	timeout := time.After(flushTimeout)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-flushed:
+		case <-timeout:
+			hlog.Warnf("flush logs and events timeout, pending logs: %d, pending events: %d", len(logChan), len(eventChan))
+			return
+		}
+	}
+	hlog.Info("flush logs and events success")
+}
+
+func reportStatus(status string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	jsonBytes, _ := json.Marshal(types.RunnerStatus{Status: status})
+	httpReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/runner_status", serverUrl), bytes.NewReader(jsonBytes))
+	httpReq.Header.Set("Content-Type", "application/json")
+	utils.SignRequest(httpReq, name, secret, jsonBytes)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		hlog.Warnf("report runner status error: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		hlog.Warnf("report runner status failed, status code: %d", resp.StatusCode)
+		return
+	}
+	hlog.Infof("report runner status success: %s", status)
+}
@@ -0,0 +1,192 @@
+package jobexec
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"cicd-runner/types"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// Executor 在工作目录中执行单条命令，输出写入 stdout 和 stderr。
+// 命令以非零状态退出时返回 *exec.ExitError。
+type Executor interface {
+	Name() string
+	Run(ctx context.Context, job *JobExec, dir, command string, stdout, stderr io.Writer) error
+}
+
+var containerRuntime = "docker"
+
+// SetContainerRuntime 设置容器执行器使用的命令行工具，例如 docker 或 podman
+func SetContainerRuntime(runtime string) {
+	containerRuntime = runtime
+}
+
+// ContainerAvailable 判断本机是否安装了容器运行时
+func ContainerAvailable() bool {
+	_, err := exec.LookPath(containerRuntime)
+	return err == nil
+}
+
+// executor 步骤声明了镜像时在容器中执行，否则直接在宿主机执行
+func (job *JobExec) executor() Executor {
+	if job.JobRunner.Image != "" {
+		return &ContainerExecutor{Runtime: containerRuntime, Image: job.JobRunner.Image}
+	}
+	return &ShellExecutor{}
+}
+
+// ShellExecutor 在宿主机上通过 sh -c 执行命令
+type ShellExecutor struct{}
+
+func (e *ShellExecutor) Name() string {
+	return "shell"
+}
+
+func (e *ShellExecutor) Run(ctx context.Context, job *JobExec, dir, command string, stdout, stderr io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Env = job.env
+	cmd.SysProcAttr = sysProcAttr()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return job.runProcessGroup(ctx, cmd)
+}
+
+// ContainerExecutor 在指定镜像的容器中执行命令，工作目录挂载到 /workspace
+type ContainerExecutor struct {
+	Runtime string
+	Image   string
+	// Mounts 额外以只读方式挂载的宿主机文件，key 为宿主机路径，value 为容器内路径
+	Mounts map[string]string
+}
+
+const (
+	containerWorkspace = "/workspace"
+	containerScript    = "/cicd/script"
+)
+
+func (e *ContainerExecutor) Name() string {
+	return fmt.Sprintf("%s(%s)", e.Runtime, e.Image)
+}
+
+func (e *ContainerExecutor) Run(ctx context.Context, job *JobExec, dir, command string, stdout, stderr io.Writer) error {
+	name := fmt.Sprintf("cicd-%d-%d", job.JobRunner.ID, time.Now().UnixNano())
+	args := []string{"run", "--rm", "--name", name, "-v", dir + ":" + containerWorkspace, "-w", containerWorkspace}
+	for src, dst := range e.Mounts {
+		args = append(args, "-v", src+":"+dst+":ro")
+	}
+	if sandboxCred != nil {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", sandboxCred.Uid, sandboxCred.Gid))
+	}
+	// 只传变量名，变量值通过运行时进程的环境变量传入，避免出现在进程列表中
+	env := os.Environ()
+	for _, e := range job.Job.Envs {
+		args = append(args, "-e", e.Key)
+		env = append(env, e.Key+"="+e.Val)
+	}
+	args = append(args, e.Image, "sh", "-c", command)
+
+	cmd := exec.Command(e.Runtime, args...)
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	output, err := pipeOutput(cmd)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		output.close()
+		return err
+	}
+	output.started()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		e.stop(job, name)
+		<-done
+		err = ctx.Err()
+	}
+	output.wait(outputWaitDelay)
+	return err
+}
+
+// mount 把宿主机文件只读挂载到容器内的 dst，返回容器内的路径
+func (e *ContainerExecutor) mount(src, dst string) string {
+	if e.Mounts == nil {
+		e.Mounts = make(map[string]string)
+	}
+	e.Mounts[src] = dst
+	return dst
+}
+
+// stop 结束运行时进程不会停止容器，需要显式停止：容器内的进程先收到 SIGTERM，
+// 超过 killGrace 后由运行时发送 SIGKILL，容器随后因 --rm 被删除
+func (e *ContainerExecutor) stop(job *JobExec, name string) {
+	timeout := strconv.Itoa(int(math.Ceil(killGrace.Seconds())))
+	job.AddLog(fmt.Sprintf("stop container %s, timeout: %ss", name, timeout))
+	out, err := exec.Command(e.Runtime, "stop", "-t", timeout, name).CombinedOutput()
+	if err == nil {
+		return
+	}
+	hlog.Errorf("stop container %s error: %s, %s", name, err, string(out))
+	if out, err := exec.Command(e.Runtime, "rm", "-f", name).CombinedOutput(); err != nil {
+		hlog.Errorf("remove container %s error: %s, %s", name, err, string(out))
+	}
+}
+
+// lineWriter 把写入的内容按行写入任务日志
+type lineWriter struct {
+	pw   *io.PipeWriter
+	done sync.WaitGroup
+
+	// onLine 返回 true 表示该行已处理，不再写入日志
+	onLine func(line string) bool
+}
+
+// newLineWriter stream 为 stdout 或 stderr，command 返回当前输出所属的命令下标
+func newLineWriter(job *JobExec, stream string, command func() int) *lineWriter {
+	pr, pw := io.Pipe()
+	w := &lineWriter{pw: pw}
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if w.onLine != nil && w.onLine(scanner.Text()) {
+				continue
+			}
+			job.addRecord(types.LogRecord{Stream: stream, Command: command(), Text: scanner.Text()})
+		}
+		// 读取出错时丢弃剩余内容，避免写入方阻塞
+		io.Copy(io.Discard, pr)
+	}()
+	return w
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close 关闭写入端并等待所有行写入日志
+func (w *lineWriter) Close() error {
+	err := w.pw.Close()
+	w.done.Wait()
+	return err
+}
@@ -0,0 +1,216 @@
+package jobexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cicd-runner/types"
+	"cicd-runner/utils"
+)
+
+// fakeExecutor 按配置输出内容并返回退出码，block 为 true 时一直等待到取消
+type fakeExecutor struct {
+	stdout, stderr []string
+	exitCode       int
+	block          bool
+}
+
+func (e *fakeExecutor) Name() string {
+	return "fake"
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, job *JobExec, dir, command string, stdout, stderr io.Writer) error {
+	for _, line := range e.stdout {
+		fmt.Fprintln(stdout, line)
+	}
+	for _, line := range e.stderr {
+		fmt.Fprintln(stderr, line)
+	}
+	if e.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if e.exitCode != 0 {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", e.exitCode)).Run()
+	}
+	return nil
+}
+
+func newTestJob(t *testing.T) *JobExec {
+	t.Helper()
+	drainLogs()
+	return &JobExec{
+		JobRunner: JobRunner{ID: 1, Commands: []string{"test"}},
+		env:       os.Environ(),
+		masker:    utils.NewMasker(),
+	}
+}
+
+// drainLogs 取出已写入的日志和事件
+func drainLogs() ([]types.LogRecord, []*types.Event) {
+	var records []types.LogRecord
+	var events []*types.Event
+	for {
+		select {
+		case log := <-logChan:
+			records = append(records, log.Record)
+		case event := <-eventChan:
+			events = append(events, event)
+		default:
+			return records, events
+		}
+	}
+}
+
+func streamLines(records []types.LogRecord, stream string) []string {
+	var lines []string
+	for _, r := range records {
+		if r.Stream == stream && r.Type == "" {
+			lines = append(lines, r.Text)
+		}
+	}
+	return lines
+}
+
+func sectionExitCode(t *testing.T, records []types.LogRecord) int {
+	t.Helper()
+	for _, r := range records {
+		if r.Type == types.LogSectionEnd && r.ExitCode != nil {
+			return *r.ExitCode
+		}
+	}
+	t.Fatal("section end not found")
+	return 0
+}
+
+func TestCommandOutput(t *testing.T) {
+	job := newTestJob(t)
+	executor := &fakeExecutor{stdout: []string{"out 1", "out 2"}, stderr: []string{"err 1"}}
+	if !job.command(context.Background(), executor, t.TempDir(), 0, "test") {
+		t.Fatal("command should succeed")
+	}
+
+	records, _ := drainLogs()
+	if got := streamLines(records, types.LogStdout); fmt.Sprint(got) != "[out 1 out 2]" {
+		t.Errorf("stdout = %v", got)
+	}
+	if got := streamLines(records, types.LogStderr); fmt.Sprint(got) != "[err 1]" {
+		t.Errorf("stderr = %v", got)
+	}
+	for _, r := range records {
+		if r.Stream != types.LogSystem && r.Command != 0 {
+			t.Errorf("output %q belongs to command %d", r.Text, r.Command)
+		}
+	}
+	if code := sectionExitCode(t, records); code != 0 {
+		t.Errorf("exit code = %d", code)
+	}
+}
+
+func TestCommandExitCode(t *testing.T) {
+	job := newTestJob(t)
+	if job.command(context.Background(), &fakeExecutor{exitCode: 3}, t.TempDir(), 0, "test") {
+		t.Fatal("command should fail")
+	}
+
+	records, events := drainLogs()
+	if code := sectionExitCode(t, records); code != 3 {
+		t.Errorf("exit code = %d", code)
+	}
+	if len(events) != 1 || events[0].Success || events[0].Message != fmt.Sprintf("[%s] exit code: 3", name) {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestCommandCancel(t *testing.T) {
+	job := newTestJob(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if job.command(ctx, &fakeExecutor{block: true}, t.TempDir(), 0, "test") {
+		t.Fatal("command should fail")
+	}
+
+	records, _ := drainLogs()
+	if code := sectionExitCode(t, records); code != -1 {
+		t.Errorf("exit code = %d", code)
+	}
+}
+
+func TestShellExecutor(t *testing.T) {
+	job := newTestJob(t)
+	var stdout, stderr bytes.Buffer
+	err := (&ShellExecutor{}).Run(context.Background(), job, t.TempDir(), "echo out; echo err >&2; exit 3", &stdout, &stderr)
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("err = %v", err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestShellExecutorCancel(t *testing.T) {
+	defer SetKillGrace(killGrace)
+	SetKillGrace(time.Second)
+
+	job := newTestJob(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := (&ShellExecutor{}).Run(ctx, job, t.TempDir(), "sleep 30", io.Discard, io.Discard)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("cancel took %s", d)
+	}
+}
+
+// 命令留下的后台进程持有输出管道时，步骤仍然要在命令退出后结束
+func TestShellExecutorBackgroundProcess(t *testing.T) {
+	defer SetKillGrace(killGrace)
+	SetKillGrace(time.Second)
+
+	job := newTestJob(t)
+	var stdout bytes.Buffer
+	start := time.Now()
+	err := (&ShellExecutor{}).Run(context.Background(), job, t.TempDir(), "sleep 30 & echo started", &stdout, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command took %s", d)
+	}
+	if stdout.String() != "started\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+}
+
+// 命令正常退出时不终止有意启动的后台进程
+func TestShellExecutorKeepBackgroundProcess(t *testing.T) {
+	job := newTestJob(t)
+	var stdout bytes.Buffer
+	err := (&ShellExecutor{}).Run(context.Background(), job, t.TempDir(), "nohup sleep 30 >/dev/null 2>&1 & echo $!", &stdout, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatalf("stdout = %q", stdout.String())
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Errorf("background process %d: %s", pid, err)
+	}
+}
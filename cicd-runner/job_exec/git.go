@@ -0,0 +1,238 @@
+package jobexec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cicd-runner/types"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+var (
+	// 是否在 runner 上为每个仓库维护一个裸镜像，新工作目录通过 --reference 复用其中的对象
+	gitMirror      = true
+	gitMirrorMutex sync.Mutex
+	gitMirrorLocks = make(map[string]*sync.Mutex)
+)
+
+func SetGitMirror(enable bool) {
+	gitMirror = enable
+}
+
+// GitCloneOrPull 把仓库检出到工作目录的指定 commit，已有仓库更新失败时重新克隆
+func (j *JobExec) GitCloneOrPull(ctx context.Context, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if err := j.updateRepo(ctx, dir); err == nil {
+			return j.checkout(ctx, dir)
+		} else if ctx.Err() != nil {
+			return err
+		} else {
+			j.AddLog(fmt.Sprintf("update repository error: %s, clone again", err))
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// 克隆失败时工作目录里可能只有部分内容，清理后再克隆
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), os.ModePerm); err != nil {
+		return err
+	}
+	if err := j.cloneRepo(ctx, dir); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			hlog.Errorf("remove dir error: %s", err)
+		}
+		return err
+	}
+	return j.checkout(ctx, dir)
+}
+
+// authEnv http(s) 认证信息通过环境变量中的 git 配置以请求头传入，只对该仓库地址生效，
+// 不写入命令行参数，也不会保存到镜像和工作目录的 remote 地址中。ssh 或匿名访问时返回空
+func (j *JobExec) authEnv() []string {
+	if j.Git.Username == "" || j.Git.Password == "" {
+		return nil
+	}
+	if !strings.HasPrefix(j.Git.Repository, "http://") && !strings.HasPrefix(j.Git.Repository, "https://") {
+		return nil
+	}
+	token := base64.StdEncoding.EncodeToString([]byte(j.Git.Username + ":" + j.Git.Password))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http." + j.Git.Repository + ".extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + token,
+	}
+}
+
+func (j *JobExec) cloneRepo(ctx context.Context, dir string) error {
+	args := []string{"clone", "-b", j.Git.Branch, "--single-branch", "--no-checkout"}
+	if j.Git.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(j.Git.Depth))
+	}
+	if mirror := j.updateMirror(ctx); mirror != "" {
+		args = append(args, "--reference-if-able", mirror)
+	}
+	args = append(args, j.Git.Repository, dir)
+	return j.git(ctx, "", args...)
+}
+
+func (j *JobExec) updateRepo(ctx context.Context, dir string) error {
+	// 同时清除旧版本写入 remote 地址中的账号密码
+	if err := j.git(ctx, dir, "remote", "set-url", "origin", j.Git.Repository); err != nil {
+		return err
+	}
+	// 复用工作目录时同样先更新镜像，后续 fetch 只需要传输镜像里没有的对象
+	j.updateMirror(ctx)
+
+	args := []string{"fetch", "origin", j.Git.Branch}
+	if j.Git.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(j.Git.Depth))
+	}
+	return j.git(ctx, dir, args...)
+}
+
+// checkout 切换到指定 commit，并按流水线配置处理 sparse checkout、清理、子模块和 LFS
+func (j *JobExec) checkout(ctx context.Context, dir string) error {
+	if len(j.Git.SparsePaths) > 0 {
+		args := append([]string{"sparse-checkout", "set"}, j.Git.SparsePaths...)
+		if err := j.git(ctx, dir, args...); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(filepath.Join(dir, ".git", "info", "sparse-checkout")); err == nil {
+		if err := j.git(ctx, dir, "sparse-checkout", "disable"); err != nil {
+			return err
+		}
+	}
+
+	if err := j.git(ctx, dir, "checkout", "-f", j.Git.CommitId); err != nil {
+		if j.Git.Depth == 0 {
+			return err
+		}
+		// 浅克隆可能不包含该 commit，单独拉取后重试
+		if err := j.git(ctx, dir, "fetch", "origin", j.Git.CommitId, "--depth", strconv.Itoa(j.Git.Depth)); err != nil {
+			return err
+		}
+		if err := j.git(ctx, dir, "checkout", "-f", j.Git.CommitId); err != nil {
+			return err
+		}
+	}
+
+	if j.Git.Clean {
+		if err := j.git(ctx, dir, "clean", "-ffdx"); err != nil {
+			return err
+		}
+	}
+
+	if j.Git.Submodules {
+		if err := j.git(ctx, dir, "submodule", "sync", "--recursive"); err != nil {
+			return err
+		}
+		args := []string{"submodule", "update", "--init", "--recursive", "--force"}
+		if j.Git.Depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(j.Git.Depth))
+		}
+		if err := j.git(ctx, dir, args...); err != nil {
+			return err
+		}
+		if j.Git.Clean {
+			if err := j.git(ctx, dir, "submodule", "foreach", "--recursive", "git", "clean", "-ffdx"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.Git.LFS {
+		if err := j.git(ctx, dir, "lfs", "pull"); err != nil {
+			return err
+		}
+	}
+
+	hlog.Infof("git checkout success, dir: %s", dir)
+	return nil
+}
+
+// updateMirror 克隆或更新仓库的裸镜像，失败时只记录日志，返回空字符串表示不使用镜像
+func (j *JobExec) updateMirror(ctx context.Context) string {
+	if !gitMirror {
+		return ""
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		hlog.Warnf("get home dir error: %s", err)
+		return ""
+	}
+	sum := sha256.Sum256([]byte(j.Git.Repository))
+	mirror := filepath.Join(homeDir, ".cicd-runner", "mirrors", hex.EncodeToString(sum[:])[:16]+".git")
+
+	gitMirrorMutex.Lock()
+	lock, ok := gitMirrorLocks[mirror]
+	if !ok {
+		lock = &sync.Mutex{}
+		gitMirrorLocks[mirror] = lock
+	}
+	gitMirrorMutex.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(mirror), os.ModePerm); err != nil {
+			hlog.Warnf("create mirror dir error: %s", err)
+			return ""
+		}
+		if err := j.git(ctx, "", "clone", "--mirror", j.Git.Repository, mirror); err != nil {
+			j.AddLog(fmt.Sprintf("clone mirror error: %s", err))
+			os.RemoveAll(mirror)
+			return ""
+		}
+		return mirror
+	}
+
+	if err := j.git(ctx, mirror, "remote", "set-url", "origin", j.Git.Repository); err != nil {
+		j.AddLog(fmt.Sprintf("update mirror error: %s", err))
+		return ""
+	}
+	if err := j.git(ctx, mirror, "fetch", "--prune", "origin"); err != nil {
+		j.AddLog(fmt.Sprintf("update mirror error: %s", err))
+	}
+	return mirror
+}
+
+// git 执行 git 命令并把输出写入任务日志，dir 为空时在当前目录执行
+func (j *JobExec) git(ctx context.Context, dir string, args ...string) error {
+	subcommand := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	// LFS 文件由 git lfs pull 按需拉取，避免未开启 LFS 时检出阶段下载大文件
+	cmd.Env = append(jobEnv(), "GIT_LFS_SKIP_SMUDGE=1", "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, j.authEnv()...)
+	cmd.SysProcAttr = sysProcAttr()
+	stdout := newLineWriter(j, types.LogStdout, noCommand)
+	stderr := newLineWriter(j, types.LogStderr, noCommand)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := j.runProcessGroup(ctx, cmd)
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("git %s exit code: %d", subcommand, exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package jobexec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 结束进程组时先发送 SIGTERM，超过该时间仍未退出的进程发送 SIGKILL
+var killGrace = 10 * time.Second
+
+func SetKillGrace(grace time.Duration) {
+	killGrace = grace
+}
+
+// 命令退出或进程组被终止后，等待输出读取完成的最长时间。
+// 后台进程或脱离进程组的进程（例如 setsid 启动的守护进程）仍持有输出管道时，超时后不再读取
+const outputWaitDelay = 2 * time.Second
+
+// runProcessGroup 在独立的进程组中执行命令。任务取消时终止整个进程组，避免 sh 启动的子进程继续运行；
+// 命令正常退出时保留有意启动的后台进程（例如 nohup ./server &），只是不再等待它们持有的输出管道
+func (job *JobExec) runProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	output, err := pipeOutput(cmd)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		output.close()
+		return err
+	}
+	output.started()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	pgid := cmd.Process.Pid
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		job.stopGroup(pgid)
+		<-done
+		err = ctx.Err()
+	}
+	output.wait(outputWaitDelay)
+	return err
+}
+
+// stopGroup 向进程组发送 SIGTERM，超过 killGrace 仍有进程未退出时发送 SIGKILL
+func (job *JobExec) stopGroup(pgid int) {
+	if len(groupProcesses(pgid)) == 0 {
+		return
+	}
+	job.killGroup(pgid, syscall.SIGTERM)
+	deadline := time.Now().Add(killGrace)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if len(groupProcesses(pgid)) == 0 {
+			return
+		}
+	}
+	job.killGroup(pgid, syscall.SIGKILL)
+}
+
+// groupOutput 用 os.Pipe 代替 exec 内部创建的管道。Stdout、Stderr 为 *os.File 时，
+// cmd.Wait 只等待命令本身退出，不会被仍持有管道的后台进程阻塞
+type groupOutput struct {
+	readers []*os.File
+	writers []*os.File
+	copied  sync.WaitGroup
+}
+
+func pipeOutput(cmd *exec.Cmd) (*groupOutput, error) {
+	output := &groupOutput{}
+	for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *w == nil {
+			continue
+		}
+		if _, ok := (*w).(*os.File); ok {
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			output.close()
+			return nil, err
+		}
+		output.readers = append(output.readers, pr)
+		output.writers = append(output.writers, pw)
+		output.copied.Add(1)
+		go func(dst io.Writer) {
+			defer output.copied.Done()
+			io.Copy(dst, pr)
+		}(*w)
+		*w = pw
+	}
+	return output, nil
+}
+
+// started 命令启动后关闭父进程持有的写入端，所有子进程退出后读取端才能读到 EOF
+func (o *groupOutput) started() {
+	for _, w := range o.writers {
+		w.Close()
+	}
+}
+
+// wait 等待输出读取完成，超时后关闭读取端
+func (o *groupOutput) wait(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		o.copied.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+	for _, r := range o.readers {
+		r.Close()
+	}
+	<-done
+}
+
+func (o *groupOutput) close() {
+	o.started()
+	o.wait(0)
+}
+
+// killGroup 向进程组发送信号，并在日志中记录收到信号的进程
+func (job *JobExec) killGroup(pgid int, sig syscall.Signal) {
+	procs := groupProcesses(pgid)
+	if len(procs) == 0 {
+		return
+	}
+	job.AddLog(fmt.Sprintf("send %s to process group %d: %s", signalName(sig), pgid, strings.Join(procs, ", ")))
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		job.AddLog(fmt.Sprintf("kill process group %d error: %s", pgid, err))
+	}
+}
+
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGKILL:
+		return "SIGKILL"
+	default:
+		return sig.String()
+	}
+}
+
+// groupProcesses 从 /proc 中查找进程组内的进程，返回 pid(命令名) 列表
+func groupProcesses(pgid int) []string {
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	var procs []string
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// 格式：pid (comm) state ppid pgrp ...，comm 中可能包含空格和括号
+		s := string(data)
+		start, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+		if start < 0 || end < start {
+			continue
+		}
+		fields := strings.Fields(s[end+1:])
+		// 已退出等待回收的僵尸进程不再计入
+		if len(fields) < 3 || fields[2] != strconv.Itoa(pgid) || fields[0] == "Z" {
+			continue
+		}
+		procs = append(procs, fmt.Sprintf("%s(%s)", strings.TrimSpace(s[:start]), s[start+1:end]))
+	}
+	return procs
+}
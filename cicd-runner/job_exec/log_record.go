@@ -0,0 +1,79 @@
+package jobexec
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"cicd-runner/types"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// noCommand 不属于任何命令的日志
+func noCommand() int {
+	return -1
+}
+
+// AddLog 记录 runner 自身输出的日志
+func (j *JobExec) AddLog(log string) {
+	j.addRecord(types.LogRecord{Stream: types.LogSystem, Command: -1, Text: log})
+}
+
+// addRecord 脱敏后补充序号和时间，放入发送队列
+func (j *JobExec) addRecord(record types.LogRecord) {
+	record.Text = j.masker.Mask(record.Text)
+	now := time.Now()
+	record.Time = now.UnixMilli()
+	if !j.startTime.IsZero() {
+		record.Elapsed = now.Sub(j.startTime).Milliseconds()
+	}
+
+	hlog.Infof("add log[%d]: %s", j.JobRunner.ID, record.Text)
+	j.logMutex.Lock()
+	if j.logStream == "" {
+		j.logStream = fmt.Sprintf("%s-%d", name, now.UnixNano())
+	}
+	j.logSeq++
+	record.Seq = j.logSeq
+	logChan <- &types.Log{
+		JobRunnerID: j.JobRunner.ID,
+		Stream:      j.logStream,
+		Record:      record,
+	}
+	j.logMutex.Unlock()
+}
+
+// startSection 开始一条命令的日志段，返回开始时间
+func (j *JobExec) startSection(i int, dir, command string) time.Time {
+	j.addRecord(types.LogRecord{
+		Stream:  types.LogSystem,
+		Command: i,
+		Type:    types.LogSectionStart,
+		Text:    fmt.Sprintf("%s$ %s", dir, command),
+	})
+	return time.Now()
+}
+
+// endSection 结束命令的日志段，记录退出码和耗时。无法得到退出码（例如取消、启动失败）时为 -1
+func (j *JobExec) endSection(i int, start time.Time, err error) {
+	code := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		code = -1
+	}
+	j.endSectionWithCode(i, start, code)
+}
+
+func (j *JobExec) endSectionWithCode(i int, start time.Time, code int) {
+	j.addRecord(types.LogRecord{
+		Stream:   types.LogSystem,
+		Command:  i,
+		Type:     types.LogSectionEnd,
+		ExitCode: &code,
+		Duration: time.Since(start).Milliseconds(),
+	})
+}
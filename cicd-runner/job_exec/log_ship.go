@@ -0,0 +1,312 @@
+package jobexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cicd-runner/types"
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	// 日志攒够行数或字节数，或距上次发送超过间隔时发送一批
+	logBatchLines    = 500
+	logBatchBytes    = 256 << 10
+	logFlushInterval = 300 * time.Millisecond
+
+	retryMinBackoff = time.Second
+	retryMaxBackoff = 30 * time.Second
+)
+
+var (
+	batchChan = make(chan *types.LogBatch, 100)
+	// server 不支持批量接口时退回逐行发送
+	legacyLog bool
+	// 写入 spool 失败的批次保留在内存中，排在 spool 之后重发
+	memorySpool []spooledBatch
+)
+
+type spooledBatch struct {
+	jobRunnerID uint
+	body        []byte
+}
+
+// rejectedError server 明确拒绝的请求，重试也不会成功。
+// 401（签名时间偏差等）、408 和 429 是暂时的，不属于拒绝
+type rejectedError struct {
+	statusCode int
+	body       string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("status code: %d, body: %s", e.statusCode, e.body)
+}
+
+// postRunnerApi 向 server 发送签名请求，encoding 不为空时设置 Content-Encoding
+func postRunnerApi(path string, body []byte, encoding string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	httpReq, _ := http.NewRequest("POST", serverUrl+path, bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		httpReq.Header.Set("Content-Encoding", encoding)
+	}
+	utils.SignRequest(httpReq, name, secret, body)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && !retryableStatus(resp.StatusCode) {
+		return &rejectedError{statusCode: resp.StatusCode, body: string(respBody)}
+	}
+	return fmt.Errorf("status code: %d, body: %s", resp.StatusCode, string(respBody))
+}
+
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// handleLog 把日志按步骤攒成批次交给 shipLogs 发送
+func handleLog() {
+	go shipLogs()
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	var batches []*types.LogBatch
+	size := 0
+	send := func() {
+		for _, batch := range batches {
+			batchChan <- batch
+		}
+		batches, size = nil, 0
+	}
+	for {
+		select {
+		case log := <-logChan:
+			if log == nil {
+				send()
+				batchChan <- nil
+				continue
+			}
+			var batch *types.LogBatch
+			for _, b := range batches {
+				if b.JobRunnerID == log.JobRunnerID && b.Stream == log.Stream {
+					batch = b
+				}
+			}
+			if batch == nil {
+				batch = &types.LogBatch{JobRunnerID: log.JobRunnerID, Stream: log.Stream}
+				batches = append(batches, batch)
+			}
+			batch.Records = append(batch.Records, log.Record)
+			size += len(log.Record.Text)
+			if len(batch.Records) >= logBatchLines || size >= logBatchBytes {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		}
+	}
+}
+
+// shipLogs 发送日志批次。发送失败的批次写入本地 spool 目录，spool 不为空时后续批次也先写入 spool，
+// 按写入顺序退避重试，保证 server 不可用期间日志不丢失且不乱序。写入 spool 失败时保留在内存中
+func shipLogs() {
+	spooled := len(spoolFiles())
+	if spooled > 0 {
+		hlog.Infof("found %d spooled log batches", spooled)
+	}
+	backoff := retryMinBackoff
+	retry := time.NewTimer(backoff)
+	defer retry.Stop()
+
+	for {
+		select {
+		case batch := <-batchChan:
+			if batch == nil {
+				if spooled > 0 {
+					spooled = resendSpool()
+				}
+				flushed <- struct{}{}
+				continue
+			}
+			body, err := encodeLogBatch(batch)
+			if err != nil {
+				hlog.Errorf("encode log batch error: %s", err)
+				continue
+			}
+			if spooled == 0 {
+				err := sendLogBatch(batch.JobRunnerID, body)
+				if err == nil {
+					continue
+				}
+				var rejected *rejectedError
+				if errors.As(err, &rejected) {
+					hlog.Warnf("send log of job runner %d failed, drop %d lines: %s", batch.JobRunnerID, len(batch.Records), err)
+					continue
+				}
+				hlog.Warnf("send log error: %s, spool it", err)
+			}
+			// 已有批次在内存中时不再写入 spool，保证重发顺序
+			if len(memorySpool) > 0 {
+				memorySpool = append(memorySpool, spooledBatch{jobRunnerID: batch.JobRunnerID, body: body})
+			} else if err := spoolLogBatch(batch.JobRunnerID, body); err != nil {
+				hlog.Errorf("spool log batch error: %s, keep it in memory", err)
+				memorySpool = append(memorySpool, spooledBatch{jobRunnerID: batch.JobRunnerID, body: body})
+			}
+			spooled++
+		case <-retry.C:
+			if spooled > 0 {
+				spooled = resendSpool()
+				if spooled > 0 {
+					backoff = min(backoff*2, retryMaxBackoff)
+				} else {
+					backoff = retryMinBackoff
+				}
+			}
+			retry.Reset(backoff)
+		}
+	}
+}
+
+func encodeLogBatch(batch *types.LogBatch) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gw).Encode(batch); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendLogBatch 发送 gzip 压缩的日志批次，server 版本较旧不支持批量接口时逐行发送
+func sendLogBatch(jobRunnerID uint, body []byte) error {
+	if !legacyLog {
+		err := postRunnerApi(fmt.Sprintf("/log_batches/%d", jobRunnerID), body, "gzip")
+		var rejected *rejectedError
+		if !errors.As(err, &rejected) || rejected.statusCode != http.StatusNotFound {
+			return err
+		}
+		hlog.Warn("server does not support log batches, fall back to sending logs line by line")
+		legacyLog = true
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	var batch types.LogBatch
+	if err := json.NewDecoder(gr).Decode(&batch); err != nil {
+		return err
+	}
+	for _, record := range batch.Records {
+		// 旧版本 server 只接收文本，按旧格式输出，命令段的结束标记没有对应的文本
+		if record.Type == types.LogSectionEnd {
+			continue
+		}
+		text := record.Text
+		if text != "" {
+			text = fmt.Sprintf("%s [%s] %s", time.UnixMilli(record.Time).Format("2006/01/02 15:04:05"), name, text)
+		}
+		jsonBytes, _ := json.Marshal(types.Log{Log: text})
+		if err := postRunnerApi(fmt.Sprintf("/logs/%d", jobRunnerID), jsonBytes, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func spoolDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".cicd-runner", "log-spool")
+}
+
+// spoolLogBatch 把批次写入 spool 目录，文件名为 <写入时间>-<job runner id>.json.gz，按名称排序即写入顺序
+func spoolLogBatch(jobRunnerID uint, body []byte) error {
+	dir := spoolDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%020d-%d.json.gz", time.Now().UnixNano(), jobRunnerID))
+	// 先写临时文件再改名，避免 runner 退出时留下不完整的批次
+	if err := os.WriteFile(path+".tmp", body, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func spoolFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(spoolDir(), "*.json.gz"))
+	sort.Strings(files)
+	return files
+}
+
+// resendSpool 按顺序重发 spool 和内存中的批次，遇到失败停止，返回剩余的批次数
+func resendSpool() int {
+	files := spoolFiles()
+	for i, file := range files {
+		jobRunnerID, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file)[21:], ".json.gz"), 10, 64)
+		if err != nil {
+			hlog.Warnf("invalid spooled log batch %s, remove it", file)
+			os.Remove(file)
+			continue
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			hlog.Errorf("read spooled log batch error: %s", err)
+			return len(files) - i + len(memorySpool)
+		}
+		err = sendLogBatch(uint(jobRunnerID), body)
+		var rejected *rejectedError
+		if err != nil && !errors.As(err, &rejected) {
+			hlog.Warnf("resend spooled log batch error: %s, %d batches left", err, len(files)-i)
+			return len(files) - i + len(memorySpool)
+		}
+		if err != nil {
+			hlog.Warnf("spooled log batch %s rejected: %s", file, err)
+		}
+		os.Remove(file)
+	}
+	if len(files) > 0 {
+		hlog.Infof("resend %d spooled log batches success", len(files))
+	}
+
+	for len(memorySpool) > 0 {
+		batch := memorySpool[0]
+		err := sendLogBatch(batch.jobRunnerID, batch.body)
+		var rejected *rejectedError
+		if err != nil && !errors.As(err, &rejected) {
+			hlog.Warnf("resend log batch error: %s, %d batches left in memory", err, len(memorySpool))
+			return len(memorySpool)
+		}
+		if err != nil {
+			hlog.Warnf("log batch of job runner %d rejected: %s", batch.jobRunnerID, err)
+		}
+		memorySpool = memorySpool[1:]
+	}
+	return 0
+}
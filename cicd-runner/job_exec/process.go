@@ -1,22 +1,18 @@
 package jobexec
 
 import (
-	"bufio"
-	"bytes"
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"cicd-runner/types"
+	"cicd-runner/utils"
 
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 )
@@ -25,6 +21,7 @@ var (
 	jobChan       = make(chan *JobExec, 5)
 	serverUrl     string
 	name          string
+	secret        string
 	eventChan     = make(chan *types.Event, 1000)
 	logChan       = make(chan *types.Log, 1000)
 	mutex         sync.Mutex
@@ -33,40 +30,84 @@ var (
 )
 
 type Job struct {
-	ID   uint
-	Tag  string
-	Envs Envs `gorm:"type:json"`
+	ID         uint
+	PipelineID uint
+	Tag        string
+	Envs       Envs `gorm:"type:json"`
 }
 
 type Envs []Env
 
 type Env struct {
-	Key string
-	Val string
+	Key    string
+	Val    string
+	Secret bool
 }
 
 type JobRunner struct {
-	ID       uint
-	Commands []string
+	ID          uint
+	Commands    []string
+	Image       string
+	Mode        string
+	Interpreter string
+	CacheKey    string
+	CachePaths  []string
+	Artifacts   []string
+	// 执行前下载哪些前序步骤的构建产物，填写步骤名称
+	ArtifactDownloads []string
+	CommandOptions    []CommandOption
+	// 命令结束后解析并上传的测试报告，相对工作目录的 glob
+	TestReports []string
 }
 
 type Git struct {
-	ID         uint
-	Repository string
-	Branch     string
-	Username   string
-	Password   string
-	CommitId   string
+	ID          uint
+	Repository  string
+	Branch      string
+	Username    string
+	Password    string
+	CommitId    string
+	Depth       int
+	Submodules  bool
+	LFS         bool
+	SparsePaths []string
+	Clean       bool
 }
 
 type JobExec struct {
 	Job       Job
 	JobRunner JobRunner
 	Git       Git
+
+	env               []string
+	cacheKeyRendered  string
+	toleratedFailures []string
+	// masker 把日志和事件中的 secret 变量、git 凭据替换为 ***
+	masker *utils.Masker
+
+	// 日志序号在 logStream 内递增，server 据此去重和排序
+	logMutex  sync.Mutex
+	logStream string
+	logSeq    uint64
+	// startTime 加入队列的时间，带单调时钟读数，用于计算日志的 Elapsed
+	startTime time.Time
 }
 
-func (j *JobExec) AddJob() {
+func (j *JobExec) AddJob() error {
+	j.masker = utils.NewMasker(j.secrets()...)
+	j.startTime = time.Now()
+
+	drainMutex.Lock()
+	if draining {
+		drainMutex.Unlock()
+		return ErrDraining
+	}
+	running.Add(1)
+	active++
+	drainMutex.Unlock()
+
 	jobChan <- j
+	return nil
 }
 
 func CancelJob(jobRunnerID uint) {
@@ -81,21 +122,23 @@ func CancelJob(jobRunnerID uint) {
 	}
 }
 
-func (j *JobExec) AddLog(log string) {
-	if log != "" {
-		log = fmt.Sprintf("%s [%s] %s", time.Now().Format("2006/01/02 15:04:05"), name, log)
+// secrets 需要在日志中隐藏的值
+func (j *JobExec) secrets() []string {
+	var secrets []string
+	for _, env := range j.Job.Envs {
+		if env.Secret {
+			secrets = append(secrets, env.Val)
+		}
 	}
-
-	hlog.Infof("add log[%d]: %s", j.JobRunner.ID, log)
-	logChan <- &types.Log{
-		JobRunnerID: j.JobRunner.ID,
-		Log:         log,
+	if j.Git.Password != "" {
+		secrets = append(secrets, j.Git.Password, j.Git.Username+":"+j.Git.Password)
 	}
+	return secrets
 }
 
 func (j *JobExec) AddEvent(success bool, message string) {
 	if message != "" {
-		message = fmt.Sprintf("[%s] %s", name, message)
+		message = fmt.Sprintf("[%s] %s", name, j.masker.Mask(message))
 	}
 	eventChan <- &types.Event{
 		JobRunnerID: j.JobRunner.ID,
@@ -104,10 +147,11 @@ func (j *JobExec) AddEvent(success bool, message string) {
 	}
 }
 
-func Run(n, su string) {
+func Run(n, su, s string) {
 	hlog.Infof("start job exec")
 	name = n
 	serverUrl = su
+	secret = s
 
 	go handleEvent()
 	go handleLog()
@@ -118,6 +162,8 @@ func Run(n, su string) {
 }
 
 func (job *JobExec) Exec() {
+	defer jobDone()
+
 	mutex.Lock()
 	ctx, cancel := context.WithCancel(context.Background())
 	jobCancelFunc[job.JobRunner.ID] = cancel
@@ -131,26 +177,26 @@ func (job *JobExec) RunCommand(ctx context.Context) {
 		hlog.Info("job channel closed")
 		return
 	}
-	hlog.Infof("start job: %+v", job)
+	hlog.Infof("start job: %d, tag: %s", job.JobRunner.ID, job.Job.Tag)
 
-	var dir string
-	if job.Git.ID > 0 {
-		if job.Git.CommitId == "" {
-			job.AddEvent(false, "commit id is empty")
-			job.AddLog("commit id is empty")
-			return
-		}
+	if job.Git.ID > 0 && job.Git.CommitId == "" {
+		job.AddEvent(false, "commit id is empty")
+		job.AddLog("commit id is empty")
+		return
+	}
 
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			hlog.Errorf("get home dir error: %s", err)
-			job.AddEvent(false, err.Error())
-			job.AddLog(err.Error())
-			return
-		}
-		dir = filepath.Join(homeDir, ".cicd-runner", "repos", fmt.Sprintf("%d", job.Git.ID))
+	dir, err := job.acquireWorkspace()
+	if err != nil {
+		hlog.Errorf("acquire workspace error: %s", err)
+		job.AddEvent(false, err.Error())
+		job.AddLog(err.Error())
+		return
+	}
+	defer job.releaseWorkspace(dir)
+	job.AddLog(fmt.Sprintf("workspace: %s", dir))
 
-		if err = job.GitCloneOrPull(dir); err != nil {
+	if job.Git.ID > 0 {
+		if err = job.GitCloneOrPull(ctx, dir); err != nil {
 			hlog.Errorf("git clone or pull error: %s", err)
 			job.AddEvent(false, err.Error())
 			job.AddLog(err.Error())
@@ -164,42 +210,71 @@ func (job *JobExec) RunCommand(ctx context.Context) {
 		Key: "VERSION",
 		Val: job.Job.Tag,
 	})
+	// 每个任务使用独立的进程环境变量，只继承 runner 白名单中的变量
+	job.env = jobEnv()
 	for _, env := range job.Job.Envs {
-		job.AddLog(fmt.Sprintf("set env: %s=%s", env.Key, env.Val))
-		if err := os.Setenv(env.Key, env.Val); err != nil {
-			hlog.Errorf("set env error: %s", err)
-			job.AddEvent(false, err.Error())
-			job.AddLog(err.Error())
-			return
+		if env.Secret {
+			job.AddLog(fmt.Sprintf("set env: %s=***", env.Key))
+		} else {
+			job.AddLog(fmt.Sprintf("set env: %s=%s", env.Key, env.Val))
 		}
+		job.env = append(job.env, env.Key+"="+env.Val)
+	}
+
+	executor := job.executor()
+	if executor.Name() != "shell" {
+		job.AddLog(fmt.Sprintf("executor: %s", executor.Name()))
+	}
+
+	job.restoreCache(dir)
+
+	if err := job.downloadArtifacts(dir); err != nil {
+		job.AddEvent(false, err.Error())
+		job.AddLog(err.Error())
+		return
+	}
+	if err := sandboxChownAll(dir); err != nil {
+		job.AddEvent(false, err.Error())
+		job.AddLog(err.Error())
+		return
 	}
 
 	succeed := true
 	defer func() {
+		job.uploadTestReports(dir)
 		if succeed {
-			job.AddEvent(true, "")
-			job.AddLog("This step was executed successfully.")
+			job.saveCache(dir)
+			if err := job.uploadArtifacts(dir); err != nil {
+				job.AddEvent(false, err.Error())
+				job.AddLog(err.Error())
+				succeed = false
+			}
 		}
-
-		// 清除环境变量
-		for _, env := range job.Job.Envs {
-			if err := os.Unsetenv(env.Key); err != nil {
-				hlog.Errorf("unset env error: %s", err)
+		if succeed {
+			var message string
+			if len(job.toleratedFailures) > 0 {
+				message = "tolerated failures: " + strings.Join(job.toleratedFailures, "; ")
 			}
+			job.AddEvent(true, message)
+			job.AddLog("This step was executed successfully.")
 		}
 
 		mutex.Lock()
 		delete(jobCancelFunc, job.JobRunner.ID)
 		mutex.Unlock()
 	}()
-	for _, command := range job.JobRunner.Commands {
+	if job.JobRunner.Mode == ModeScript {
+		succeed = job.script(ctx, executor, dir)
+		return
+	}
+	for i, command := range job.JobRunner.Commands {
 		select {
 		case <-ctx.Done():
 			job.AddEvent(false, "job interrupted")
 			job.AddLog("job interrupted")
 			return
 		default:
-			succeed = job.command(ctx, dir, command)
+			succeed = job.command(ctx, executor, dir, i, command)
 			if !succeed {
 				return
 			}
@@ -208,42 +283,22 @@ func (job *JobExec) RunCommand(ctx context.Context) {
 
 }
 
-func (job *JobExec) command(ctx context.Context, dir, command string) bool {
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	cmd.Dir = dir
-	hlog.Infof("run command: %s", command)
-
-	job.AddLog(fmt.Sprintf("%s$ %s", cmp.Or(dir, "~"), command))
-	// 创建管道获取标准输出
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		hlog.Errorf("cmd stdout pipe error: %s", err)
-	}
-
-	// 创建管道获取标准错误
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		hlog.Errorf("cmd stderr pipe error: %s", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		hlog.Errorf("cmd start error: %s", err)
-	}
-
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			job.AddLog(scanner.Text())
-		}
-	}()
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			job.AddLog(scanner.Text())
-		}
-	}()
+func (job *JobExec) command(ctx context.Context, executor Executor, dir string, i int, command string) bool {
+	hlog.Infof("run command with %s: %s", executor.Name(), command)
+
+	start := job.startSection(i, cmp.Or(dir, "~"), command)
+	current := func() int { return i }
+	stdout := newLineWriter(job, types.LogStdout, current)
+	stderr := newLineWriter(job, types.LogStderr, current)
+	err := executor.Run(ctx, job, dir, command, stdout, stderr)
+	stdout.Close()
+	stderr.Close()
+	job.endSection(i, start, err)
+	return job.result(job.tolerate(i, err))
+}
 
-	err = cmd.Wait()
+// result 把命令执行结果写入日志和事件，返回是否成功
+func (job *JobExec) result(err error) bool {
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			hlog.Errorf("exit code: %v", exitErr)
@@ -263,226 +318,29 @@ func (job *JobExec) command(ctx context.Context, dir, command string) bool {
 
 func handleEvent() {
 	for event := range eventChan {
+		if event == nil {
+			flushed <- struct{}{}
+			continue
+		}
 		sendEvent(event)
 	}
 }
 
+// sendEvent 发送失败时按退避时间重试，直到成功或 server 拒绝该事件
 func sendEvent(event *types.Event) {
-	client := &http.Client{}
 	jsonBytes, _ := json.Marshal(event)
-	httpReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/events/%d", serverUrl, event.JobRunnerID), bytes.NewReader(jsonBytes))
-	httpReq.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		hlog.Warnf("send event error: %s", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		hlog.Warnf("send event failed, status code: %d", resp.StatusCode)
-	}
-	hlog.Info("send event success")
-}
-
-func handleLog() {
-	for log := range logChan {
-		sendLog(log)
-	}
-}
-
-func sendLog(log *types.Log) {
-	client := &http.Client{}
-	jsonBytes, _ := json.Marshal(log)
-	httpReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/logs/%d", serverUrl, log.JobRunnerID), bytes.NewReader(jsonBytes))
-	httpReq.Header.Set("Content-Type", "application/json")
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		hlog.Warnf("send log error: %s", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			hlog.Warnf("send log error: %s", err)
-		}
-
-		hlog.Warnf("send log failed, status code: %d, body: %s", resp.StatusCode, string(body))
-	}
-	hlog.Info("send log success")
-}
-
-func (j *JobExec) GitCloneOrPull(dir string) error {
-	var err error
-	var clone bool
-
-	_, err = os.Stat(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			clone = true
-			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	}
-
-	if clone {
-		if err = j.cloneRepo(dir); err != nil {
-			return handleExitError(err, dir)
-		}
-	} else {
-		// 获取远程分支
-		if err := j.fetchBranch(dir); err != nil {
-			return err
-		}
-
-		// 切换到远程分支
-		if err = j.checkoutBranch(dir); err != nil {
-			return err
-		}
-
-		// 拉取远程分支
-		if err = j.pullRepo(dir); err != nil {
-			return handleExitError(err, dir)
-		}
-	}
-
-	// 切换到commit id
-	if err = j.checkoutCommit(dir); err != nil {
-		return err
-	}
-
-	hlog.Infof("git clone or pull success, dir: %s", dir)
-	return nil
-}
-
-func (j *JobExec) cloneRepo(dir string) error {
-	var cmd *exec.Cmd
-	if j.Git.Username != "" && j.Git.Password != "" {
-		// http(s) 认证
-		authUrl := strings.Replace(j.Git.Repository, "://", fmt.Sprintf("://%s:%s@", j.Git.Username, j.Git.Password), 1)
-		cmd = exec.Command("git", "clone", "-b", j.Git.Branch, "--single-branch", authUrl, dir)
-	} else {
-		// ssh 或匿名
-		cmd = exec.Command("git", "clone", "-b", j.Git.Branch, "--single-branch", j.Git.Repository, dir)
-	}
-
-	// 创建管道获取标准输出
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-
-	// 创建管道获取标准错误
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			j.AddLog(scanner.Text())
-		}
-	}()
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			j.AddLog(scanner.Text())
-		}
-	}()
-
-	err = cmd.Wait()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (j *JobExec) pullRepo(dir string) error {
-	cmd := exec.Command("git", "-C", dir, "pull")
-
-	// 创建管道获取标准输出
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-
-	// 创建管道获取标准错误
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			j.AddLog(scanner.Text())
-		}
-	}()
-	go func() {
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			j.AddLog(scanner.Text())
+	for backoff := retryMinBackoff; ; backoff = min(backoff*2, retryMaxBackoff) {
+		err := postRunnerApi(fmt.Sprintf("/events/%d", event.JobRunnerID), jsonBytes, "")
+		if err == nil {
+			hlog.Info("send event success")
+			return
 		}
-	}()
-
-	err = cmd.Wait()
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (j *JobExec) fetchBranch(dir string) error {
-	cmd := exec.Command("git", "-C", dir, "fetch")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return handleExitError(err, dir)
-	}
-	j.AddLog(string(out))
-	return nil
-}
-
-func (j *JobExec) checkoutBranch(dir string) error {
-	cmd := exec.Command("git", "-C", dir, "checkout", j.Git.Branch)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		hlog.Errorf("checkout branch error: %s", err)
-		return handleExitError(err, dir)
-	}
-	j.AddLog(string(out))
-	return nil
-}
-
-func (j *JobExec) checkoutCommit(dir string) error {
-	cmd := exec.Command("git", "-C", dir, "checkout", j.Git.CommitId)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		hlog.Errorf("checkout commit error: %s", err)
-		return handleExitError(err, dir)
-	}
-	j.AddLog(string(out))
-	return nil
-}
-
-func handleExitError(err error, dir string) error {
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		hlog.Infof("remove dir: %s", dir)
-		if err := os.RemoveAll(dir); err != nil {
-			hlog.Errorf("remove dir error: %s", err)
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			hlog.Warnf("send event failed: %s", err)
+			return
 		}
-
-		return fmt.Errorf("exit code: %d, error: %s", exitErr.ExitCode(), string(exitErr.Stderr))
+		hlog.Warnf("send event error: %s, retry in %s", err, backoff)
+		time.Sleep(backoff)
 	}
-	return err
 }
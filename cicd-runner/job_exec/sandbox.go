@@ -0,0 +1,183 @@
+package jobexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+var (
+	// sandboxCred 不为空时，任务命令以该用户身份执行
+	sandboxCred *syscall.Credential
+	sandboxUser string
+	// 任务使用的 HOME，与 runner 自身的 HOME 隔离
+	sandboxHome string
+	// 允许从 runner 进程传递给任务的环境变量
+	passEnv = []string{"PATH", "LANG", "LC_ALL", "TZ", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"}
+)
+
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// SetSandbox 设置执行任务的用户，username 为空时使用 runner 自身的用户。
+// 任务最终以 root 身份执行时，除非 allowRoot，否则拒绝启动。
+func SetSandbox(username string, allowRoot bool, extraEnv []string) error {
+	passEnv = append(passEnv, extraEnv...)
+
+	if username == "" {
+		if os.Geteuid() == 0 && !allowRoot {
+			return errors.New("refuse to run jobs as root, set --run-as to an unprivileged user or pass --allow-root")
+		}
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		sandboxHome = homeDir
+		return nil
+	}
+
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	if uid == 0 && !allowRoot {
+		return fmt.Errorf("refuse to run jobs as root user %s, pass --allow-root to allow it", username)
+	}
+	if uid != uint64(os.Geteuid()) && os.Geteuid() != 0 {
+		return fmt.Errorf("runner must be started as root to run jobs as %s", username)
+	}
+
+	var groups []uint32
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+	sandboxCred = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+	sandboxUser = username
+
+	// 工作目录、镜像目录和 HOME 都需要归属任务用户
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	root := filepath.Join(homeDir, ".cicd-runner")
+	sandboxHome = filepath.Join(root, "home", username)
+	for _, dir := range []string{sandboxHome, filepath.Join(root, "workspaces"), filepath.Join(root, "mirrors")} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := sandboxChown(dir); err != nil {
+			return err
+		}
+		if err := checkTraversable(filepath.Dir(dir)); err != nil {
+			return err
+		}
+	}
+
+	hlog.Infof("run jobs as user %s(%d:%d), home: %s", username, uid, gid, sandboxHome)
+	return nil
+}
+
+// checkTraversable 检查任务用户能否进入 dir 及其所有上级目录
+func checkTraversable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		mode := info.Mode().Perm()
+		switch {
+		case mode&0001 != 0:
+		case ok && stat.Uid == sandboxCred.Uid && mode&0100 != 0:
+		case ok && stat.Gid == sandboxCred.Gid && mode&0010 != 0:
+		default:
+			return fmt.Errorf("%s is not accessible by user %s, grant it execute permission (chmod o+x) or move the runner home", dir, sandboxUser)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// jobEnv 任务的基础环境变量，只保留白名单中的 runner 变量
+func jobEnv() []string {
+	env := []string{"HOME=" + sandboxHome, "CI=true"}
+	if sandboxUser != "" {
+		env = append(env, "USER="+sandboxUser, "LOGNAME="+sandboxUser)
+	} else if u, err := user.Current(); err == nil {
+		env = append(env, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+	if _, ok := os.LookupEnv("PATH"); !ok {
+		env = append(env, "PATH="+defaultPath)
+	}
+	for _, key := range passEnv {
+		if val, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+val)
+		}
+	}
+	return env
+}
+
+// getenv 从任务的环境变量中查找，后设置的值优先
+func (job *JobExec) getenv(key string) string {
+	for i := len(job.env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(job.env[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// sysProcAttr 任务进程的属性，启用任务用户时切换到该用户
+func sysProcAttr() *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{}
+	if sandboxCred != nil {
+		attr.Credential = sandboxCred
+	}
+	return attr
+}
+
+// sandboxChown 把 runner 自身创建的文件交给任务用户
+func sandboxChown(path string) error {
+	if sandboxCred == nil {
+		return nil
+	}
+	return os.Lchown(path, int(sandboxCred.Uid), int(sandboxCred.Gid))
+}
+
+// sandboxChownAll 修正工作目录中不属于任务用户的文件，例如恢复的缓存和下载的构建产物
+func sandboxChownAll(dir string) error {
+	if sandboxCred == nil {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid == sandboxCred.Uid && stat.Gid == sandboxCred.Gid {
+			return nil
+		}
+		return os.Lchown(path, int(sandboxCred.Uid), int(sandboxCred.Gid))
+	})
+}
@@ -0,0 +1,179 @@
+package jobexec
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cicd-runner/types"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+const (
+	// ModeCommand 每条命令单独使用 sh -c 执行
+	ModeCommand = "command"
+	// ModeScript 所有命令写入同一个脚本，由指定解释器执行，cd、export 和函数在命令之间保留
+	ModeScript = "script"
+
+	defaultInterpreter = "bash -euo pipefail"
+	// 脚本在每条命令前输出该标记，用于把输出归属到对应命令
+	commandMarker = "::cicd-command::"
+)
+
+// script 以脚本模式执行步骤的全部命令
+func (job *JobExec) script(ctx context.Context, executor Executor, dir string) bool {
+	interpreter := cmp.Or(strings.TrimSpace(job.JobRunner.Interpreter), defaultInterpreter)
+
+	// 脚本写在 runner 自己的目录中，任务无法通过工作目录中的符号链接让 runner 写入其它文件
+	file, err := writeScript(job.JobRunner.ID, job.buildScript(interpreter))
+	if err != nil {
+		return job.result(err)
+	}
+	defer os.Remove(file)
+	name := file
+	if container, ok := executor.(*ContainerExecutor); ok {
+		name = container.mount(file, containerScript)
+	}
+
+	hlog.Infof("run script with %s: %s %s", executor.Name(), interpreter, name)
+	job.AddLog(fmt.Sprintf("run %d commands as script with: %s", len(job.JobRunner.Commands), interpreter))
+
+	// 每条命令对应一个日志段，遇到下一条命令的标记时结束上一段，
+	// 前一条命令能继续执行说明其退出码为 0 或者被容忍
+	var current atomic.Int32
+	current.Store(-1)
+	var start time.Time
+	code := 0
+	command := func() int { return int(current.Load()) }
+	stdout := newLineWriter(job, types.LogStdout, command)
+	stdout.onLine = func(line string) bool {
+		if c, ok := job.parseTolerated(line); ok {
+			code = c
+			return true
+		}
+		if !strings.HasPrefix(line, commandMarker) {
+			return false
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(line, commandMarker))
+		if err != nil || i < 0 || i >= len(job.JobRunner.Commands) {
+			return false
+		}
+		if prev := current.Load(); prev >= 0 {
+			job.endSectionWithCode(int(prev), start, code)
+		}
+		current.Store(int32(i))
+		code = 0
+		start = job.startSection(i, dir, job.JobRunner.Commands[i])
+		return true
+	}
+	stderr := newLineWriter(job, types.LogStderr, command)
+	err = executor.Run(ctx, job, dir, scriptCommand(interpreter)+" "+name, stdout, stderr)
+	stdout.Close()
+	stderr.Close()
+
+	if i := current.Load(); i >= 0 {
+		if err != nil {
+			job.endSection(int(i), start, err)
+			job.AddLog(fmt.Sprintf("script failed at command: %s", job.JobRunner.Commands[i]))
+		} else {
+			job.endSectionWithCode(int(i), start, code)
+		}
+	}
+	return job.result(err)
+}
+
+func scriptRoot() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".cicd-runner", "scripts"), nil
+}
+
+// writeScript 把脚本写入 runner 的脚本目录并交给任务用户。目录只有 runner 可以写入，
+// 任务用户只能读取自己的脚本
+func writeScript(id uint, content string) (string, error) {
+	root, err := scriptRoot()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(root, 0711); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(root, 0711); err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(root, fmt.Sprintf("script-%d", id))
+	// 清理上次异常退出时遗留的脚本
+	os.Remove(file)
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return "", err
+	}
+	if sandboxCred != nil {
+		if err := f.Chown(int(sandboxCred.Uid), int(sandboxCred.Gid)); err != nil {
+			f.Close()
+			os.Remove(file)
+			return "", err
+		}
+	}
+	_, err = f.WriteString(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", err
+	}
+	return file, nil
+}
+
+func isPython(interpreter string) bool {
+	return strings.HasPrefix(path.Base(strings.Fields(interpreter)[0]), "python")
+}
+
+// scriptCommand 执行脚本的命令。python 输出到管道时会缓冲 stdout，
+// 需要加上 -u，否则命令输出会晚于后续命令的标记，被归属到错误的命令
+func scriptCommand(interpreter string) string {
+	fields := strings.Fields(interpreter)
+	if !isPython(interpreter) || slices.Contains(fields, "-u") {
+		return interpreter
+	}
+	return strings.Join(append([]string{fields[0], "-u"}, fields[1:]...), " ")
+}
+
+// buildScript 在每条命令前插入输出标记的语句，shell 解释器下为配置了容忍选项的命令生成判断退出码的代码
+func (job *JobExec) buildScript(interpreter string) string {
+	python := isPython(interpreter)
+
+	var b strings.Builder
+	for i, command := range job.JobRunner.Commands {
+		opt, tolerate := job.commandOption(i)
+		if python {
+			fmt.Fprintf(&b, "print(%q, flush=True)\n", commandMarker+strconv.Itoa(i))
+			if tolerate {
+				job.AddLog(fmt.Sprintf("continue_on_error and success_exit_codes are not supported by %s, ignored: %s", interpreter, command))
+			}
+		} else {
+			fmt.Fprintf(&b, "echo '%s%d'\n", commandMarker, i)
+		}
+		if tolerate && !python {
+			b.WriteString(tolerateScript(i, command, opt))
+			continue
+		}
+		b.WriteString(command)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package jobexec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"cicd-runner/types"
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// ReportMetrics 定期上报 CPU、内存、工作目录剩余磁盘和负载
+func ReportMetrics(interval time.Duration) {
+	prevIdle, prevTotal, _ := readCPU()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		metrics := types.Metrics{CPUs: runtime.NumCPU()}
+
+		idle, total, err := readCPU()
+		if err != nil {
+			hlog.Warnf("read cpu error: %s", err)
+		} else if total > prevTotal {
+			metrics.CPUPercent = 100 * (1 - float64(idle-prevIdle)/float64(total-prevTotal))
+			prevIdle, prevTotal = idle, total
+		}
+
+		if metrics.MemTotal, metrics.MemAvailable, err = readMemory(); err != nil {
+			hlog.Warnf("read memory error: %s", err)
+		}
+		if metrics.Load1, metrics.Load5, metrics.Load15, err = readLoad(); err != nil {
+			hlog.Warnf("read load error: %s", err)
+		}
+		if metrics.DiskFree, err = workspaceDiskFree(); err != nil {
+			hlog.Warnf("read disk free error: %s", err)
+		}
+
+		sendMetrics(&metrics)
+	}
+}
+
+// readCPU 读取 /proc/stat 中累计的空闲和总 CPU 时间
+func readCPU() (idle, total uint64, err error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return 0, 0, fmt.Errorf("empty /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += v
+		// idle 和 iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return idle, total, nil
+}
+
+func readMemory() (total, available uint64, err error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = v * 1024
+		case "MemAvailable:":
+			available = v * 1024
+		}
+	}
+	return total, available, scanner.Err()
+}
+
+func readLoad() (load1, load5, load15 float64, err error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	if load1, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return
+	}
+	if load5, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return
+	}
+	load15, err = strconv.ParseFloat(fields[2], 64)
+	return
+}
+
+func workspaceDiskFree() (uint64, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Join(homeDir, ".cicd-runner")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func sendMetrics(metrics *types.Metrics) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	jsonBytes, _ := json.Marshal(metrics)
+	httpReq, _ := http.NewRequest("POST", fmt.Sprintf("%s/runner_metrics", serverUrl), bytes.NewReader(jsonBytes))
+	httpReq.Header.Set("Content-Type", "application/json")
+	utils.SignRequest(httpReq, name, secret, jsonBytes)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		hlog.Warnf("send metrics error: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		hlog.Warnf("send metrics failed, status code: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,283 @@
+package jobexec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"cicd-runner/types"
+)
+
+const (
+	// 单个测试报告文件的大小上限
+	testReportMaxSize = 64 << 20
+	// 失败信息只保留开头部分
+	testMessageMaxSize = 16 << 10
+)
+
+// uploadTestReports 命令结束后（无论成功与否）解析并上传测试报告，失败只记录日志，不影响步骤结果
+func (job *JobExec) uploadTestReports(dir string) {
+	if len(job.JobRunner.TestReports) == 0 {
+		return
+	}
+
+	for _, pattern := range job.JobRunner.TestReports {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			job.AddLog(fmt.Sprintf("invalid test report pattern %s: %s", pattern, err))
+			continue
+		}
+		if len(matches) == 0 {
+			job.AddLog(fmt.Sprintf("no test report matches: %s", pattern))
+		}
+		for _, file := range matches {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				job.AddLog(fmt.Sprintf("test report %s is outside of workspace", file))
+				continue
+			}
+			if err := checkParents(dir, file); err != nil {
+				job.AddLog(fmt.Sprintf("test report %s error: %s", rel, err))
+				continue
+			}
+			report, err := parseTestReport(file)
+			if err != nil {
+				job.AddLog(fmt.Sprintf("parse test report %s error: %s", rel, err))
+				continue
+			}
+			report.JobRunnerID = job.JobRunner.ID
+			report.File = filepath.ToSlash(rel)
+			for i := range report.Cases {
+				report.Cases[i].Message = job.masker.Mask(report.Cases[i].Message)
+			}
+			if err := sendTestReport(report); err != nil {
+				job.AddLog(fmt.Sprintf("upload test report %s error: %s", rel, err))
+				continue
+			}
+			job.AddLog(fmt.Sprintf("test report uploaded: %s, %s", rel, summarizeTests(report.Cases)))
+		}
+	}
+}
+
+func summarizeTests(cases []types.TestCase) string {
+	count := make(map[string]int)
+	for _, c := range cases {
+		count[c.Status]++
+	}
+	return fmt.Sprintf("%d tests, %d passed, %d failed, %d skipped", len(cases), count[types.TestPassed], count[types.TestFailed], count[types.TestSkipped])
+}
+
+func sendTestReport(report *types.TestReport) error {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gw).Encode(report); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return postRunnerApi(fmt.Sprintf("/test_reports/%d", report.JobRunnerID), buf.Bytes(), "gzip")
+}
+
+// parseTestReport 根据内容识别报告格式：XML 为 JUnit，否则按 go test -json 的输出解析。
+// runner 可能以 root 身份运行，报告本身是符号链接时不读取
+func parseTestReport(file string) (*types.TestReport, error) {
+	// O_NONBLOCK 避免打开任务创建的命名管道时阻塞
+	f, err := os.OpenFile(file, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	if info.Size() > testReportMaxSize {
+		return nil, fmt.Errorf("file is larger than %d MB", testReportMaxSize>>20)
+	}
+	data, err := io.ReadAll(io.LimitReader(f, testReportMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	var cases []types.TestCase
+	var format string
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		format = "junit"
+		cases, err = parseJUnit(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		format = "gotest"
+		cases, err = parseGoTest(trimmed)
+	default:
+		err = errors.New("unknown format, expect JUnit XML or go test -json output")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &types.TestReport{Format: format, Cases: cases}, nil
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (m *junitMessage) String() string {
+	return strings.TrimSpace(m.Message + "\n" + strings.TrimSpace(m.Text))
+}
+
+// parseJUnit 解析 JUnit XML，根节点可以是 testsuites 或 testsuite，testsuite 可以嵌套
+func parseJUnit(data []byte) ([]types.TestCase, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	var cases []types.TestCase
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			tc := types.TestCase{
+				Suite:    c.Classname,
+				Name:     c.Name,
+				Status:   types.TestPassed,
+				Duration: parseSeconds(c.Time),
+			}
+			if tc.Suite == "" {
+				tc.Suite = suite.Name
+			}
+			switch {
+			case c.Failure != nil:
+				tc.Status, tc.Message = types.TestFailed, c.Failure.String()
+			case c.Error != nil:
+				tc.Status, tc.Message = types.TestFailed, c.Error.String()
+			case c.Skipped != nil:
+				tc.Status, tc.Message = types.TestSkipped, c.Skipped.String()
+			}
+			if tc.Status == types.TestFailed && strings.TrimSpace(c.SystemOut) != "" {
+				tc.Message += "\n" + strings.TrimSpace(c.SystemOut)
+			}
+			tc.Message = truncateMessage(tc.Message)
+			cases = append(cases, tc)
+		}
+		for _, s := range suite.Suites {
+			walk(s)
+		}
+	}
+	walk(root)
+	return cases, nil
+}
+
+// parseSeconds 把 JUnit 中的秒数（可能带千分位）转换为毫秒
+func parseSeconds(s string) int64 {
+	seconds, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return 0
+	}
+	return int64(seconds * 1000)
+}
+
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseGoTest 解析 go test -json 的输出，包失败但没有失败的测试（例如编译失败）时记录为包级别的失败
+func parseGoTest(data []byte) ([]types.TestCase, error) {
+	var cases []types.TestCase
+	output := make(map[string]*strings.Builder)
+	failedTests := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), testReportMaxSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte("{")) {
+			continue
+		}
+		var event goTestEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, err
+		}
+		key := event.Package + "\x00" + event.Test
+		switch event.Action {
+		case "output":
+			b, ok := output[key]
+			if !ok {
+				b = &strings.Builder{}
+				output[key] = b
+			}
+			if b.Len() < testMessageMaxSize {
+				b.WriteString(event.Output)
+			}
+		case "pass", "fail", "skip":
+			status := map[string]string{"pass": types.TestPassed, "fail": types.TestFailed, "skip": types.TestSkipped}[event.Action]
+			var message string
+			if b, ok := output[key]; ok && status != types.TestPassed {
+				message = truncateMessage(strings.TrimSpace(b.String()))
+			}
+			delete(output, key)
+			if event.Test == "" {
+				if status == types.TestFailed && !failedTests[event.Package] {
+					cases = append(cases, types.TestCase{Suite: event.Package, Name: "(package)", Status: status, Duration: int64(event.Elapsed * 1000), Message: message})
+				}
+				continue
+			}
+			if status == types.TestFailed {
+				failedTests[event.Package] = true
+			}
+			cases = append(cases, types.TestCase{
+				Suite:    event.Package,
+				Name:     event.Test,
+				Status:   status,
+				Duration: int64(event.Elapsed * 1000),
+				Message:  message,
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+func truncateMessage(message string) string {
+	if len(message) <= testMessageMaxSize {
+		return message
+	}
+	return strings.ToValidUTF8(message[:testMessageMaxSize], "") + "\n... (truncated)"
+}
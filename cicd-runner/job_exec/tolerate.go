@@ -0,0 +1,93 @@
+package jobexec
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CommandOption 按下标对应 JobRunner.Commands 中的命令
+type CommandOption struct {
+	ContinueOnError  bool
+	SuccessExitCodes []int
+}
+
+// 脚本模式下被容忍的命令输出该标记，格式为 ::cicd-tolerated::<命令下标>:<退出码>
+const toleratedMarker = "::cicd-tolerated::"
+
+func (job *JobExec) commandOption(i int) (CommandOption, bool) {
+	if i < 0 || i >= len(job.JobRunner.CommandOptions) {
+		return CommandOption{}, false
+	}
+	opt := job.JobRunner.CommandOptions[i]
+	return opt, opt.ContinueOnError || len(opt.SuccessExitCodes) > 0
+}
+
+// tolerate 按命令配置忽略退出码错误，返回 nil 表示继续执行后续命令
+func (job *JobExec) tolerate(i int, err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		// 取消、启动失败等不是命令本身的失败，不容忍
+		return err
+	}
+	opt, ok := job.commandOption(i)
+	if !ok {
+		return err
+	}
+	if !opt.ContinueOnError && !slices.Contains(opt.SuccessExitCodes, exitErr.ExitCode()) {
+		return err
+	}
+	job.tolerated(i, exitErr.ExitCode())
+	return nil
+}
+
+// tolerated 记录被容忍的失败，步骤成功时写入事件消息
+func (job *JobExec) tolerated(i, code int) {
+	opt, _ := job.commandOption(i)
+	if slices.Contains(opt.SuccessExitCodes, code) {
+		job.AddLog(fmt.Sprintf("exit code %d is treated as success", code))
+		return
+	}
+	job.AddLog(fmt.Sprintf("exit code: %d, continue on error", code))
+	job.toleratedFailures = append(job.toleratedFailures, fmt.Sprintf("%s (exit code %d)", job.JobRunner.Commands[i], code))
+}
+
+// parseTolerated 解析脚本输出的容忍标记，返回被容忍的退出码
+func (job *JobExec) parseTolerated(line string) (int, bool) {
+	if !strings.HasPrefix(line, toleratedMarker) {
+		return 0, false
+	}
+	index, code, ok := strings.Cut(strings.TrimPrefix(line, toleratedMarker), ":")
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(job.JobRunner.Commands) {
+		return 0, false
+	}
+	c, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, false
+	}
+	job.tolerated(i, c)
+	return c, true
+}
+
+// tolerateScript 为配置了容忍选项的命令生成 shell 代码，失败时按配置退出或输出容忍标记继续执行
+func tolerateScript(i int, command string, opt CommandOption) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "__cicd_rc=0\n{\n%s\n} || __cicd_rc=$?\n", command)
+	b.WriteString("if [ \"$__cicd_rc\" -ne 0 ]; then\n")
+	if !opt.ContinueOnError {
+		codes := make([]string, 0, len(opt.SuccessExitCodes))
+		for _, code := range opt.SuccessExitCodes {
+			codes = append(codes, strconv.Itoa(code))
+		}
+		fmt.Fprintf(&b, "case \" %s \" in *\" $__cicd_rc \"*) ;; *) exit \"$__cicd_rc\";; esac\n", strings.Join(codes, " "))
+	}
+	fmt.Fprintf(&b, "echo \"%s%d:$__cicd_rc\"\n", toleratedMarker, i)
+	b.WriteString("fi\n")
+	return b.String()
+}
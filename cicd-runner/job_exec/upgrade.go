@@ -0,0 +1,130 @@
+package jobexec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cicd-runner/types"
+	"cicd-runner/utils"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// 等待 runner 空闲的检查间隔
+const upgradeIdleCheck = 5 * time.Second
+
+var upgrading atomic.Bool
+
+// RequestUpgrade 记录升级请求，runner 空闲后下载新版本并重启
+func RequestUpgrade(req types.Upgrade) error {
+	if Draining() {
+		return ErrDraining
+	}
+	if !upgrading.CompareAndSwap(false, true) {
+		return errors.New("upgrade already in progress")
+	}
+	go upgrade(req)
+	return nil
+}
+
+func upgrade(req types.Upgrade) {
+	defer upgrading.Store(false)
+
+	for {
+		drainMutex.Lock()
+		if draining {
+			drainMutex.Unlock()
+			hlog.Warnf("runner is draining, skip upgrade to %s", req.Version)
+			return
+		}
+		if active == 0 {
+			// 暂停接收任务，直到重启
+			draining = true
+			drainMutex.Unlock()
+			break
+		}
+		drainMutex.Unlock()
+		time.Sleep(upgradeIdleCheck)
+	}
+
+	reportStatus(types.RunnerDraining)
+	if err := installUpgrade(req); err != nil {
+		hlog.Errorf("upgrade to %s error: %s", req.Version, err)
+		drainMutex.Lock()
+		draining = false
+		drainMutex.Unlock()
+		reportStatus(types.RunnerOnline)
+		return
+	}
+	flush()
+
+	exe, err := os.Executable()
+	if err != nil {
+		hlog.Errorf("get executable error: %s", err)
+		return
+	}
+	hlog.Infof("upgrade success, restart into version %s", req.Version)
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		hlog.Fatalf("restart runner error: %s", err)
+	}
+}
+
+// installUpgrade 下载新版本，校验 sha256 后替换当前可执行文件
+func installUpgrade(req types.Upgrade) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	httpReq, _ := http.NewRequest("GET", fmt.Sprintf("%s/runner_release/%d/download", serverUrl, req.ReleaseID), nil)
+	utils.SignRequest(httpReq, name, secret, nil)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("download runner release failed, status code: %d", resp.StatusCode)
+	}
+
+	tmp := exe + ".new"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != req.Checksum {
+		return fmt.Errorf("checksum mismatch, expected: %s, actual: %s", req.Checksum, checksum)
+	}
+
+	if err := os.Rename(exe, exe+".old"); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		if err := os.Rename(exe+".old", exe); err != nil {
+			hlog.Errorf("restore executable error: %s", err)
+		}
+		return err
+	}
+	hlog.Infof("runner binary replaced, old version kept at %s.old", exe)
+	return nil
+}
@@ -0,0 +1,99 @@
+package jobexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+type WorkspacePolicy string
+
+const (
+	// WorkspaceReuse 同一仓库的工作目录在任务结束后保留，供后续任务复用，并发任务使用不同目录
+	WorkspaceReuse WorkspacePolicy = "reuse"
+	// WorkspaceClean 每个任务使用新目录，结束后删除
+	WorkspaceClean WorkspacePolicy = "clean"
+	// WorkspaceKeep 每个任务使用新目录，结束后保留，便于排查
+	WorkspaceKeep WorkspacePolicy = "keep"
+)
+
+var (
+	workspacePolicy = WorkspaceReuse
+	workspaceMutex  sync.Mutex
+	workspaceInUse  = make(map[string]struct{})
+)
+
+func SetWorkspacePolicy(policy string) error {
+	switch p := WorkspacePolicy(policy); p {
+	case WorkspaceReuse, WorkspaceClean, WorkspaceKeep:
+		workspacePolicy = p
+		return nil
+	default:
+		return fmt.Errorf("unknown workspace policy: %s", policy)
+	}
+}
+
+func workspaceRoot() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".cicd-runner", "workspaces"), nil
+}
+
+// acquireWorkspace 为任务分配独立的工作目录
+func (job *JobExec) acquireWorkspace() (string, error) {
+	root, err := workspaceRoot()
+	if err != nil {
+		return "", err
+	}
+
+	key := "default"
+	if job.Git.ID > 0 {
+		key = fmt.Sprintf("%d", job.Git.ID)
+	}
+
+	var dir string
+	if workspacePolicy == WorkspaceReuse {
+		workspaceMutex.Lock()
+		for n := 0; ; n++ {
+			dir = filepath.Join(root, fmt.Sprintf("%s-%d", key, n))
+			if _, ok := workspaceInUse[dir]; !ok {
+				workspaceInUse[dir] = struct{}{}
+				break
+			}
+		}
+		workspaceMutex.Unlock()
+	} else {
+		dir = filepath.Join(root, key, fmt.Sprintf("%d", job.JobRunner.ID))
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		job.releaseWorkspace(dir)
+		return "", err
+	}
+	// 任务用户需要能在上级目录中重新克隆工作目录
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := sandboxChown(d); err != nil {
+			job.releaseWorkspace(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func (job *JobExec) releaseWorkspace(dir string) {
+	switch workspacePolicy {
+	case WorkspaceReuse:
+		workspaceMutex.Lock()
+		delete(workspaceInUse, dir)
+		workspaceMutex.Unlock()
+	case WorkspaceClean:
+		if err := os.RemoveAll(dir); err != nil {
+			hlog.Errorf("remove workspace error: %s", err)
+		}
+	}
+}
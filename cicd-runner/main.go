@@ -3,18 +3,22 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"cicd-runner/handler"
 	jobexec "cicd-runner/job_exec"
+	"cicd-runner/types"
+	"cicd-runner/utils"
 
 	"github.com/cloudwego/hertz/pkg/app/server"
 	"github.com/cloudwego/hertz/pkg/common/hlog"
@@ -22,23 +26,48 @@ import (
 )
 
 var (
-	name, runnerUrl, serverUrl string
-	labels                     []string
+	name, runnerUrl, serverUrl, token string
+	labels                            []string
+	gracePeriod, metricsInterval      time.Duration
+	killGrace                         time.Duration
+	workspacePolicy, containerRuntime string
+	cacheMaxSize, cacheMaxEntrySize   int64
+	cacheShare, gitMirror             bool
+	runAs                             string
+	allowRoot                         bool
+	passEnv                           []string
 )
 
 func main() {
-	// createUser()
 	cmd := &cobra.Command{
 		Use:   "cicd-runner",
 		Short: "Start cicd-runner",
 		Long:  "Start cicd-runner",
 		Run: func(cmd *cobra.Command, args []string) {
-			registerRunner(name, runnerUrl, serverUrl, labels)
+			if err := jobexec.SetWorkspacePolicy(workspacePolicy); err != nil {
+				log.Fatal(err)
+			}
+			if err := jobexec.SetSandbox(runAs, allowRoot, passEnv); err != nil {
+				log.Fatal(err)
+			}
+			jobexec.SetCacheOptions(cacheMaxSize<<20, cacheMaxEntrySize<<20, cacheShare)
+			jobexec.SetContainerRuntime(containerRuntime)
+			jobexec.SetGitMirror(gitMirror)
+			jobexec.SetKillGrace(killGrace)
+			if jobexec.ContainerAvailable() {
+				types.Features = append(types.Features, "container")
+			}
+			secret := registerRunner(name, runnerUrl, serverUrl, token, labels)
 
-			go jobexec.Run(name, serverUrl)
+			go jobexec.Run(name, serverUrl, secret)
+			go jobexec.ReportMetrics(metricsInterval)
 			h := server.Default(server.WithHostPorts(":5913"))
-			h.POST("/start_job", handler.StartJob)
+			h.SetCustomSignalWaiter(waitDrain)
+			h.Use(handler.ServerAuth(secret))
+			h.POST("/start_job", handler.DecryptBody(secret), handler.StartJob)
 			h.POST("/cancel_job/:job_runner_id", handler.CancelJob)
+			h.POST("/drain", handler.Drain)
+			h.POST("/upgrade", handler.Upgrade)
 
 			h.Spin()
 		},
@@ -47,21 +76,51 @@ func main() {
 	cmd.PersistentFlags().StringVarP(&name, "name", "n", "cicd-runner", "runner name")
 	cmd.PersistentFlags().StringVarP(&runnerUrl, "runnerUrl", "r", "http://localhost:5913", "runner server url")
 	cmd.PersistentFlags().StringVarP(&serverUrl, "serverUrl", "s", "http://localhost:5912", "server url")
+	cmd.PersistentFlags().StringVarP(&token, "token", "t", "", "runner registration token issued by admin")
 	cmd.PersistentFlags().StringSliceVarP(&labels, "labels", "l", []string{}, "runner labels")
+	cmd.PersistentFlags().StringVarP(&workspacePolicy, "workspace-policy", "w", "reuse", "workspace policy: reuse, clean or keep")
+	cmd.PersistentFlags().StringVarP(&runAs, "run-as", "u", "", "unprivileged user to run job commands as, requires the runner to start as root")
+	cmd.PersistentFlags().BoolVar(&allowRoot, "allow-root", false, "allow job commands to run as root")
+	cmd.PersistentFlags().StringSliceVar(&passEnv, "pass-env", []string{}, "runner environment variables passed to jobs besides PATH, locale and proxy")
+	cmd.PersistentFlags().StringVarP(&containerRuntime, "container-runtime", "c", "docker", "container runtime used by steps declaring an image")
+	cmd.PersistentFlags().Int64Var(&cacheMaxSize, "cache-max-size", 10240, "max total size of local dependency cache in MB")
+	cmd.PersistentFlags().Int64Var(&cacheMaxEntrySize, "cache-max-entry-size", 1024, "max size of a single dependency cache in MB")
+	cmd.PersistentFlags().BoolVar(&cacheShare, "cache-share", false, "share dependency cache with other runners through server")
+	cmd.PersistentFlags().BoolVar(&gitMirror, "git-mirror", true, "keep a bare mirror per repository and create workspaces from it")
+	cmd.PersistentFlags().DurationVarP(&metricsInterval, "metrics-interval", "m", 30*time.Second, "interval of reporting cpu, memory, disk and load")
+	cmd.PersistentFlags().DurationVar(&killGrace, "kill-grace", 10*time.Second, "time to wait after SIGTERM before killing the process group of a canceled command")
+	cmd.PersistentFlags().DurationVarP(&gracePeriod, "grace-period", "g", 10*time.Minute, "time to wait for running jobs before canceling them on shutdown")
 
 	if err := cmd.Execute(); err != nil {
 		panic(err)
 	}
 }
 
-func registerRunner(name, runnerUrl, serverUrl string, labels []string) {
+// waitDrain 收到 SIGTERM/SIGINT 或 server 下发的排空请求后，排空任务再优雅退出
+func waitDrain(errCh chan error) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-signals:
+		hlog.Infof("received signal: %s", sig)
+	case <-jobexec.DrainRequested():
+		hlog.Info("received drain request from server")
+	case err := <-errCh:
+		return err
+	}
+
+	jobexec.Drain(gracePeriod)
+	return nil
+}
+
+func registerRunner(name, runnerUrl, serverUrl, token string, labels []string) string {
 	var ipstr string
 	ip, err := GetOutboundIP()
 	if err != nil {
 		ips, err := GetLocalIPs()
 		if err != nil {
 			log.Fatal(err)
-			return
 		}
 
 		ipstr = strings.Join(ips, ",")
@@ -74,23 +133,70 @@ func registerRunner(name, runnerUrl, serverUrl string, labels []string) {
 		Endpoint: runnerUrl,
 		Labels:   labels,
 		IP:       ipstr,
+		Token:    token,
+		Version:  types.Version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Protocol: types.ProtocolVersion,
+		Features: types.Features,
 	}
 
 	client := &http.Client{}
 	jsonBytes, _ := json.Marshal(req)
 	httpReq, _ := http.NewRequest("POST", serverUrl+"/register_runner", bytes.NewReader(jsonBytes))
 	httpReq.Header.Set("Content-Type", "application/json")
+	// 已注册过的 runner 需要用保存的密钥签名，server 才允许更换密钥
+	if saved := loadSecret(name); saved != "" {
+		utils.SignRequest(httpReq, name, saved, jsonBytes)
+	}
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		log.Fatal(err)
-		return
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		log.Fatalf("register runner failed, status code: %d", resp.StatusCode)
-		return
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("register runner failed, status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var registerResp RegisterRunnerResp
+	if err := json.NewDecoder(resp.Body).Decode(&registerResp); err != nil {
+		log.Fatalf("decode register response error: %s", err)
+	}
+	if registerResp.Secret == "" {
+		log.Fatal("register runner failed, server returned no secret")
+	}
+	if err := saveSecret(name, registerResp.Secret); err != nil {
+		log.Fatalf("save runner secret error: %s", err)
+	}
+	log.Printf("register runner success, version: %s, protocol: %d", types.Version, types.ProtocolVersion)
+	return registerResp.Secret
+}
+
+// secretPath 注册得到的密钥保存位置，只有运行 runner 的用户可以读取
+func secretPath(name string) string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".cicd-runner", "secrets", name)
+}
+
+func loadSecret(name string) string {
+	data, err := os.ReadFile(secretPath(name))
+	if err != nil {
+		return ""
 	}
-	log.Println("register runner success")
+	return strings.TrimSpace(string(data))
+}
+
+func saveSecret(name, secret string) error {
+	path := secretPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免写入中断后丢失密钥
+	if err := os.WriteFile(path+".tmp", []byte(secret), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
 }
 
 func GetOutboundIP() (net.IP, error) {
@@ -150,58 +256,15 @@ type RegisterRunnerReq struct {
 	Endpoint string   `json:"endpoint"`
 	Labels   []string `json:"labels"`
 	IP       string   `json:"ip"`
+	Token    string   `json:"token"`
+	Version  string   `json:"version"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features"`
 }
 
-func createUser() {
-	if runtime.GOOS != "linux" {
-		log.Println("only support linux system with useradd command")
-		return
-	}
-	group := "cicd-runner"
-	username := "cicd-runner"
-	cmd := exec.Command("useradd", "-m", "-g", group, username)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil { // create userexec.Command("useradd", "-m", "-g", "cicd-runner", "cicd-runner").Run()
-		hlog.Fatal(err)
-		return
-	}
-	log.Println("create user success, setting uid and gid")
-	// 获取用户的 UID 和 GID
-	cmd = exec.Command("id", "-u", username)
-	output, err := cmd.Output()
-	if err != nil {
-		hlog.Fatal(err)
-	}
-	uid := string(output[:len(output)-1]) // 去掉末尾的换行符
-
-	cmd = exec.Command("id", "-g", username)
-	output, err = cmd.Output()
-	if err != nil {
-		hlog.Fatal(err)
-	}
-	gid := string(output[:len(output)-1])
-
-	hlog.Info("uid:", uid)
-	hlog.Info("gid:", gid)
-
-	// 设置进程的 UID 和 GID
-	uidint, err := strconv.Atoi(uid)
-	if err != nil {
-		hlog.Fatal(err)
-	}
-	gidint, err := strconv.Atoi(gid)
-	if err != nil {
-		hlog.Fatal(err)
-	}
-	err = syscall.Setuid(uidint)
-	if err != nil {
-		hlog.Fatal(err)
-	}
-	err = syscall.Setgid(gidint)
-	if err != nil {
-		hlog.Fatal(err)
-	}
-
-	hlog.Info(string(output))
+type RegisterRunnerResp struct {
+	Data   string `json:"data"`
+	Secret string `json:"secret"`
 }
@@ -2,9 +2,13 @@
 
 server_url=http://localhost:8029/api      # 服务器地址（runner机器可以访问到的）
 runner_url=http://localhost:5913          # 运行器地址（server机器可以访问到的）
+runner_token=                             # 管理员在server上创建的注册令牌
+run_as=cicd-runner                        # 执行任务命令的非特权用户，runner需要以root启动
 
 # -n 运行器名称
 # -s 服务器地址
 # -r 运行器地址
+# -t 注册令牌，通过 /api/create_runner_token 创建
 # -l 运行器标签，可多个，执行ci任务时，会根据标签匹配runner机器并执行任务
-nohup /home/devops/ci/cicd-runner -n shanghai01 -s $server_url -r $runner_url -l sh_01 > runner.log 2>&1 &
\ No newline at end of file
+# -u 以该用户身份执行任务命令；不指定时以runner自身用户执行，root用户需额外加 --allow-root
+nohup /home/devops/ci/cicd-runner -n shanghai01 -s $server_url -r $runner_url -t $runner_token -l sh_01 -u $run_as > runner.log 2>&1 &
\ No newline at end of file
@@ -7,6 +7,71 @@ type Event struct {
 }
 
 type Log struct {
-	JobRunnerID uint   `path:"job_runner_id" vd:"$>0"`
-	Log         string `json:"log"`
+	JobRunnerID uint      `path:"job_runner_id" vd:"$>0"`
+	Stream      string    `json:"-"`
+	Record      LogRecord `json:"-"`
+	Log         string    `json:"log"`
+}
+
+const (
+	LogStdout = "stdout"
+	LogStderr = "stderr"
+	// LogSystem runner 自身输出的日志，例如执行的命令、环境变量和错误信息
+	LogSystem = "system"
+
+	// LogSectionStart 和 LogSectionEnd 标记一条命令的开始和结束，日志页面据此折叠命令的输出
+	LogSectionStart = "section_start"
+	LogSectionEnd   = "section_end"
+)
+
+// LogRecord 结构化的日志记录
+type LogRecord struct {
+	Seq uint64 `json:"seq"`
+	// Time 记录时间，unix 毫秒
+	Time int64 `json:"time"`
+	// Elapsed 距步骤开始的毫秒数，使用单调时钟，不受系统时间调整影响
+	Elapsed int64  `json:"elapsed"`
+	Stream  string `json:"stream"`
+	// Command 所属命令的下标，-1 表示不属于任何命令
+	Command int `json:"command"`
+	// Type 为空表示普通日志行，否则为命令段的开始或结束
+	Type string `json:"type,omitempty"`
+	// ExitCode 和 Duration 只在命令段结束时出现，Duration 为毫秒
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+	Text     string `json:"text"`
+}
+
+// LogBatch 批量上报的日志，Stream 标识一次执行，序号在 Stream 内从 1 开始连续递增
+type LogBatch struct {
+	JobRunnerID uint        `json:"-"`
+	Stream      string      `json:"stream"`
+	Records     []LogRecord `json:"records"`
+}
+
+const (
+	RunnerOnline   = "online"
+	RunnerDraining = "draining"
+	RunnerOffline  = "offline"
+)
+
+type RunnerStatus struct {
+	Status string `json:"status"`
+}
+
+type Upgrade struct {
+	ReleaseID uint   `json:"release_id" vd:"$>0"`
+	Version   string `json:"version"`
+	Checksum  string `json:"checksum" vd:"len($)>0"`
+}
+
+type Metrics struct {
+	CPUs         int     `json:"cpus"`
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemTotal     uint64  `json:"mem_total"`
+	MemAvailable uint64  `json:"mem_available"`
+	DiskFree     uint64  `json:"disk_free"`
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
 }
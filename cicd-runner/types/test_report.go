@@ -0,0 +1,26 @@
+package types
+
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestSkipped = "skipped"
+)
+
+// TestReport 从一个测试报告文件中解析出的测试用例
+type TestReport struct {
+	JobRunnerID uint       `json:"-"`
+	File        string     `json:"file"`
+	Format      string     `json:"format"`
+	Cases       []TestCase `json:"cases"`
+}
+
+type TestCase struct {
+	// Suite JUnit 的 classname 或 testsuite 名称，go test 的包名
+	Suite  string `json:"suite"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Duration 毫秒
+	Duration int64 `json:"duration"`
+	// Message 失败或跳过的原因，包含测试输出
+	Message string `json:"message,omitempty"`
+}
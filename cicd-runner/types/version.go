@@ -0,0 +1,10 @@
+package types
+
+// Version 构建时通过 -ldflags "-X cicd-runner/types.Version=x.y.z" 注入
+var Version = "dev"
+
+// ProtocolVersion runner 与 server 之间的协议版本，JobExec 等结构不兼容变更时递增
+const ProtocolVersion = 1
+
+// Features runner 支持的协议特性，server 据此判断是否可以下发对应任务
+var Features = []string{"cancel", "drain", "upgrade", "telemetry", "script", "artifacts", "test_reports", "encrypted_job", "signed_query"}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+)
+
+// TransportKey server 下发任务时加密请求体使用的密钥，由注册时下发的密钥派生
+func TransportKey(secret string) []byte {
+	sum := sha256.Sum256([]byte("cicd-job:" + secret))
+	return sum[:]
+}
+
+// Open 解密 AES-256-GCM 加密的 nonce+密文
+func Open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
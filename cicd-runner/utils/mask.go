@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 过短的值替换后会破坏大量正常日志，不做替换
+const minMaskLength = 4
+
+// Masker 把日志中出现的密钥替换为 ***，同时匹配 base64 和 url 编码后的形式
+type Masker struct {
+	replacer *strings.Replacer
+}
+
+func NewMasker(secrets ...string) *Masker {
+	forms := make(map[string]struct{})
+	for _, secret := range secrets {
+		if len(secret) < minMaskLength {
+			continue
+		}
+		for _, form := range []string{
+			secret,
+			base64.StdEncoding.EncodeToString([]byte(secret)),
+			base64.RawStdEncoding.EncodeToString([]byte(secret)),
+			base64.URLEncoding.EncodeToString([]byte(secret)),
+			base64.RawURLEncoding.EncodeToString([]byte(secret)),
+			url.QueryEscape(secret),
+			url.PathEscape(secret),
+		} {
+			forms[form] = struct{}{}
+		}
+	}
+	if len(forms) == 0 {
+		return &Masker{}
+	}
+
+	// 较长的形式优先匹配，避免只替换掉其中一部分
+	list := make([]string, 0, len(forms))
+	for form := range forms {
+		list = append(list, form)
+	}
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	pairs := make([]string, 0, len(list)*2)
+	for _, form := range list {
+		pairs = append(pairs, form, "***")
+	}
+	return &Masker{replacer: strings.NewReplacer(pairs...)}
+}
+
+func (m *Masker) Mask(s string) string {
+	if m == nil || m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
@@ -0,0 +1,49 @@
+package dal
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+// Artifact 步骤成功后 runner 上传的构建产物
+type Artifact struct {
+	gorm.Model
+	JobID       uint `gorm:"index"`
+	JobRunnerID uint `gorm:"index"`
+	StepID      uint
+	StepName    string
+	RunnerName  string
+	Name        string // 相对工作目录的路径
+	Path        string
+	Checksum    string
+	Size        int64
+	ExpireAt    time.Time `gorm:"index"`
+}
+
+// ArtifactRetention 构建产物保留时长，通过 CICD_ARTIFACT_RETENTION_DAYS 配置，默认 30 天
+func ArtifactRetention() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("CICD_ARTIFACT_RETENTION_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+func (a *Artifact) Format() types.ArtifactResp {
+	return types.ArtifactResp{
+		ID:          a.ID,
+		JobID:       a.JobID,
+		JobRunnerID: a.JobRunnerID,
+		StepName:    a.StepName,
+		RunnerName:  a.RunnerName,
+		Name:        a.Name,
+		Checksum:    a.Checksum,
+		Size:        a.Size,
+		CreatedAt:   a.CreatedAt.Format(time.DateTime),
+		ExpireAt:    a.ExpireAt.Format(time.DateTime),
+	}
+}
@@ -10,4 +10,10 @@ type Git struct {
 	Username   string
 	Password   string
 	CommitID   string
+	// 检出选项
+	Depth       int // 浅克隆深度，0 表示完整克隆
+	Submodules  bool
+	LFS         bool
+	SparsePaths ListString
+	Clean       bool // 构建前执行 git clean -ffdx
 }
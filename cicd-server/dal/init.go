@@ -51,6 +51,12 @@ func initSqlite() *gorm.DB {
 		&Step{},
 		&Job{},
 		&JobRunner{},
+		&LogCursor{},
+		&LogLine{},
+		&TestCase{},
+		&TestStat{},
+		&ProblemMatcher{},
+		&Annotation{},
 		&Runner{},
 		&RunnerLabel{},
 		&Git{},
@@ -59,9 +65,22 @@ func initSqlite() *gorm.DB {
 		&UserRole{},
 		&PipelineRole{},
 		&Stage{},
+		&RunnerToken{},
+		&RunnerRelease{},
+		&RunnerMetric{},
+		&Artifact{},
 	); err != nil {
 		panic(err)
 	}
+	if err = initLogSearch(DB); err != nil {
+		panic(err)
+	}
+	if err = initProblemMatchers(DB); err != nil {
+		panic(err)
+	}
+	if err = initSecrets(DB); err != nil {
+		log.Fatalf("init secrets error: %s", err)
+	}
 
 	hlog.Info("sqlite init success")
 	return DB
@@ -4,6 +4,7 @@ import (
 	"cicd-server/types"
 	"sort"
 
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
@@ -15,14 +16,18 @@ type Job struct {
 	Envs       Envs `gorm:"type:json"`
 	Branch     string
 	CommitID   string
+	Priority   int
+	GroupName  string
 }
 
 func (j *Job) Format() types.JobResp {
 	var evns types.Envs
 	for _, v := range j.Envs {
+		// 任务详情只用于展示，不返回 secret 变量的值
 		evns = append(evns, types.Env{
-			Key: v.Key,
-			Val: v.Val,
+			Key:    v.Key,
+			Val:    lo.Ternary(v.Secret, "***", v.Val),
+			Secret: v.Secret,
 		})
 	}
 
@@ -59,5 +64,29 @@ func (j *Job) Format() types.JobResp {
 		JobRunners: rs,
 		Branch:     j.Branch,
 		CommitID:   j.CommitID,
+		Priority:   j.Priority,
 	}
 }
+
+// Secrets 返回任务中需要在日志里隐藏的值：解密后的 secret 变量和 git 凭据
+func (j *Job) Secrets() []string {
+	var secrets []string
+	envs, err := j.Envs.Decrypt()
+	if err != nil {
+		hlog.Errorf("decrypt envs of job %d error: %s", j.ID, err)
+	}
+	for _, env := range envs {
+		if env.Secret {
+			secrets = append(secrets, env.Val)
+		}
+	}
+	var git Git
+	if err := DB.Last(&git, "pipeline_id = ?", j.PipelineID).Error; err == nil && git.Password != "" {
+		if password, err := git.PlainPassword(); err == nil {
+			secrets = append(secrets, password, git.Username+":"+password)
+		} else {
+			hlog.Errorf("decrypt git password of pipeline %d error: %s", j.PipelineID, err)
+		}
+	}
+	return secrets
+}
@@ -10,20 +10,31 @@ import (
 
 type JobRunner struct {
 	gorm.Model
-	JobID           uint
-	StageID         uint
-	StepID          uint
-	StepSort        int
-	Commands        ListString
-	Status          Status
-	EventStatus     EventStatus
-	Message         string
-	AssignRunnerIds AssignRunnerIds
-	Trigger         Trigger
-	StartTime       time.Time
-	EndTime         time.Time
-	TriggerUserId   uint
-	Parallel        bool
+	JobID             uint
+	StageID           uint
+	StepID            uint
+	StepSort          int
+	Commands          ListString
+	Image             string
+	Mode              StepMode
+	Interpreter       string
+	CacheKey          string
+	CachePaths        ListString
+	Artifacts         ListString
+	ArtifactDownloads ListString
+	AllowFailure      bool
+	CommandOptions    CommandOptions
+	TestReports       ListString
+	ProblemMatchers   ProblemMatcherRules
+	Status            Status
+	EventStatus       EventStatus
+	Message           string
+	AssignRunnerIds   AssignRunnerIds
+	Trigger           Trigger
+	StartTime         time.Time
+	EndTime           time.Time
+	TriggerUserId     uint
+	Parallel          bool
 }
 
 type Status string
@@ -37,8 +48,24 @@ const (
 	PartialSuccess Status = "partial_success"
 	Failed         Status = "failed"
 	Canceled       Status = "canceled"
+	// AllowedFailure 步骤失败，但配置了 allow_failure，不阻塞后续步骤
+	AllowedFailure Status = "allowed_failure"
 )
 
+// Finished 步骤是否已结束
+func (s Status) Finished() bool {
+	switch s {
+	case Success, PartialSuccess, Failed, Canceled, AllowedFailure:
+		return true
+	}
+	return false
+}
+
+// Passed 步骤是否已结束且允许继续执行后续步骤
+func (s Status) Passed() bool {
+	return s == Success || s == AllowedFailure
+}
+
 type EventStatus map[Status]int
 
 // 实现 sql.Scanner 接口，Scan 将 value 扫描至 Jsonb
@@ -0,0 +1,12 @@
+package dal
+
+import "gorm.io/gorm"
+
+// LogCursor 记录每个日志 stream 已写入的最大序号，用于丢弃 runner 重传的重复日志。
+// runner 每次执行步骤生成新的 stream，同一步骤由多台 runner 执行时各自独立计数。
+type LogCursor struct {
+	gorm.Model
+	JobRunnerID uint   `gorm:"index"`
+	Stream      string `gorm:"index"`
+	Seq         uint64
+}
@@ -0,0 +1,33 @@
+package dal
+
+import "gorm.io/gorm"
+
+// LogLine 用于全文检索的日志行，与日志文件中的记录一一对应。
+// 内容索引在 log_lines_fts 中，通过触发器与本表同步。
+type LogLine struct {
+	ID          uint `gorm:"primarykey"`
+	JobRunnerID uint `gorm:"index"`
+	Runner      string
+	Stream      string
+	Time        int64
+	Text        string
+}
+
+// initLogSearch 创建日志全文索引。使用 trigram 分词，支持任意子串（包括中文和报错中的类名）检索，
+// 关键字至少需要 3 个字符
+func initLogSearch(db *gorm.DB) error {
+	for _, sql := range []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS log_lines_fts USING fts5(text, content='log_lines', content_rowid='id', tokenize='trigram')`,
+		`CREATE TRIGGER IF NOT EXISTS log_lines_ai AFTER INSERT ON log_lines BEGIN
+			INSERT INTO log_lines_fts(rowid, text) VALUES (new.id, new.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS log_lines_ad AFTER DELETE ON log_lines BEGIN
+			INSERT INTO log_lines_fts(log_lines_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		END`,
+	} {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
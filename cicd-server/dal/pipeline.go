@@ -20,13 +20,18 @@ type Pipeline struct {
 	Envs        Envs `gorm:"type:json"`
 	UseGit      bool `gorm:"default:0"`
 	Sort        int  `gorm:"default:0"`
+	Priority    int  `gorm:"default:0"`
+	// 保留最近 RetentionCount 个任务，或 RetentionDays 天内的任务，为 0 时使用全局配置
+	RetentionCount int `gorm:"default:0"`
+	RetentionDays  int `gorm:"default:0"`
 }
 
 type Envs []Env
 
 type Env struct {
-	Key string
-	Val string
+	Key    string
+	Val    string
+	Secret bool
 }
 
 // 实现 sql.Scanner 接口，Scan 将 value 扫描至 Jsonb
@@ -47,25 +52,48 @@ func (j Envs) Value() (driver.Value, error) {
 	return json.Marshal(j)
 }
 
-func (p *Pipeline) Format() types.PipelineResp {
-	var evns types.Envs
-	for _, v := range p.Envs {
-		evns = append(evns, types.Env{
-			Key: v.Key,
-			Val: v.Val,
+// Format secret 变量只返回是否已设置，不返回值
+func (envs Envs) Format() types.Envs {
+	var resp types.Envs
+	for _, v := range envs {
+		resp = append(resp, types.Env{
+			Key:    v.Key,
+			Val:    lo.Ternary(v.Secret, "", v.Val),
+			Secret: v.Secret,
+			Set:    v.Secret && v.Val != "",
 		})
 	}
+	return resp
+}
+
+// Merge 合并请求中的变量，secret 变量的值留空时保留 envs 中已保存的值
+func (envs Envs) Merge(req types.Envs) Envs {
+	saved := lo.SliceToMap(envs, func(env Env) (string, Env) { return env.Key, env })
+	var merged Envs
+	for _, v := range req {
+		env := Env{Key: v.Key, Val: v.Val, Secret: v.Secret}
+		if old, ok := saved[v.Key]; ok && v.Secret && old.Secret && v.Val == "" {
+			env.Val = old.Val
+		}
+		merged = append(merged, env)
+	}
+	return merged
+}
 
+func (p *Pipeline) Format() types.PipelineResp {
 	pipeline := types.PipelineResp{
-		ID:           p.ID,
-		Name:         p.Name,
-		GroupName:    p.GroupName,
-		TagTemplate:  p.TagTemplate,
-		Envs:         evns,
-		LastUpdateAt: p.UpdatedAt.Format("2006-01-02 15:04:05"),
-		LastTag:      p.TagTemplate,
-		UseGit:       p.UseGit,
-		Sort:         p.Sort,
+		ID:             p.ID,
+		Name:           p.Name,
+		GroupName:      p.GroupName,
+		TagTemplate:    p.TagTemplate,
+		Envs:           p.Envs.Format(),
+		LastUpdateAt:   p.UpdatedAt.Format("2006-01-02 15:04:05"),
+		LastTag:        p.TagTemplate,
+		UseGit:         p.UseGit,
+		Sort:           p.Sort,
+		Priority:       p.Priority,
+		RetentionCount: p.RetentionCount,
+		RetentionDays:  p.RetentionDays,
 	}
 
 	var pipelineRoles []PipelineRole
@@ -80,7 +108,12 @@ func (p *Pipeline) Format() types.PipelineResp {
 		pipeline.Repository = git.Repository
 		pipeline.Branch = git.Branch
 		pipeline.Username = git.Username
-		pipeline.Password = git.Password
+		pipeline.PasswordSet = git.Password != ""
+		pipeline.Depth = git.Depth
+		pipeline.Submodules = git.Submodules
+		pipeline.LFS = git.LFS
+		pipeline.SparsePaths = git.SparsePaths
+		pipeline.Clean = git.Clean
 	}
 
 	var job Job
@@ -135,24 +168,19 @@ func (p *Pipeline) Format() types.PipelineResp {
 }
 
 func (p *Pipeline) ListFormat() types.PipelineResp {
-	var evns types.Envs
-	for _, v := range p.Envs {
-		evns = append(evns, types.Env{
-			Key: v.Key,
-			Val: v.Val,
-		})
-	}
-
 	pipeline := types.PipelineResp{
-		ID:           p.ID,
-		Name:         p.Name,
-		GroupName:    p.GroupName,
-		TagTemplate:  p.TagTemplate,
-		Envs:         evns,
-		LastUpdateAt: p.UpdatedAt.Format("2006-01-02 15:04:05"),
-		LastTag:      p.TagTemplate,
-		UseGit:       p.UseGit,
-		Sort:         p.Sort,
+		ID:             p.ID,
+		Name:           p.Name,
+		GroupName:      p.GroupName,
+		TagTemplate:    p.TagTemplate,
+		Envs:           p.Envs.Format(),
+		LastUpdateAt:   p.UpdatedAt.Format("2006-01-02 15:04:05"),
+		LastTag:        p.TagTemplate,
+		UseGit:         p.UseGit,
+		Sort:           p.Sort,
+		Priority:       p.Priority,
+		RetentionCount: p.RetentionCount,
+		RetentionDays:  p.RetentionDays,
 	}
 
 	var pipelineRoles []PipelineRole
@@ -0,0 +1,163 @@
+package dal
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+// ProblemMatcher 全局的问题匹配规则，对所有步骤的日志生效
+type ProblemMatcher struct {
+	gorm.Model
+	ProblemMatcherRule
+}
+
+// ProblemMatcherRule 把匹配的日志行转换为注解。Pattern 为单行正则，可以使用命名分组
+// file、line、column、severity、code、message，没有 message 分组时使用整行作为内容
+type ProblemMatcherRule struct {
+	Name     string
+	Pattern  string
+	Severity string // 没有 severity 分组时使用的级别：error、warning 或 notice
+}
+
+type ProblemMatcherRules []ProblemMatcherRule
+
+// 实现 sql.Scanner 接口，Scan 将 value 扫描至 Jsonb
+func (j *ProblemMatcherRules) Scan(value interface{}) error {
+	val := make(ProblemMatcherRules, 0)
+	if err := json.Unmarshal(value.([]byte), &val); err != nil {
+		return err
+	}
+	*j = val
+	return nil
+}
+
+// 实现 driver.Valuer 接口，Value 返回 json value
+func (j ProblemMatcherRules) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return json.Marshal(ProblemMatcherRules{})
+	}
+	return json.Marshal(j)
+}
+
+// Annotation 问题匹配规则从步骤日志中提取的错误和警告
+type Annotation struct {
+	gorm.Model
+	JobRunnerID uint `gorm:"index"`
+	Matcher     string
+	Severity    string
+	File        string
+	Line        int
+	Column      int
+	Code        string
+	Message     string
+	// Runner 和 Seq 对应产生注解的日志记录
+	Runner string
+	Seq    uint64
+}
+
+// DefaultProblemMatchers 首次启动时写入的全局规则，管理员可以修改或删除
+var DefaultProblemMatchers = []ProblemMatcherRule{
+	{
+		Name:     "go",
+		Pattern:  `^(?P<file>[^\s:]+\.go):(?P<line>\d+):(?:(?P<column>\d+):)?\s+(?P<message>.+)$`,
+		Severity: types.SeverityError,
+	},
+	{
+		Name:     "fail",
+		Pattern:  `^\s*(?:--- )?FAIL:?\s+(?P<message>.+)$`,
+		Severity: types.SeverityError,
+	},
+	{
+		Name:     "gcc",
+		Pattern:  `^(?P<file>[^\s:]+):(?P<line>\d+):(?P<column>\d+):\s+(?:fatal )?(?P<severity>error|warning|note):\s+(?P<message>.+)$`,
+		Severity: types.SeverityError,
+	},
+	{
+		Name:     "tsc",
+		Pattern:  `^(?P<file>[^\s(]+)\((?P<line>\d+),(?P<column>\d+)\): (?P<severity>error|warning) (?P<code>TS\d+): (?P<message>.+)$`,
+		Severity: types.SeverityError,
+	},
+	{
+		Name:     "eslint-compact",
+		Pattern:  `^(?P<file>[^\s:]+): line (?P<line>\d+), col (?P<column>\d+), (?P<severity>Error|Warning) - (?P<message>.+?)(?: \((?P<code>[^)]+)\))?$`,
+		Severity: types.SeverityError,
+	},
+	{
+		Name:     "eslint-stylish",
+		Pattern:  `^\s+(?P<line>\d+):(?P<column>\d+)\s+(?P<severity>error|warning)\s+(?P<message>.+?)(?:\s{2,}(?P<code>\S+))?$`,
+		Severity: types.SeverityError,
+	},
+}
+
+func initProblemMatchers(db *gorm.DB) error {
+	var count int64
+	if err := db.Unscoped().Model(&ProblemMatcher{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for _, rule := range DefaultProblemMatchers {
+		if err := db.Create(&ProblemMatcher{ProblemMatcherRule: rule}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate 检查正则和默认级别
+func (r ProblemMatcherRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("problem matcher name is empty")
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("problem matcher %s: %w", r.Name, err)
+	}
+	switch r.Severity {
+	case "", types.SeverityError, types.SeverityWarning, types.SeverityNotice:
+		return nil
+	}
+	return fmt.Errorf("problem matcher %s: invalid severity %s", r.Name, r.Severity)
+}
+
+func (r ProblemMatcherRule) Format() types.ProblemMatcherRule {
+	return types.ProblemMatcherRule{Name: r.Name, Pattern: r.Pattern, Severity: r.Severity}
+}
+
+func (m *ProblemMatcher) Format() types.ProblemMatcherResp {
+	return types.ProblemMatcherResp{ID: m.ID, ProblemMatcherRule: m.ProblemMatcherRule.Format()}
+}
+
+func (a *Annotation) Format() types.AnnotationResp {
+	return types.AnnotationResp{
+		ID:       a.ID,
+		Matcher:  a.Matcher,
+		Severity: a.Severity,
+		File:     a.File,
+		Line:     a.Line,
+		Column:   a.Column,
+		Code:     a.Code,
+		Message:  a.Message,
+		Runner:   a.Runner,
+		Seq:      a.Seq,
+	}
+}
+
+// NormalizeSeverity 把日志中的级别统一为 error、warning 或 notice
+func NormalizeSeverity(severity string) string {
+	switch s := strings.ToLower(severity); {
+	case strings.HasPrefix(s, "warn"):
+		return types.SeverityWarning
+	case s == "note" || s == "notice" || s == "info":
+		return types.SeverityNotice
+	default:
+		return types.SeverityError
+	}
+}
@@ -0,0 +1,56 @@
+package dal
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// JobRetention 任务历史保留规则：保留最近 Count 个任务，或 Days 天内的任务，满足其一即保留。
+// 两者都为 0 时不清理
+type JobRetention struct {
+	Count int
+	Days  int
+}
+
+// GlobalJobRetention 全局保留规则，通过 CICD_JOB_RETENTION_COUNT 和 CICD_JOB_RETENTION_DAYS 配置，默认不清理
+func GlobalJobRetention() JobRetention {
+	var r JobRetention
+	if count, err := strconv.Atoi(os.Getenv("CICD_JOB_RETENTION_COUNT")); err == nil && count > 0 {
+		r.Count = count
+	}
+	if days, err := strconv.Atoi(os.Getenv("CICD_JOB_RETENTION_DAYS")); err == nil && days > 0 {
+		r.Days = days
+	}
+	return r
+}
+
+// JobRetention 流水线的保留规则，未配置的项使用全局配置
+func (p *Pipeline) JobRetention() JobRetention {
+	r := GlobalJobRetention()
+	if p.RetentionCount > 0 {
+		r.Count = p.RetentionCount
+	}
+	if p.RetentionDays > 0 {
+		r.Days = p.RetentionDays
+	}
+	return r
+}
+
+func (r JobRetention) Enabled() bool {
+	return r.Count > 0 || r.Days > 0
+}
+
+// Expired 判断按时间倒序排在第 index 个（从 0 开始）的任务是否超出保留范围
+func (r JobRetention) Expired(index int, createdAt time.Time) bool {
+	if !r.Enabled() {
+		return false
+	}
+	if r.Count > 0 && index < r.Count {
+		return false
+	}
+	if r.Days > 0 && time.Since(createdAt) < time.Duration(r.Days)*24*time.Hour {
+		return false
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package dal
 import (
 	"cicd-server/types"
 
+	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
@@ -18,15 +19,33 @@ type Runner struct {
 	StageID       uint
 	StageParallel bool
 	IP            string
+	Secret        string `json:"-"`
+	Version       string
+	OS            string
+	Arch          string
+	Protocol      int
+	Features      ListString
 }
 
 type RunnerStatus string
 
 const (
-	Online  RunnerStatus = "online"
-	Offline RunnerStatus = "offline"
+	Online   RunnerStatus = "online"
+	Offline  RunnerStatus = "offline"
+	Draining RunnerStatus = "draining"
 )
 
+const (
+	// RunnerProtocol server 当前使用的 runner 协议版本
+	RunnerProtocol = 1
+	// MinRunnerProtocol 低于该版本的 runner 拒绝注册
+	MinRunnerProtocol = 1
+)
+
+func (r *Runner) HasFeature(feature string) bool {
+	return lo.Contains(r.Features, feature)
+}
+
 func (r *Runner) Format() types.RunnerResp {
 	resp := types.RunnerResp{
 		ID:           r.ID,
@@ -37,6 +56,17 @@ func (r *Runner) Format() types.RunnerResp {
 		Enable:       r.Enable,
 		IP:           r.IP,
 		CreatedAt:    r.CreatedAt.Format("2006-01-02 15:04:05"),
+		Message:      r.Message,
+		Version:      r.Version,
+		OS:           r.OS,
+		Arch:         r.Arch,
+		Protocol:     r.Protocol,
+		Features:     r.Features,
+	}
+
+	if metric := LatestRunnerMetric(r.ID); metric != nil {
+		m := metric.Format()
+		resp.Metrics = &m
 	}
 
 	var labels []RunnerLabel
@@ -0,0 +1,85 @@
+package dal
+
+import (
+	"time"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// 每个 runner 保留的监控记录数
+	runnerMetricHistory = 120
+	// 超过该时间未上报的监控数据视为失效
+	runnerMetricTTL = 3 * time.Minute
+)
+
+// RunnerMetric runner 定期上报的资源使用情况
+type RunnerMetric struct {
+	gorm.Model
+	RunnerID     uint `gorm:"index"`
+	CPUs         int
+	CPUPercent   float64
+	MemTotal     uint64
+	MemAvailable uint64
+	DiskFree     uint64
+	Load1        float64
+	Load5        float64
+	Load15       float64
+}
+
+// SaveRunnerMetric 保存监控数据，并清理超出保留数量的历史记录
+func SaveRunnerMetric(metric *RunnerMetric) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(metric).Error; err != nil {
+			return err
+		}
+
+		var ids []uint
+		if err := tx.Model(&RunnerMetric{}).
+			Where("runner_id = ?", metric.RunnerID).
+			Order("id desc").Offset(runnerMetricHistory).Limit(1).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		return tx.Unscoped().Where("runner_id = ? AND id <= ?", metric.RunnerID, ids[0]).Delete(&RunnerMetric{}).Error
+	})
+}
+
+// LatestRunnerMetric 返回 runner 最近一次有效的监控数据，没有时返回 nil
+func LatestRunnerMetric(runnerID uint) *RunnerMetric {
+	var metric RunnerMetric
+	if err := DB.Last(&metric, "runner_id = ?", runnerID).Error; err != nil {
+		return nil
+	}
+	if time.Since(metric.CreatedAt) > runnerMetricTTL {
+		return nil
+	}
+	return &metric
+}
+
+// Load 按 CPU 核数归一化的 1 分钟负载
+func (m *RunnerMetric) Load() float64 {
+	if m.CPUs <= 0 {
+		return m.Load1
+	}
+	return m.Load1 / float64(m.CPUs)
+}
+
+func (m *RunnerMetric) Format() types.RunnerMetricResp {
+	return types.RunnerMetricResp{
+		CPUs:         m.CPUs,
+		CPUPercent:   m.CPUPercent,
+		MemTotal:     m.MemTotal,
+		MemAvailable: m.MemAvailable,
+		DiskFree:     m.DiskFree,
+		Load1:        m.Load1,
+		Load5:        m.Load5,
+		Load15:       m.Load15,
+		CreatedAt:    m.CreatedAt.Format(time.DateTime),
+	}
+}
@@ -0,0 +1,32 @@
+package dal
+
+import (
+	"time"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+// RunnerRelease 管理员发布的 runner 二进制
+type RunnerRelease struct {
+	gorm.Model
+	Version  string
+	OS       string
+	Arch     string
+	Path     string
+	Checksum string
+	Size     int64
+}
+
+func (r *RunnerRelease) Format() types.RunnerReleaseResp {
+	return types.RunnerReleaseResp{
+		ID:        r.ID,
+		Version:   r.Version,
+		OS:        r.OS,
+		Arch:      r.Arch,
+		Checksum:  r.Checksum,
+		Size:      r.Size,
+		CreatedAt: r.CreatedAt.Format(time.DateTime),
+	}
+}
@@ -0,0 +1,25 @@
+package dal
+
+import (
+	"time"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+// RunnerToken 管理员签发的 runner 注册令牌
+type RunnerToken struct {
+	gorm.Model
+	Token  string `gorm:"size:64;uniqueIndex"`
+	Remark string
+}
+
+func (t *RunnerToken) Format() types.RunnerTokenResp {
+	return types.RunnerTokenResp{
+		ID:        t.ID,
+		Token:     t.Token,
+		Remark:    t.Remark,
+		CreatedAt: t.CreatedAt.Format(time.DateTime),
+	}
+}
@@ -0,0 +1,162 @@
+package dal
+
+import (
+	"fmt"
+
+	"cicd-server/utils"
+
+	"gorm.io/gorm"
+)
+
+// git 密码和 secret 变量在数据库中只保存主密钥加密后的值，下发任务时才解密
+
+func (g *Git) BeforeSave(tx *gorm.DB) error {
+	password, err := utils.EncryptSecret(g.Password)
+	if err != nil {
+		return err
+	}
+	g.Password = password
+	return nil
+}
+
+func (p *Pipeline) BeforeSave(tx *gorm.DB) error {
+	envs, err := p.Envs.Encrypt()
+	if err != nil {
+		return err
+	}
+	p.Envs = envs
+	return nil
+}
+
+func (j *Job) BeforeSave(tx *gorm.DB) error {
+	envs, err := j.Envs.Encrypt()
+	if err != nil {
+		return err
+	}
+	j.Envs = envs
+	return nil
+}
+
+// PlainPassword 解密后的 git 密码
+func (g *Git) PlainPassword() (string, error) {
+	return utils.DecryptSecret(g.Password)
+}
+
+// Encrypt 返回 secret 变量加密后的副本
+func (envs Envs) Encrypt() (Envs, error) {
+	return envs.convert(utils.EncryptSecret)
+}
+
+// Decrypt 返回 secret 变量解密后的副本
+func (envs Envs) Decrypt() (Envs, error) {
+	return envs.convert(utils.DecryptSecret)
+}
+
+func (envs Envs) convert(fn func(string) (string, error)) (Envs, error) {
+	if envs == nil {
+		return nil, nil
+	}
+	converted := make(Envs, len(envs))
+	for i, env := range envs {
+		if env.Secret {
+			val, err := fn(env.Val)
+			if err != nil {
+				return nil, fmt.Errorf("env %s: %w", env.Key, err)
+			}
+			env.Val = val
+		}
+		converted[i] = env
+	}
+	return converted, nil
+}
+
+// initSecrets 加载主密钥，并加密旧版本以明文保存的 git 密码和 secret 变量
+func initSecrets(db *gorm.DB) error {
+	if err := utils.InitSecretKey(); err != nil {
+		return err
+	}
+	return reencryptSecrets(db, func(value string) (string, error) {
+		if utils.IsEncrypted(value) {
+			// 启动时确认主密钥正确，避免下发任务时才发现无法解密
+			if _, err := utils.DecryptSecret(value); err != nil {
+				return "", err
+			}
+			return value, nil
+		}
+		return utils.EncryptSecret(value)
+	})
+}
+
+// reencryptSecrets 用 convert 转换所有 git 密码和 secret 变量（包括已删除的记录）后写回，
+// 直接更新字段，不触发钩子，也不修改更新时间
+func reencryptSecrets(db *gorm.DB, convert func(string) (string, error)) error {
+	var gits []Git
+	if err := db.Unscoped().Select("id", "password").Find(&gits, "password != ''").Error; err != nil {
+		return err
+	}
+	for _, git := range gits {
+		password, err := convert(git.Password)
+		if err != nil {
+			return fmt.Errorf("git %d: %w", git.ID, err)
+		}
+		if password != git.Password {
+			if err := db.Unscoped().Model(&Git{}).Where("id = ?", git.ID).UpdateColumn("password", password).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	var pipelines []Pipeline
+	if err := db.Unscoped().Select("id", "envs").Find(&pipelines).Error; err != nil {
+		return err
+	}
+	for _, p := range pipelines {
+		if err := reencryptEnvs(db, &Pipeline{}, p.ID, p.Envs, convert); err != nil {
+			return fmt.Errorf("pipeline %d: %w", p.ID, err)
+		}
+	}
+
+	var jobs []Job
+	if err := db.Unscoped().Select("id", "envs").Find(&jobs).Error; err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if err := reencryptEnvs(db, &Job{}, j.ID, j.Envs, convert); err != nil {
+			return fmt.Errorf("job %d: %w", j.ID, err)
+		}
+	}
+	return nil
+}
+
+func reencryptEnvs(db *gorm.DB, model interface{}, id uint, envs Envs, convert func(string) (string, error)) error {
+	converted, err := envs.convert(convert)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for i := range envs {
+		changed = changed || envs[i].Val != converted[i].Val
+	}
+	if !changed {
+		return nil
+	}
+	return db.Unscoped().Model(model).Where("id = ?", id).UpdateColumn("envs", converted).Error
+}
+
+// RotateSecretKey 使用新的主密钥重新加密所有 git 密码和 secret 变量。
+// 需要在 server 停止时执行，成功后由调用方保存新的密钥
+func RotateSecretKey(newKey []byte) error {
+	oldKey, err := utils.CurrentSecretKey()
+	if err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return reencryptSecrets(tx, func(value string) (string, error) {
+			plain, err := utils.DecryptSecretWith(oldKey, value)
+			if err != nil {
+				return "", err
+			}
+			return utils.EncryptSecretWith(newKey, plain)
+		})
+	})
+}
@@ -6,6 +6,7 @@ import (
 
 	"cicd-server/types"
 
+	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
@@ -19,6 +20,18 @@ type Step struct {
 	RunnerLabelMatch   string
 	MultipleRunnerExec bool
 	Sort               int
+	MinDiskFree        int64  // MB，runner 工作目录剩余空间低于该值时不分配
+	Image              string // 不为空时在该镜像的容器中执行命令
+	Mode               StepMode
+	Interpreter        string // 脚本模式使用的解释器，例如 bash -euo pipefail、sh、python3
+	CacheKey           string // 依赖缓存 key 模板，例如 go-${hash(go.sum)}
+	CachePaths         ListString
+	Artifacts          ListString // 步骤成功后上传的构建产物，相对工作目录的 glob
+	ArtifactDownloads  ListString // 执行前下载哪些前序步骤的构建产物，填写步骤名称
+	AllowFailure       bool       // 失败时标记为已容忍的失败，流水线继续执行
+	CommandOptions     CommandOptions
+	TestReports        ListString          // 命令结束后解析的测试报告（JUnit XML 或 go test -json），相对工作目录的 glob
+	ProblemMatchers    ProblemMatcherRules // 步骤自定义的问题匹配规则，优先于全局规则
 }
 
 type ListString []string
@@ -40,6 +53,41 @@ func (list *ListString) Scan(input interface{}) error {
 	return nil
 }
 
+// CommandOption 按下标对应 Commands 中的命令
+type CommandOption struct {
+	ContinueOnError  bool  // 命令失败后继续执行后续命令，步骤仍视为成功
+	SuccessExitCodes []int // 视为成功的非零退出码
+}
+
+type CommandOptions []CommandOption
+
+// 实现 sql.Scanner 接口，Scan 将 value 扫描至 Jsonb
+func (j *CommandOptions) Scan(value interface{}) error {
+	val := make(CommandOptions, 0)
+	if err := json.Unmarshal(value.([]byte), &val); err != nil {
+		return err
+	}
+	*j = val
+	return nil
+}
+
+// 实现 driver.Valuer 接口，Value 返回 json value
+func (j CommandOptions) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return json.Marshal(CommandOptions{})
+	}
+	return json.Marshal(j)
+}
+
+type StepMode string
+
+const (
+	// StepModeCommand 每条命令单独执行
+	StepModeCommand StepMode = "command"
+	// StepModeScript 所有命令合并为一个脚本执行，命令之间共享 shell 状态
+	StepModeScript StepMode = "script"
+)
+
 type Trigger string
 
 const (
@@ -58,6 +106,22 @@ func (s *Step) Format() types.StepResp {
 		MultipleRunnerExec: s.MultipleRunnerExec,
 		Sort:               s.Sort,
 		CreatedAt:          s.CreatedAt,
+		MinDiskFree:        s.MinDiskFree,
+		Image:              s.Image,
+		Mode:               string(s.Mode),
+		Interpreter:        s.Interpreter,
+		CacheKey:           s.CacheKey,
+		CachePaths:         s.CachePaths,
+		Artifacts:          s.Artifacts,
+		ArtifactDownloads:  s.ArtifactDownloads,
+		AllowFailure:       s.AllowFailure,
+		CommandOptions: lo.Map(s.CommandOptions, func(item CommandOption, _ int) types.CommandOption {
+			return types.CommandOption{ContinueOnError: item.ContinueOnError, SuccessExitCodes: item.SuccessExitCodes}
+		}),
+		TestReports: s.TestReports,
+		ProblemMatchers: lo.Map(s.ProblemMatchers, func(item ProblemMatcherRule, _ int) types.ProblemMatcherRule {
+			return item.Format()
+		}),
 	}
 
 	var job Job
@@ -0,0 +1,118 @@
+package dal
+
+import (
+	"strings"
+
+	"cicd-server/types"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// 判断测试是否不稳定时参考的最近结果数
+	TestHistorySize = 20
+	// 最近的结果中通过和失败交替出现的次数达到该值时视为不稳定
+	testFlakyFlips = 3
+)
+
+// TestCase runner 上报的一次测试结果
+type TestCase struct {
+	gorm.Model
+	JobRunnerID uint `gorm:"index"`
+	JobID       uint
+	PipelineID  uint   `gorm:"index:idx_test_case_key"`
+	Suite       string `gorm:"index:idx_test_case_key"`
+	Name        string `gorm:"index:idx_test_case_key"`
+	Runner      string
+	File        string
+	Status      string
+	Duration    int64 // 毫秒
+	Message     string
+	// Flaky 写入时该测试是否被判定为不稳定
+	Flaky bool
+}
+
+// TestStat 按流水线汇总的测试历史，写入测试结果时增量更新
+type TestStat struct {
+	gorm.Model
+	PipelineID uint   `gorm:"uniqueIndex:idx_test_stat_key"`
+	Suite      string `gorm:"uniqueIndex:idx_test_stat_key"`
+	Name       string `gorm:"uniqueIndex:idx_test_stat_key"`
+	Runs       int
+	Failures   int
+	// History 最近 TestHistorySize 次结果，P 通过、F 失败、S 跳过，最新的在最后
+	History         string
+	LastStatus      string
+	LastCommitID    string
+	LastJobRunnerID uint
+	// SameCommitFlipRun 同一 commit 既通过又失败时的 Runs，用于在最近的结果中识别不稳定
+	SameCommitFlipRun int
+	Flaky             bool
+}
+
+func (c *TestCase) Format() types.TestCaseResp {
+	return types.TestCaseResp{
+		ID:          c.ID,
+		JobRunnerID: c.JobRunnerID,
+		Suite:       c.Suite,
+		Name:        c.Name,
+		Runner:      c.Runner,
+		File:        c.File,
+		Status:      c.Status,
+		Duration:    c.Duration,
+		Message:     c.Message,
+		Flaky:       c.Flaky,
+	}
+}
+
+func (s *TestStat) Format() types.TestStatResp {
+	return types.TestStatResp{
+		Suite:           s.Suite,
+		Name:            s.Name,
+		Runs:            s.Runs,
+		Failures:        s.Failures,
+		History:         s.History,
+		LastStatus:      s.LastStatus,
+		LastJobRunnerID: s.LastJobRunnerID,
+		Flaky:           s.Flaky,
+	}
+}
+
+// Record 把一次测试结果计入历史，返回测试是否不稳定。
+// 同一 commit 既通过又失败，或者最近的结果中通过和失败反复交替，都视为不稳定
+func (s *TestStat) Record(status, commitID string, jobRunnerID uint) bool {
+	mark := map[string]string{types.TestPassed: "P", types.TestFailed: "F", types.TestSkipped: "S"}[status]
+	s.Runs++
+	if status == types.TestFailed {
+		s.Failures++
+	}
+	if status != types.TestSkipped && s.LastStatus != types.TestSkipped && s.LastStatus != "" &&
+		status != s.LastStatus && commitID != "" && commitID == s.LastCommitID {
+		s.SameCommitFlipRun = s.Runs
+	}
+	s.History += mark
+	if len(s.History) > TestHistorySize {
+		s.History = s.History[len(s.History)-TestHistorySize:]
+	}
+	if status != types.TestSkipped {
+		s.LastStatus = status
+		s.LastCommitID = commitID
+	}
+	s.LastJobRunnerID = jobRunnerID
+
+	s.Flaky = (s.SameCommitFlipRun > 0 && s.Runs-s.SameCommitFlipRun < TestHistorySize) ||
+		flips(s.History) >= testFlakyFlips
+	return s.Flaky
+}
+
+// flips 忽略跳过的结果，统计通过和失败交替的次数
+func flips(history string) int {
+	history = strings.ReplaceAll(history, "S", "")
+	n := 0
+	for i := 1; i < len(history); i++ {
+		if history[i] != history[i-1] {
+			n++
+		}
+	}
+	return n
+}
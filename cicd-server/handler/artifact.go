@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// UploadArtifact 保存 runner 上传的构建产物，同一 runner 重复上传同名产物时覆盖
+func UploadArtifact(ctx context.Context, c *app.RequestContext) {
+	var req types.UploadArtifactReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !assignedToRunner(c, req.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner is not assigned to this runner"})
+		return
+	}
+
+	name := path.Clean(req.Name)
+	if path.IsAbs(name) || name == "." || strings.HasPrefix(name, "../") || name == ".." {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "invalid artifact name"})
+		return
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	var step dal.Step
+	if err := dal.DB.Unscoped().Last(&step, "id = ?", jobRunner.StepID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	r, _ := c.Get("runner")
+	runner := r.(dal.Runner)
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	file := filepath.Join(homeDir, ".cicd-server", "artifacts", fmt.Sprint(jobRunner.JobID), fmt.Sprint(jobRunner.ID), runner.Name, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	size, checksum, err := saveBody(c, file, req.Checksum)
+	if errors.Is(err, errChecksumMismatch) {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "artifact checksum mismatch"})
+		return
+	}
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	var artifact dal.Artifact
+	if err := dal.DB.Last(&artifact, "job_runner_id = ? AND runner_name = ? AND name = ?", jobRunner.ID, runner.Name, name).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	artifact.JobID = jobRunner.JobID
+	artifact.JobRunnerID = jobRunner.ID
+	artifact.StepID = step.ID
+	artifact.StepName = step.Name
+	artifact.RunnerName = runner.Name
+	artifact.Name = name
+	artifact.Path = file
+	artifact.Checksum = checksum
+	artifact.Size = size
+	artifact.ExpireAt = time.Now().Add(dal.ArtifactRetention())
+	if err := dal.DB.Save(&artifact).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// RunnerArtifacts 返回步骤需要下载的前序步骤构建产物
+func RunnerArtifacts(ctx context.Context, c *app.RequestContext) {
+	var req types.RunnerArtifactReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !assignedToRunner(c, req.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner is not assigned to this runner"})
+		return
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	artifacts := make([]dal.Artifact, 0)
+	if len(jobRunner.ArtifactDownloads) > 0 {
+		if err := dal.DB.Order("id ASC").Find(&artifacts, "job_id = ? AND step_name IN (?)", jobRunner.JobID, []string(jobRunner.ArtifactDownloads)).Error; err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(consts.StatusOK, lo.Map(artifacts, func(item dal.Artifact, _ int) types.ArtifactResp {
+		return item.Format()
+	}))
+}
+
+// RunnerDownloadArtifact runner 下载同一次任务中的构建产物
+func RunnerDownloadArtifact(ctx context.Context, c *app.RequestContext) {
+	var req types.RunnerArtifactReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !assignedToRunner(c, req.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner is not assigned to this runner"})
+		return
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	var artifact dal.Artifact
+	if err := dal.DB.Last(&artifact, "id = ? AND job_id = ?", req.ID, jobRunner.JobID).Error; err != nil {
+		c.JSON(consts.StatusNotFound, utils.H{"error": "artifact not found"})
+		return
+	}
+	c.File(artifact.Path)
+}
+
+func JobArtifacts(ctx context.Context, c *app.RequestContext) {
+	var req types.JobArtifactReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var artifacts []dal.Artifact
+	if err := dal.DB.Order("id ASC").Find(&artifacts, "job_id = ?", req.JobID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, lo.Map(artifacts, func(item dal.Artifact, _ int) types.ArtifactResp {
+		return item.Format()
+	}))
+}
+
+func DownloadArtifact(ctx context.Context, c *app.RequestContext) {
+	var req types.PathArtifactReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var artifact dal.Artifact
+	if err := dal.DB.Last(&artifact, "id = ?", req.ID).Error; err != nil {
+		c.JSON(consts.StatusNotFound, utils.H{"error": "artifact not found"})
+		return
+	}
+	c.Header("X-Cicd-Checksum", artifact.Checksum)
+	c.FileAttachment(artifact.Path, path.Base(artifact.Name))
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// maxBodySize 除上传接口外的请求体上限，与 hertz 默认值相同
+const maxBodySize = 4 << 20
+
+var errChecksumMismatch = errors.New("content checksum mismatch")
+
+// LimitBody server 开启了流式请求体，hertz 不再限制大小，
+// 除 streamBody 允许的上传接口外，请求体超过 maxBodySize 时直接拒绝
+func LimitBody(ctx context.Context, c *app.RequestContext) {
+	if streamBody(c) {
+		c.Next(ctx)
+		return
+	}
+	switch n := c.Request.Header.ContentLength(); {
+	case n > maxBodySize:
+		c.AbortWithStatusJSON(consts.StatusRequestEntityTooLarge, utils.H{"error": "request body too large"})
+		return
+	case n == -1:
+		// chunked 请求无法提前知道大小
+		c.AbortWithStatusJSON(consts.StatusLengthRequired, utils.H{"error": "content length required"})
+		return
+	}
+	c.Next(ctx)
+}
+
+// streamBody 请求体是否边读边写入文件，不读入内存。
+// runner 上传需要带上签名过的 X-Cicd-Content-Sha256，旧版本 runner 仍按普通请求体处理
+func streamBody(c *app.RequestContext) bool {
+	switch c.FullPath() {
+	case "/api/upload_runner_release":
+		return true
+	case "/api/cache/upload", "/api/artifacts/:job_runner_id/upload":
+		return len(c.GetHeader(cutils.HeaderContentSha256)) > 0
+	}
+	return false
+}
+
+// saveBody 把请求体写入 file，返回大小和 sha256。
+// 内容与 X-Cicd-Content-Sha256 或 expected 不一致时不写入
+func saveBody(c *app.RequestContext, file, expected string) (int64, string, error) {
+	tmp := file + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var src io.Reader
+	if c.Request.IsBodyStream() {
+		src = c.Request.BodyStream()
+	} else {
+		src = bytes.NewReader(c.Request.Body())
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	dst.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return 0, "", err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	header := string(c.GetHeader(cutils.HeaderContentSha256))
+	if (header != "" && header != checksum) || (expected != "" && expected != checksum) {
+		os.Remove(tmp)
+		return 0, "", errChecksumMismatch
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return 0, "", err
+	}
+	return size, checksum, nil
+}
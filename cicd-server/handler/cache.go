@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+)
+
+var cacheKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+func cacheRoot() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".cicd-server", "cache"), nil
+}
+
+// cacheDir 缓存按流水线隔离，runner 只能读写分配给它的步骤所属流水线的缓存
+func cacheDir(c *app.RequestContext, jobRunnerID uint) (string, int, error) {
+	if !assignedToRunner(c, jobRunnerID) {
+		return "", consts.StatusForbidden, errors.New("job runner is not assigned to this runner")
+	}
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
+		return "", consts.StatusInternalServerError, err
+	}
+	var job dal.Job
+	if err := dal.DB.Last(&job, "id = ?", jobRunner.JobID).Error; err != nil {
+		return "", consts.StatusInternalServerError, err
+	}
+
+	root, err := cacheRoot()
+	if err != nil {
+		return "", consts.StatusInternalServerError, err
+	}
+	dir := filepath.Join(root, fmt.Sprint(job.PipelineID))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", consts.StatusInternalServerError, err
+	}
+	return dir, consts.StatusOK, nil
+}
+
+// cacheMaxSize server 共享缓存的总大小上限，通过 CICD_CACHE_MAX_SIZE 配置，单位 MB
+func cacheMaxSize() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("CICD_CACHE_MAX_SIZE"), 10, 64); err == nil && v > 0 {
+		return v << 20
+	}
+	return 20 << 30
+}
+
+// DownloadCache runner 本地缓存未命中时，从 server 获取精确匹配或同前缀最新的缓存
+func DownloadCache(ctx context.Context, c *app.RequestContext) {
+	var req types.CacheReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !cacheKeyPattern.MatchString(req.Key) || (req.Prefix != "" && !cacheKeyPattern.MatchString(req.Prefix)) {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "invalid cache key"})
+		return
+	}
+
+	store, status, err := cacheDir(c, req.JobRunnerID)
+	if err != nil {
+		c.JSON(status, utils.H{"error": err.Error()})
+		return
+	}
+
+	file := filepath.Join(store, req.Key+".tar.gz")
+	if _, err := os.Stat(file); err != nil {
+		file = ""
+		if req.Prefix != "" {
+			matches, _ := filepath.Glob(filepath.Join(store, req.Prefix+"*.tar.gz"))
+			var newestTime time.Time
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.ModTime().After(newestTime) {
+					file, newestTime = match, info.ModTime()
+				}
+			}
+		}
+	}
+	if file == "" {
+		c.JSON(consts.StatusNotFound, utils.H{"error": "cache not found"})
+		return
+	}
+
+	now := time.Now()
+	os.Chtimes(file, now, now)
+	c.Header("X-Cicd-Cache-Key", filepath.Base(file[:len(file)-len(".tar.gz")]))
+	c.File(file)
+}
+
+// UploadCache 保存 runner 上传的缓存，相同 key 已存在时忽略
+func UploadCache(ctx context.Context, c *app.RequestContext) {
+	var req types.CacheReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !cacheKeyPattern.MatchString(req.Key) {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "invalid cache key"})
+		return
+	}
+
+	store, status, err := cacheDir(c, req.JobRunnerID)
+	if err != nil {
+		c.JSON(status, utils.H{"error": err.Error()})
+		return
+	}
+
+	file := filepath.Join(store, req.Key+".tar.gz")
+	if _, err := os.Stat(file); err == nil {
+		c.JSON(consts.StatusOK, utils.H{"data": "success"})
+		return
+	}
+
+	if _, _, err := saveBody(c, file, ""); err != nil {
+		status := consts.StatusInternalServerError
+		if errors.Is(err, errChecksumMismatch) {
+			status = consts.StatusBadRequest
+		}
+		c.JSON(status, utils.H{"error": err.Error()})
+		return
+	}
+	evictCache()
+
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// evictCache 按最近使用时间淘汰所有流水线的缓存，直到总大小不超过上限，
+// 旧版本不区分流水线保存的缓存也一起参与淘汰
+func evictCache() {
+	root, err := cacheRoot()
+	if err != nil {
+		hlog.Warnf("evict cache error: %s", err)
+		return
+	}
+	legacy, _ := filepath.Glob(filepath.Join(root, "*.tar.gz"))
+	matches, _ := filepath.Glob(filepath.Join(root, "*", "*.tar.gz"))
+	matches = append(matches, legacy...)
+
+	infos := make(map[string]os.FileInfo, len(matches))
+	var total int64
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil {
+			infos[match] = info
+			total += info.Size()
+		}
+	}
+	files := lo.Keys(infos)
+	sort.Slice(files, func(i, j int) bool {
+		return infos[files[i]].ModTime().Before(infos[files[j]].ModTime())
+	})
+
+	limit := cacheMaxSize()
+	for _, file := range files {
+		if total <= limit {
+			return
+		}
+		if err := os.Remove(file); err != nil {
+			hlog.Warnf("evict cache error: %s", err)
+			continue
+		}
+		total -= infos[file].Size()
+		hlog.Infof("evict cache: %s", file)
+	}
+}
@@ -1,11 +1,12 @@
 package handler
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"fmt"
-	"os"
-	"path/filepath"
+	"io"
 
+	"cicd-server/dal"
 	jobexec "cicd-server/job_exec"
 	"cicd-server/types"
 
@@ -21,8 +22,15 @@ func Events(ctx context.Context, c *app.RequestContext) {
 		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
 		return
 	}
+	if !assignedToRunner(c, event.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner not assigned to this runner"})
+		return
+	}
 
+	// 事件消息会出现在步骤详情中，同样需要脱敏
+	event.Message = logMasker(event.JobRunnerID).Mask(event.Message)
 	jobexec.AddEvent(&event)
+	flushLogsLater(event.JobRunnerID)
 
 	c.JSON(consts.StatusOK, utils.H{"data": "success"})
 }
@@ -33,57 +41,70 @@ func Log(ctx context.Context, c *app.RequestContext) {
 		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
 		return
 	}
+	if !assignedToRunner(c, log.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner not assigned to this runner"})
+		return
+	}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		hlog.Errorf("get home dir error: %s", err)
+	// 旧版本 runner 上报的是已经带有时间和 runner 名称的文本
+	if err := appendRecords(log.JobRunnerID, []types.LogRecord{{Stream: types.LogSystem, Command: -1, Text: log.Log}}); err != nil {
+		hlog.Errorf("append log error: %s", err)
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
+	jobexec.NotifyLog(log.JobRunnerID)
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
 
-	logPath := filepath.Join(homeDir, ".cicd-runner", "logs", fmt.Sprintf("%d.log", log.JobRunnerID))
-	_, err = os.Stat(logPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(filepath.Dir(logPath), os.ModePerm); err != nil {
-				hlog.Errorf("mkdir error: %s", err)
-				c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
-				return
-			}
-			file, err := os.Create(logPath)
-			if err != nil {
-				hlog.Errorf("create file error: %s", err)
-				c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
-				return
-			}
-			defer file.Close()
-			_, err = file.WriteString(log.Log + "\n")
-			if err != nil {
-				hlog.Errorf("write file error: %s", err)
-				c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
-				return
-			}
-			c.JSON(consts.StatusOK, utils.H{"data": "success"})
-			return
-		} else {
-			hlog.Errorf("stat file error: %s", err)
-			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
-			return
-		}
+// LogBatch 接收 runner 批量上报的日志，按 stream 内的序号去重并按顺序写入
+func LogBatch(ctx context.Context, c *app.RequestContext) {
+	if err := gunzipBody(c, logBatchMaxSize); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
 	}
 
-	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		hlog.Errorf("open file error: %s", err)
-		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+	var batch types.LogBatch
+	if err := c.BindAndValidate(&batch); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
 		return
 	}
-	defer file.Close()
-	_, err = file.WriteString(log.Log + "\n")
-	if err != nil {
-		hlog.Errorf("write file error: %s", err)
+	for _, line := range batch.Lines {
+		batch.Records = append(batch.Records, types.LogRecord{Seq: line.Seq, Stream: types.LogSystem, Command: -1, Text: line.Log})
+	}
+	// 无法识别的格式解析后为空，返回错误让 runner 知道日志没有写入
+	if len(batch.Records) == 0 {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "log batch has no records"})
+		return
+	}
+	if !assignedToRunner(c, batch.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner not assigned to this runner"})
+		return
+	}
+
+	runner := c.MustGet("runner").(dal.Runner)
+	if err := writeLogBatch(&batch, runner.Name); err != nil {
+		hlog.Errorf("write log batch error: %s", err)
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
+	jobexec.NotifyLog(batch.JobRunnerID)
 	c.JSON(consts.StatusOK, utils.H{"data": "success"})
 }
+
+// gunzipBody 解压 runner 以 gzip 压缩上报的请求体，解压后超过 limit 的部分被截断
+func gunzipBody(c *app.RequestContext, limit int64) error {
+	if string(c.Request.Header.Peek("Content-Encoding")) != "gzip" {
+		return nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(c.Request.Body()))
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(io.LimitReader(reader, limit))
+	if err != nil {
+		return err
+	}
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.SetBody(body)
+	return nil
+}
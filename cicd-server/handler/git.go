@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 
+	"cicd-server/dal"
 	"cicd-server/git"
 	"cicd-server/types"
 
@@ -18,6 +19,19 @@ func TestGit(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	if req.Password == "" && req.PipelineID > 0 && !req.ClearPassword {
+		// 只有仓库地址不变时才使用保存的密码，避免把凭据发送到其他地址
+		var saved dal.Git
+		if err := dal.DB.Last(&saved, "pipeline_id = ?", req.PipelineID).Error; err == nil && saved.Repository == req.Repository {
+			password, err := saved.PlainPassword()
+			if err != nil {
+				c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+				return
+			}
+			req.Password = password
+		}
+	}
+
 	lastCommit, err := git.RepoLastCommit(req.Repository, req.Branch, req.Username, req.Password)
 	if err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
@@ -1,14 +1,14 @@
 package handler
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +16,7 @@ import (
 	"cicd-server/dal"
 	gitutils "cicd-server/git"
 	jobexec "cicd-server/job_exec"
+	"cicd-server/logstore"
 	"cicd-server/types"
 	cutils "cicd-server/utils"
 
@@ -108,24 +109,33 @@ func StartJob(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
-	mapEnv := make(map[string]string)
+	mapEnv := make(map[string]dal.Env)
 	for _, env := range pipeline.Envs {
-		mapEnv[env.Key] = env.Val
+		mapEnv[env.Key] = env
 	}
 	for _, env := range job.Envs {
-		mapEnv[env.Key] = env.Val
-	}
-
-	var envs []dal.Env
-	for k, v := range mapEnv {
-		envs = append(envs, dal.Env{
-			Key: k,
-			Val: v,
-		})
+		// 接口不返回 secret 变量的值，触发时留空表示使用流水线保存的值
+		if saved, ok := mapEnv[env.Key]; ok && saved.Secret && env.Val == "" {
+			continue
+		}
+		// 触发时覆盖流水线的 secret 变量，新值同样按 secret 处理
+		env.Secret = env.Secret || mapEnv[env.Key].Secret
+		if err := checkSecrets(types.Envs{env}, ""); err != nil {
+			c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+			return
+		}
+		mapEnv[env.Key] = dal.Env{
+			Key:    env.Key,
+			Val:    env.Val,
+			Secret: env.Secret,
+		}
 	}
+	envs := lo.Values(mapEnv)
 	j := dal.Job{
 		PipelineID: job.PipelineID,
 		Envs:       envs,
+		Priority:   lo.FromPtrOr(job.Priority, pipeline.Priority),
+		GroupName:  pipeline.GroupName,
 	}
 
 	var git dal.Git
@@ -173,15 +183,26 @@ func StartJob(ctx context.Context, c *app.RequestContext) {
 			}
 
 			runner := dal.JobRunner{
-				JobID:         j.ID,
-				StageID:       step.StageID,
-				StepID:        step.ID,
-				StepSort:      step.Sort,
-				Parallel:      stepParallel,
-				Status:        status,
-				Trigger:       step.Trigger,
-				Commands:      step.Commands,
-				TriggerUserId: user.Id,
+				JobID:             j.ID,
+				StageID:           step.StageID,
+				StepID:            step.ID,
+				StepSort:          step.Sort,
+				Parallel:          stepParallel,
+				Status:            status,
+				Trigger:           step.Trigger,
+				Commands:          step.Commands,
+				Image:             step.Image,
+				Mode:              step.Mode,
+				Interpreter:       step.Interpreter,
+				CacheKey:          step.CacheKey,
+				CachePaths:        step.CachePaths,
+				Artifacts:         step.Artifacts,
+				ArtifactDownloads: step.ArtifactDownloads,
+				AllowFailure:      step.AllowFailure,
+				CommandOptions:    step.CommandOptions,
+				TestReports:       step.TestReports,
+				ProblemMatchers:   step.ProblemMatchers,
+				TriggerUserId:     user.Id,
 			}
 			if err := tx.Create(&runner).Error; err != nil {
 				return err
@@ -207,7 +228,12 @@ func StartJob(ctx context.Context, c *app.RequestContext) {
 			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 			return
 		}
-		commit, err := gitutils.RepoLastCommit(git.Repository, git.Branch, git.Username, git.Password)
+		password, err := git.PlainPassword()
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+		commit, err := gitutils.RepoLastCommit(git.Repository, git.Branch, git.Username, password)
 		if err != nil {
 			runners[0].Status = dal.Failed
 			runners[0].Message = err.Error()
@@ -262,7 +288,7 @@ func StartJobStep(ctx context.Context, c *app.RequestContext) {
 	}
 
 	switch jobRunner.Status {
-	case dal.Success, dal.Failed, dal.PartialSuccess, dal.Canceled:
+	case dal.Success, dal.Failed, dal.PartialSuccess, dal.Canceled, dal.AllowedFailure:
 		if err := dal.DB.Transaction(func(tx *gorm.DB) error {
 			jobRunner.ID = 0
 			jobRunner.Status = dal.Queueing
@@ -412,43 +438,85 @@ func JobRunnerDetail(ctx context.Context, c *app.RequestContext) {
 		Job:        job.Format(),
 		JobRunner:  jr,
 	}
+	if tests, err := testSummary(runner.JobRunnerID); err == nil {
+		resp.Tests = tests
+	} else {
+		hlog.Errorf("get test summary error: %s", err)
+	}
+	if annotations, err := jobRunnerAnnotations(runner.JobRunnerID); err == nil {
+		resp.Annotations = annotations
+	} else {
+		hlog.Errorf("get annotations error: %s", err)
+	}
 
 	c.JSON(consts.StatusOK, resp)
 }
 
+// JobRunnerLog 返回步骤的完整日志，format=json 时返回结构化的日志记录；
+// 带 offset 参数时返回 offset 之后的日志片段，供不支持 SSE 的客户端轮询
 func JobRunnerLog(ctx context.Context, c *app.RequestContext) {
-	var log types.Log
-	if err := c.BindAndValidate(&log); err != nil {
+	var req types.LogStreamReq
+	if err := c.BindAndValidate(&req); err != nil {
 		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
 		return
 	}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		hlog.Errorf("get home dir error: %s", err)
-		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+	if c.Query("offset") != "" {
+		var jobRunner dal.JobRunner
+		if err := dal.DB.Select("status").Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+		log, next, err := readLog(req.JobRunnerID, req.Offset)
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+		resp := types.LogChunkResp{
+			Offset:   next,
+			Status:   string(jobRunner.Status),
+			Finished: jobRunner.Status.Finished(),
+		}
+		if log != "" {
+			if req.Format == "json" {
+				resp.Records = parseRecords(log)
+			} else {
+				resp.Log = renderText(parseRecords(log))
+			}
+		}
+		c.JSON(consts.StatusOK, resp)
 		return
 	}
 
-	logPath := filepath.Join(homeDir, ".cicd-runner", "logs", fmt.Sprintf("%d.log", log.JobRunnerID))
-	_, err = os.Stat(logPath)
+	reader, err := logstore.Default.Open(req.JobRunnerID)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if err == logstore.ErrNotExist {
 			c.JSON(consts.StatusInternalServerError, utils.H{"error": "log not found"})
 			return
 		}
+		hlog.Errorf("open log error: %s", err)
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
+	defer reader.Close()
 
-	file, err := os.ReadFile(logPath)
+	log, err := io.ReadAll(reader)
 	if err != nil {
-		hlog.Errorf("open file error: %s", err)
+		hlog.Errorf("read log error: %s", err)
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(consts.StatusOK, string(file))
+	if len(log) == 0 {
+		c.JSON(consts.StatusOK, lo.Ternary[any](req.Format == "json", []types.LogRecord{}, ""))
+		return
+	}
+	records := parseRecords(string(log))
+	if req.Format == "json" {
+		c.JSON(consts.StatusOK, records)
+		return
+	}
+	c.JSON(consts.StatusOK, renderText(records))
 }
 
 func CancelJobRunner(ctx context.Context, c *app.RequestContext) {
@@ -471,7 +539,7 @@ func CancelJobRunner(ctx context.Context, c *app.RequestContext) {
 		if jobRunner.Status == dal.Success {
 			return errors.New("job runner already success")
 		}
-		if jobRunner.Status == dal.Failed {
+		if jobRunner.Status == dal.Failed || jobRunner.Status == dal.AllowedFailure {
 			return errors.New("job runner already failed")
 		}
 
@@ -510,14 +578,21 @@ func CancelJobRunner(ctx context.Context, c *app.RequestContext) {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
+	jobexec.NotifyLog(jobRunner.ID)
 
 	c.JSON(consts.StatusOK, utils.H{"data": "success"})
 }
 
 func cancelJob(runner *dal.Runner, jobRunnerID uint) error {
+	return callRunner(runner, "/cancel_job/"+strconv.Itoa(int(jobRunnerID)), nil)
+}
+
+// callRunner 向 runner 发送签名请求，连接失败时把 runner 标记为离线
+func callRunner(runner *dal.Runner, path string, body []byte) error {
 	client := &http.Client{}
-	httpReq, _ := http.NewRequest("POST", runner.Endpoint+"/cancel_job/"+strconv.Itoa(int(jobRunnerID)), nil)
+	httpReq, _ := http.NewRequest("POST", runner.Endpoint+path, bytes.NewReader(body))
 	httpReq.Header.Set("Content-Type", "application/json")
+	cutils.SignRequest(httpReq, runner.Name, runner.Secret, body)
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		if opErr, ok := err.(*net.OpError); ok {
@@ -536,7 +611,7 @@ func cancelJob(runner *dal.Runner, jobRunnerID uint) error {
 		if resp.StatusCode == 404 {
 			dal.DB.Model(&dal.Runner{}).Where("id = ?", runner.ID).Update("status", dal.Offline)
 		}
-		return fmt.Errorf("send job failed, status code: %d", resp.StatusCode)
+		return fmt.Errorf("call runner %s failed, status code: %d", path, resp.StatusCode)
 	}
 
 	return nil
@@ -0,0 +1,417 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cicd-server/dal"
+	jobexec "cicd-server/job_exec"
+	"cicd-server/logstore"
+	"cicd-server/types"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+	"github.com/samber/lo"
+)
+
+const (
+	// 单次读取日志的上限，避免一次返回过大的内容
+	logReadLimit = 1 << 20
+	// 步骤结束后继续等待迟到日志的时间
+	logFinishWait     = 3 * time.Second
+	logStatusInterval = 5 * time.Second
+	logKeepalive      = 15 * time.Second
+	// 乱序等待的日志行超过该数量，或者缺失的行超过 logPendingTimeout 没有到达时跳过缺失的行直接写入
+	logPendingLimit   = 10000
+	logPendingTimeout = 10 * time.Second
+	// 解压后的日志批次大小上限
+	logBatchMaxSize = 64 << 20
+	// 步骤的脱敏规则缓存时间，过期后重新读取变量和 git 凭据
+	maskerTTL = 10 * time.Minute
+)
+
+var (
+	// 正在写入或有日志行等待写入的 stream，key 为 job runner id 和 stream
+	logStreams      = make(map[string]*logStream)
+	logStreamsMutex sync.Mutex
+
+	maskers     = make(map[uint]cachedMasker)
+	maskerMutex sync.Mutex
+)
+
+// logStream 一个步骤的一个 stream 的写入状态，同一 stream 的批次串行写入，不同 stream 互不阻塞
+type logStream struct {
+	mutex       sync.Mutex
+	key         string
+	jobRunnerID uint
+	stream      string
+	// 序号不连续的日志行，等前面的行到达后再写入
+	pending map[uint64]types.LogRecord
+	// 缺失的行等待超时后跳过
+	timer   *time.Timer
+	removed bool
+}
+
+type cachedMasker struct {
+	masker   *cutils.Masker
+	expireAt time.Time
+}
+
+// logMasker 返回步骤日志的脱敏规则。runner 上报前已经脱敏，这里再处理一次，
+// 避免旧版本 runner 或者 runner 之外的路径写入密钥
+func logMasker(jobRunnerID uint) *cutils.Masker {
+	maskerMutex.Lock()
+	defer maskerMutex.Unlock()
+
+	now := time.Now()
+	if cached, ok := maskers[jobRunnerID]; ok && now.Before(cached.expireAt) {
+		return cached.masker
+	}
+	for id, cached := range maskers {
+		if now.After(cached.expireAt) {
+			delete(maskers, id)
+		}
+	}
+
+	var jobRunner dal.JobRunner
+	var job dal.Job
+	if err := dal.DB.Select("job_id").Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
+		hlog.Errorf("get job runner error: %s", err)
+		return nil
+	}
+	if err := dal.DB.Last(&job, "id = ?", jobRunner.JobID).Error; err != nil {
+		hlog.Errorf("get job error: %s", err)
+		return nil
+	}
+	masker := cutils.NewMasker(job.Secrets()...)
+	maskers[jobRunnerID] = cachedMasker{masker: masker, expireAt: now.Add(maskerTTL)}
+	return masker
+}
+
+// appendRecords 脱敏后把日志记录按行以 JSON 格式追加到步骤的日志，加入全文索引并提取注解
+func appendRecords(jobRunnerID uint, records []types.LogRecord) error {
+	masker := logMasker(jobRunnerID)
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for i := range records {
+		records[i].Text = masker.Mask(records[i].Text)
+		if err := encoder.Encode(records[i]); err != nil {
+			return err
+		}
+	}
+	if err := logstore.Default.Append(jobRunnerID, buf.Bytes()); err != nil {
+		return err
+	}
+	indexRecords(jobRunnerID, records)
+	annotateRecords(jobRunnerID, records)
+	return nil
+}
+
+// parseRecords 解析日志文件中的行，不是 JSON 的行（迁移前的纯文本日志）作为完整的文本行
+func parseRecords(data string) []types.LogRecord {
+	var records []types.LogRecord
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		var record types.LogRecord
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &record) != nil {
+			record = types.LogRecord{Stream: types.LogSystem, Command: -1, Text: line}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// renderText 按旧版本的纯文本格式输出日志：时间 [runner] 内容，命令段的结束标记不输出
+func renderText(records []types.LogRecord) string {
+	var b strings.Builder
+	for _, record := range records {
+		b.WriteString(recordText(record))
+	}
+	return b.String()
+}
+
+// recordText 一条日志记录的纯文本行，命令段的结束标记返回空
+func recordText(record types.LogRecord) string {
+	if record.Type == types.LogSectionEnd {
+		return ""
+	}
+	if record.Time > 0 && record.Text != "" {
+		return fmt.Sprintf("%s [%s] %s\n", time.UnixMilli(record.Time).Format("2006/01/02 15:04:05"), record.Runner, record.Text)
+	}
+	return record.Text + "\n"
+}
+
+// formatLog 把日志文件中的行转换为 text 或 json 格式，每个元素为一行
+func formatLog(log, format string) []string {
+	records := parseRecords(log)
+	if format == "json" {
+		lines := make([]string, 0, len(records))
+		for _, record := range records {
+			data, _ := json.Marshal(record)
+			lines = append(lines, string(data))
+		}
+		return lines
+	}
+	text := renderText(records)
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// lockLogStream 返回加锁后的 stream 写入状态
+func lockLogStream(jobRunnerID uint, stream string) *logStream {
+	key := fmt.Sprintf("%d/%s", jobRunnerID, stream)
+	for {
+		logStreamsMutex.Lock()
+		s, ok := logStreams[key]
+		if !ok {
+			s = &logStream{key: key, jobRunnerID: jobRunnerID, stream: stream, pending: make(map[uint64]types.LogRecord)}
+			logStreams[key] = s
+		}
+		logStreamsMutex.Unlock()
+
+		s.mutex.Lock()
+		// 等待锁期间已被移除时重新获取
+		if !s.removed {
+			return s
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// writeLogBatch 丢弃已写入的重复行，把从游标开始连续的行按序号写入并推进游标
+func writeLogBatch(batch *types.LogBatch, runner string) error {
+	s := lockLogStream(batch.JobRunnerID, batch.Stream)
+	defer s.mutex.Unlock()
+
+	cursor := dal.LogCursor{JobRunnerID: batch.JobRunnerID, Stream: batch.Stream}
+	if err := dal.DB.Where(&cursor).FirstOrCreate(&cursor).Error; err != nil {
+		return err
+	}
+	for _, record := range batch.Records {
+		if record.Seq > cursor.Seq {
+			record.Runner = runner
+			s.pending[record.Seq] = record
+		}
+	}
+	return s.write(cursor, len(s.pending) > logPendingLimit)
+}
+
+// flushLogStream 跳过缺失的行，写入 stream 中等待的全部日志行，失败时稍后重试
+func flushLogStream(jobRunnerID uint, stream string) {
+	s := lockLogStream(jobRunnerID, stream)
+	defer s.mutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	cursor := dal.LogCursor{JobRunnerID: jobRunnerID, Stream: stream}
+	err := dal.DB.Where(&cursor).FirstOrCreate(&cursor).Error
+	if err == nil {
+		err = s.write(cursor, true)
+	}
+	if err != nil {
+		hlog.Errorf("flush log of job runner %d stream %s error: %s", jobRunnerID, stream, err)
+		s.timer = time.AfterFunc(logPendingTimeout, func() { flushLogStream(jobRunnerID, stream) })
+		return
+	}
+	jobexec.NotifyLog(jobRunnerID)
+}
+
+// flushLogsLater 步骤在 runner 上结束后，等待迟到的日志一段时间，再写入仍在等待缺失行的日志
+func flushLogsLater(jobRunnerID uint) {
+	time.AfterFunc(logFinishWait, func() {
+		logStreamsMutex.Lock()
+		var streams []string
+		for _, s := range logStreams {
+			if s.jobRunnerID == jobRunnerID {
+				streams = append(streams, s.stream)
+			}
+		}
+		logStreamsMutex.Unlock()
+
+		for _, stream := range streams {
+			flushLogStream(jobRunnerID, stream)
+		}
+	})
+}
+
+// write 把从游标开始连续的行写入并推进游标，skip 为 true 时跳过缺失的行写入全部等待的行。调用方持有 s.mutex
+func (s *logStream) write(cursor dal.LogCursor, skip bool) error {
+	var records []types.LogRecord
+	next := cursor.Seq + 1
+	for record, ok := s.pending[next]; ok; record, ok = s.pending[next] {
+		records = append(records, record)
+		next++
+	}
+	// 缺失的行迟迟没有到达时不再等待，避免后面的日志一直不可见或者丢失
+	if skip {
+		seqs := lo.Filter(lo.Keys(s.pending), func(seq uint64, _ int) bool { return seq >= next })
+		if len(seqs) > 0 {
+			sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+			hlog.Warnf("job runner %d stream %s: skip missing log lines %d-%d", s.jobRunnerID, s.stream, next, seqs[0]-1)
+			for _, seq := range seqs {
+				records = append(records, s.pending[seq])
+			}
+			next = seqs[len(seqs)-1] + 1
+		}
+	}
+
+	if len(records) > 0 {
+		if err := appendRecords(s.jobRunnerID, records); err != nil {
+			return err
+		}
+		if err := dal.DB.Model(&cursor).Update("seq", next-1).Error; err != nil {
+			return err
+		}
+	}
+	for seq := range s.pending {
+		if seq < next {
+			delete(s.pending, seq)
+		}
+	}
+
+	if len(s.pending) > 0 {
+		if s.timer == nil {
+			jobRunnerID, stream := s.jobRunnerID, s.stream
+			s.timer = time.AfterFunc(logPendingTimeout, func() { flushLogStream(jobRunnerID, stream) })
+		}
+		return nil
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	logStreamsMutex.Lock()
+	delete(logStreams, s.key)
+	logStreamsMutex.Unlock()
+	s.removed = true
+	return nil
+}
+
+// readLog 从 offset 开始读取完整的日志行，返回内容和下一次读取的 offset
+func readLog(jobRunnerID uint, offset int64) (string, int64, error) {
+	data, err := logstore.Default.Read(jobRunnerID, offset, logReadLimit)
+	if err != nil {
+		return "", offset, err
+	}
+	// 只返回以换行结尾的完整行，未写完的行留到下一次读取
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return "", offset, nil
+	}
+	return string(data[:end+1]), offset + int64(end+1), nil
+}
+
+// JobRunnerLogStream 以 SSE 推送步骤日志：先发送 offset 之后的已有日志，再跟随新日志，
+// 步骤结束后发送 status 事件并关闭连接。事件 id 为下一次读取的 offset，断线重连时通过 Last-Event-ID 续传。
+func JobRunnerLogStream(ctx context.Context, c *app.RequestContext) {
+	var req types.LogStreamReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	offset := req.Offset
+	if lastEventID, err := strconv.ParseInt(string(c.GetHeader("Last-Event-ID")), 10, 64); err == nil && lastEventID > offset {
+		offset = lastEventID
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	notify, unsubscribe := jobexec.SubscribeLog(req.JobRunnerID)
+	defer unsubscribe()
+
+	c.SetStatusCode(consts.StatusOK)
+	c.Response.Header.Set("Content-Type", "text/event-stream")
+	c.Response.Header.Set("Cache-Control", "no-cache")
+	c.Response.Header.Set("X-Accel-Buffering", "no")
+	c.Response.HijackWriter(resp.NewChunkedBodyWriter(&c.Response, c.GetWriter()))
+
+	keepalive := time.NewTicker(logKeepalive)
+	defer keepalive.Stop()
+	statusCheck := time.NewTicker(logStatusInterval)
+	defer statusCheck.Stop()
+
+	// 连接时步骤已结束则发送完日志后立即结束，否则等待迟到的日志
+	var finishedAt time.Time
+	if jobRunner.Status.Finished() {
+		finishedAt = time.Now().Add(-logFinishWait)
+	}
+	for {
+		log, next, err := readLog(req.JobRunnerID, offset)
+		if err != nil {
+			hlog.Errorf("read log error: %s", err)
+			return
+		}
+		if next > offset {
+			offset = next
+			if !finishedAt.IsZero() && time.Since(finishedAt) < logFinishWait {
+				finishedAt = time.Now()
+			}
+			var b strings.Builder
+			fmt.Fprintf(&b, "id: %d\n", offset)
+			for _, line := range formatLog(log, req.Format) {
+				fmt.Fprintf(&b, "data: %s\n", line)
+			}
+			b.WriteString("\n")
+			if !writeEvent(c, b.String()) {
+				return
+			}
+			// 还有未读完的日志时立即继续读取
+			continue
+		}
+
+		if jobRunner.Status.Finished() {
+			if finishedAt.IsZero() {
+				finishedAt = time.Now()
+			} else if time.Since(finishedAt) >= logFinishWait {
+				writeEvent(c, fmt.Sprintf("event: status\nid: %d\ndata: %s\n\n", offset, jobRunner.Status))
+				return
+			}
+		}
+
+		var wait <-chan time.Time
+		if !finishedAt.IsZero() {
+			wait = time.After(time.Until(finishedAt.Add(logFinishWait)))
+		}
+		select {
+		case <-wait:
+		case <-notify:
+		case <-statusCheck.C:
+			if err := dal.DB.Select("status").Last(&jobRunner, "id = ?", req.JobRunnerID).Error; err != nil {
+				hlog.Errorf("get job runner status error: %s", err)
+			}
+		case <-keepalive.C:
+			if !writeEvent(c, ": keepalive\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent 写入并立即发送 SSE 事件，返回 false 表示客户端已断开
+func writeEvent(c *app.RequestContext, event string) bool {
+	c.WriteString(event)
+	if err := c.Flush(); err != nil {
+		hlog.Infof("log stream closed: %s", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cicd-server/dal"
+	"cicd-server/logstore"
+	"cicd-server/types"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// ansiPattern 匹配 CSI（颜色、光标移动）、OSC（窗口标题、超链接）和其他两字节的终端控制序列
+var ansiPattern = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+var errInvalidRange = errors.New("invalid range")
+
+// writeRawLog 把日志文件中的记录逐行渲染为纯文本写入 w，不会把整个日志读入内存。
+// tail 大于 0 时只输出最后 tail 行
+func writeRawLog(w io.Writer, reader io.Reader, stripANSI bool, tail int) error {
+	bw := bufio.NewWriterSize(w, 64<<10)
+	br := bufio.NewReaderSize(reader, 64<<10)
+	var ring []string
+	next := 0
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			text := renderText(parseRecords(line))
+			if stripANSI {
+				text = ansiPattern.ReplaceAllString(text, "")
+			}
+			switch {
+			case text == "":
+			case tail <= 0:
+				if _, err := bw.WriteString(text); err != nil {
+					return err
+				}
+			case len(ring) < tail:
+				ring = append(ring, text)
+			default:
+				ring[next] = text
+				next = (next + 1) % tail
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := range ring {
+		if _, err := bw.WriteString(ring[(next+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// parseRange 解析单个字节范围，返回闭区间。没有 Range 或者包含多个范围时 ok 为 false，按完整内容返回
+func parseRange(header string, size int64) (start, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, errInvalidRange
+	}
+	if first == "" {
+		// bytes=-n 表示最后 n 个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		return max(size-n, 0), size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, errInvalidRange
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.file.Close()
+}
+
+// JobRunnerRawLog 以 text/plain 返回步骤日志，支持 tail 和 Range 请求。
+// 没有 Range 时边渲染边返回；有 Range 时先渲染到临时文件以确定总长度
+func JobRunnerRawLog(ctx context.Context, c *app.RequestContext) {
+	var req types.RawLogReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	reader, err := logstore.Default.Open(req.JobRunnerID)
+	if err != nil {
+		if err == logstore.ErrNotExist {
+			c.JSON(consts.StatusNotFound, utils.H{"error": "log not found"})
+			return
+		}
+		hlog.Errorf("open log error: %s", err)
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.SetContentType("text/plain; charset=utf-8")
+	c.Header("Accept-Ranges", "bytes")
+	rangeHeader := string(c.GetHeader("Range"))
+	if rangeHeader == "" {
+		pr, pw := io.Pipe()
+		go func() {
+			defer reader.Close()
+			err := writeRawLog(pw, reader, req.StripANSI, req.Tail)
+			if err != nil && err != io.ErrClosedPipe {
+				hlog.Errorf("write raw log of job runner %d error: %s", req.JobRunnerID, err)
+			}
+			pw.CloseWithError(err)
+		}()
+		c.SetBodyStream(pr, -1)
+		return
+	}
+
+	defer reader.Close()
+	file, err := os.CreateTemp("", "cicd-log-*")
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	// 文件打开期间仍可读写，删除后不会残留临时文件
+	os.Remove(file.Name())
+	if err := writeRawLog(file, reader, req.StripANSI, req.Tail); err != nil {
+		file.Close()
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	start, end, ok, err := parseRange(rangeHeader, size)
+	if err != nil {
+		file.Close()
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.SetStatusCode(consts.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if ok {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		c.SetStatusCode(consts.StatusPartialContent)
+	} else {
+		start, end = 0, size-1
+	}
+	c.SetBodyStream(&sectionReadCloser{SectionReader: io.NewSectionReader(file, start, end-start+1), file: file}, int(end-start+1))
+}
+
+// JobLogArchive 把一次任务中所有步骤的纯文本日志打包为 zip 下载，重新执行的步骤各自单独一个文件
+func JobLogArchive(ctx context.Context, c *app.RequestContext) {
+	var req types.JobLogArchiveReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var job dal.Job
+	if err := dal.DB.Last(&job, "id = ?", req.JobID).Error; err != nil {
+		c.JSON(consts.StatusNotFound, utils.H{"error": "job not found"})
+		return
+	}
+	var jobRunners []dal.JobRunner
+	if err := dal.DB.Order("step_sort ASC, id ASC").Find(&jobRunners, "job_id = ?", job.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	var steps []dal.Step
+	if err := dal.DB.Unscoped().Find(&steps, "pipeline_id = ?", job.PipelineID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	stepNames := make(map[uint]string)
+	for _, step := range steps {
+		stepNames[step.ID] = step.Name
+	}
+	var pipeline dal.Pipeline
+	if err := dal.DB.Unscoped().Last(&pipeline, "id = ?", job.PipelineID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw := zip.NewWriter(pw)
+		err := func() error {
+			for _, jobRunner := range jobRunners {
+				reader, err := logstore.Default.Open(jobRunner.ID)
+				if err == logstore.ErrNotExist {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				name := fmt.Sprintf("%02d-%s-%d.log", jobRunner.StepSort, safeFileName(stepNames[jobRunner.StepID]), jobRunner.ID)
+				w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: jobRunner.UpdatedAt})
+				if err == nil {
+					err = writeRawLog(w, reader, req.StripANSI, 0)
+				}
+				reader.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return zw.Close()
+		}()
+		if err != nil && err != io.ErrClosedPipe {
+			hlog.Errorf("write log archive of job %d error: %s", job.ID, err)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	c.SetContentType("application/zip")
+	// 非 ASCII 的名称按 RFC 2231 编码
+	filename := fmt.Sprintf("%s-%s-logs.zip", safeFileName(pipeline.Name), safeFileName(job.Tag))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.SetBodyStream(pr, -1)
+}
+
+// safeFileName 把名称中的路径分隔符、引号和控制字符替换为 _，名称不能为 . 或 ..，
+// 用于 zip 中的文件名和下载的文件名
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	// 超长的行只索引开头部分
+	logIndexMaxLine = 4096
+	// 每个步骤最多返回的匹配行数
+	logSearchMatchLimit = 5
+	// 匹配行前后各返回的行数
+	logSearchContext = 2
+)
+
+// indexRecords 把写入的日志记录加入全文索引，失败时只记录错误，不影响日志写入
+func indexRecords(jobRunnerID uint, records []types.LogRecord) {
+	lines := make([]dal.LogLine, 0, len(records))
+	for _, record := range records {
+		if record.Type == types.LogSectionEnd || strings.TrimSpace(record.Text) == "" {
+			continue
+		}
+		text := record.Text
+		if len(text) > logIndexMaxLine {
+			text = strings.ToValidUTF8(text[:logIndexMaxLine], "")
+		}
+		lines = append(lines, dal.LogLine{
+			JobRunnerID: jobRunnerID,
+			Runner:      record.Runner,
+			Stream:      record.Stream,
+			Time:        record.Time,
+			Text:        text,
+		})
+	}
+	if len(lines) == 0 {
+		return
+	}
+	if err := dal.DB.CreateInBatches(lines, 500).Error; err != nil {
+		hlog.Errorf("index log of job runner %d error: %s", jobRunnerID, err)
+	}
+}
+
+// SearchLog 全文检索日志，按步骤分组返回匹配的行和上下文
+func SearchLog(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+
+	var req types.LogSearchReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if utf8.RuneCountInString(req.Query) < 3 {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "关键字至少需要 3 个字符"})
+		return
+	}
+	// 作为短语检索，关键字中的运算符不生效
+	phrase := `"` + strings.ReplaceAll(req.Query, `"`, `""`) + `"`
+
+	db := dal.DB.Table("log_lines_fts").
+		Joins("JOIN log_lines ON log_lines.id = log_lines_fts.rowid").
+		Joins("JOIN job_runners ON job_runners.id = log_lines.job_runner_id AND job_runners.deleted_at IS NULL").
+		Joins("JOIN jobs ON jobs.id = job_runners.job_id").
+		Where("log_lines_fts MATCH ?", phrase)
+	if !user.IsAdmin {
+		var userRoles []dal.UserRole
+		if err := dal.DB.Where("user_id = ?", user.Id).Find(&userRoles).Error; err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+		if len(userRoles) == 0 {
+			c.JSON(consts.StatusOK, utils.H{
+				"list":  []types.LogSearchResp{},
+				"total": 0,
+			})
+			return
+		}
+		db = db.Where("jobs.pipeline_id IN (?)", dal.DB.Model(&dal.PipelineRole{}).Select("pipeline_id").Where("pipeline_roles.role_id IN ?", lo.Map(userRoles, func(item dal.UserRole, _ int) uint { return item.RoleID })))
+	}
+	if req.PipelineID > 0 {
+		db = db.Where("jobs.pipeline_id = ?", req.PipelineID)
+	}
+	if req.StepID > 0 {
+		db = db.Where("job_runners.step_id = ?", req.StepID)
+	}
+	if req.Status != "" {
+		db = db.Where("job_runners.status = ?", req.Status)
+	}
+	if req.Start > 0 {
+		db = db.Where("job_runners.start_time >= ?", time.UnixMilli(req.Start))
+	}
+	if req.End > 0 {
+		db = db.Where("job_runners.start_time <= ?", time.UnixMilli(req.End))
+	}
+	db = db.Session(&gorm.Session{})
+
+	var total int64
+	if err := db.Distinct("log_lines.job_runner_id").Count(&total).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	var groups []struct {
+		JobRunnerID uint
+		MatchCount  int
+	}
+	if err := db.Select("log_lines.job_runner_id, COUNT(*) AS match_count").
+		Group("log_lines.job_runner_id").
+		Order("log_lines.job_runner_id DESC").
+		Scopes(dal.Paginate(req.Page, req.PageSize)).
+		Scan(&groups).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	list := make([]types.LogSearchResp, 0, len(groups))
+	for _, group := range groups {
+		resp, err := searchResult(phrase, group.JobRunnerID)
+		if err != nil {
+			c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+			return
+		}
+		resp.MatchCount = group.MatchCount
+		list = append(list, resp)
+	}
+
+	c.JSON(consts.StatusOK, utils.H{
+		"list":  list,
+		"total": total,
+	})
+}
+
+// searchResult 查询步骤的信息和前几个匹配的行
+func searchResult(phrase string, jobRunnerID uint) (types.LogSearchResp, error) {
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
+		return types.LogSearchResp{}, err
+	}
+	var job dal.Job
+	if err := dal.DB.Last(&job, "id = ?", jobRunner.JobID).Error; err != nil {
+		return types.LogSearchResp{}, err
+	}
+	resp := types.LogSearchResp{
+		JobRunnerID: jobRunner.ID,
+		JobID:       job.ID,
+		Tag:         job.Tag,
+		PipelineID:  job.PipelineID,
+		StepID:      jobRunner.StepID,
+		Status:      string(jobRunner.Status),
+		StartTime:   lo.Ternary(jobRunner.StartTime.IsZero(), "-", jobRunner.StartTime.Format("2006-01-02 15:04:05")),
+	}
+	// 流水线和步骤可能已被删除，只影响展示的名称
+	var pipeline dal.Pipeline
+	if err := dal.DB.Unscoped().Select("name").Last(&pipeline, "id = ?", job.PipelineID).Error; err == nil {
+		resp.PipelineName = pipeline.Name
+	}
+	var step dal.Step
+	if err := dal.DB.Unscoped().Select("name").Last(&step, "id = ?", jobRunner.StepID).Error; err == nil {
+		resp.StepName = step.Name
+	}
+
+	var lines []dal.LogLine
+	if err := dal.DB.Table("log_lines_fts").
+		Select("log_lines.*").
+		Joins("JOIN log_lines ON log_lines.id = log_lines_fts.rowid").
+		Where("log_lines_fts MATCH ? AND log_lines.job_runner_id = ?", phrase, jobRunnerID).
+		Order("log_lines.id ASC").
+		Limit(logSearchMatchLimit).
+		Find(&lines).Error; err != nil {
+		return resp, err
+	}
+	for _, line := range lines {
+		match := types.LogSearchMatch{
+			Runner: line.Runner,
+			Stream: line.Stream,
+			Time:   line.Time,
+			Text:   line.Text,
+		}
+		// 同一步骤由多台 runner 执行时日志交错，上下文只取同一台 runner 的行
+		var before, after []dal.LogLine
+		if err := dal.DB.Where("job_runner_id = ? AND runner = ? AND id < ?", jobRunnerID, line.Runner, line.ID).
+			Order("id DESC").Limit(logSearchContext).Find(&before).Error; err != nil {
+			return resp, err
+		}
+		if err := dal.DB.Where("job_runner_id = ? AND runner = ? AND id > ?", jobRunnerID, line.Runner, line.ID).
+			Order("id ASC").Limit(logSearchContext).Find(&after).Error; err != nil {
+			return resp, err
+		}
+		for i := len(before) - 1; i >= 0; i-- {
+			match.Before = append(match.Before, before[i].Text)
+		}
+		for _, l := range after {
+			match.After = append(match.After, l.Text)
+		}
+		resp.Matches = append(resp.Matches, match)
+	}
+	return resp, nil
+}
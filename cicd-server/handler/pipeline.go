@@ -1,8 +1,10 @@
 package handler
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"sort"
 
 	"cicd-server/dal"
@@ -16,6 +18,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// checkSecrets 过短的 secret 变量和 git 密码无法在日志中隐藏，保存前拒绝。值留空表示沿用已保存的值，不校验
+func checkSecrets(envs types.Envs, password string) error {
+	for _, env := range envs {
+		if env.Secret && env.Val != "" && len(env.Val) < cutils.MinMaskLength {
+			return fmt.Errorf("secret 变量 %s 的值少于 %d 个字符，无法在日志中隐藏", env.Key, cutils.MinMaskLength)
+		}
+	}
+	if password != "" && len(password) < cutils.MinMaskLength {
+		return fmt.Errorf("git 密码少于 %d 个字符，无法在日志中隐藏", cutils.MinMaskLength)
+	}
+	return nil
+}
+
 func ListPipeline(ctx context.Context, c *app.RequestContext) {
 	user, err := cutils.LoginUser(ctx, c)
 	if err != nil {
@@ -90,6 +105,11 @@ func CreatePipeline(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	if err := checkSecrets(pipeline.Envs, pipeline.Password); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
 	var count int64
 	if err := dal.DB.Model(&dal.Pipeline{}).Where("name = ?", pipeline.Name).Count(&count).Error; err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
@@ -111,16 +131,20 @@ func CreatePipeline(ctx context.Context, c *app.RequestContext) {
 	}
 
 	p := dal.Pipeline{
-		Name:        pipeline.Name,
-		GroupName:   pipeline.GroupName,
-		TagTemplate: pipeline.TagTemplate,
-		UseGit:      pipeline.UseGit,
+		Name:           pipeline.Name,
+		GroupName:      pipeline.GroupName,
+		TagTemplate:    pipeline.TagTemplate,
+		UseGit:         pipeline.UseGit,
+		Priority:       pipeline.Priority,
+		RetentionCount: pipeline.RetentionCount,
+		RetentionDays:  pipeline.RetentionDays,
 	}
 	var envs []dal.Env
 	for _, v := range pipeline.Envs {
 		envs = append(envs, dal.Env{
-			Key: v.Key,
-			Val: v.Val,
+			Key:    v.Key,
+			Val:    v.Val,
+			Secret: v.Secret,
 		})
 	}
 	p.Envs = envs
@@ -139,11 +163,16 @@ func CreatePipeline(ctx context.Context, c *app.RequestContext) {
 
 		if pipeline.UseGit {
 			git := dal.Git{
-				PipelineID: p.ID,
-				Repository: pipeline.Repository,
-				Branch:     pipeline.Branch,
-				Username:   pipeline.Username,
-				Password:   pipeline.Password,
+				PipelineID:  p.ID,
+				Repository:  pipeline.Repository,
+				Branch:      pipeline.Branch,
+				Username:    pipeline.Username,
+				Password:    pipeline.Password,
+				Depth:       pipeline.Depth,
+				Submodules:  pipeline.Submodules,
+				LFS:         pipeline.LFS,
+				SparsePaths: pipeline.SparsePaths,
+				Clean:       pipeline.Clean,
 			}
 			if err := tx.Create(&git).Error; err != nil {
 				return err
@@ -195,6 +224,11 @@ func UpdatePipeline(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	if err := checkSecrets(pipeline.Envs, pipeline.Password); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
 	var count int64
 	if err := dal.DB.Model(&dal.Pipeline{}).Where("name = ? AND id != ?", pipeline.Name, pipeline.ID).Count(&count).Error; err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
@@ -228,7 +262,13 @@ func UpdatePipeline(ctx context.Context, c *app.RequestContext) {
 	}
 
 	if err := dal.DB.Transaction(func(tx *gorm.DB) error {
+		// 密码留空时沿用已保存的密码，仓库地址变化时不沿用，避免把凭据发送到其他地址
+		var savedPassword string
 		if p.UseGit {
+			var saved dal.Git
+			if err := tx.Last(&saved, "pipeline_id = ?", p.ID).Error; err == nil && saved.Repository == pipeline.Repository && !pipeline.ClearPassword {
+				savedPassword = saved.Password
+			}
 			if err := tx.Delete(&dal.Git{}, "pipeline_id = ?", p.ID).Error; err != nil {
 				return err
 			}
@@ -238,14 +278,10 @@ func UpdatePipeline(ctx context.Context, c *app.RequestContext) {
 		p.UseGit = pipeline.UseGit
 		p.GroupName = pipeline.GroupName
 		p.Sort = maxSort
-		var envs []dal.Env
-		for _, v := range pipeline.Envs {
-			envs = append(envs, dal.Env{
-				Key: v.Key,
-				Val: v.Val,
-			})
-		}
-		p.Envs = envs
+		p.Priority = pipeline.Priority
+		p.RetentionCount = pipeline.RetentionCount
+		p.RetentionDays = pipeline.RetentionDays
+		p.Envs = p.Envs.Merge(pipeline.Envs)
 		if err := tx.Save(&p).Error; err != nil {
 			return err
 		}
@@ -265,11 +301,16 @@ func UpdatePipeline(ctx context.Context, c *app.RequestContext) {
 
 		if pipeline.UseGit {
 			git := dal.Git{
-				PipelineID: p.ID,
-				Repository: pipeline.Repository,
-				Branch:     pipeline.Branch,
-				Username:   pipeline.Username,
-				Password:   pipeline.Password,
+				PipelineID:  p.ID,
+				Repository:  pipeline.Repository,
+				Branch:      pipeline.Branch,
+				Username:    pipeline.Username,
+				Password:    cmp.Or(pipeline.Password, savedPassword),
+				Depth:       pipeline.Depth,
+				Submodules:  pipeline.Submodules,
+				LFS:         pipeline.LFS,
+				SparsePaths: pipeline.SparsePaths,
+				Clean:       pipeline.Clean,
 			}
 			if err := tx.Create(&git).Error; err != nil {
 				return err
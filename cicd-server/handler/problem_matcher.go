@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+)
+
+const (
+	// 每个步骤最多保存的注解数，避免大量重复的错误写满数据库
+	annotationLimit = 200
+	// 注解内容的长度上限
+	annotationMaxMessage = 1024
+)
+
+var (
+	problemMatchers = make(map[uint]*cachedMatchers)
+	matcherMutex    sync.Mutex
+)
+
+type compiledMatcher struct {
+	name     string
+	pattern  *regexp.Regexp
+	severity string
+}
+
+type cachedMatchers struct {
+	matchers []compiledMatcher
+	// count 步骤已保存的注解数
+	count    int
+	expireAt time.Time
+}
+
+// logMatchers 返回步骤使用的问题匹配规则，步骤自定义的规则在前，全局规则在后
+func logMatchers(jobRunnerID uint) *cachedMatchers {
+	matcherMutex.Lock()
+	defer matcherMutex.Unlock()
+
+	now := time.Now()
+	if cached, ok := problemMatchers[jobRunnerID]; ok && now.Before(cached.expireAt) {
+		return cached
+	}
+	for id, cached := range problemMatchers {
+		if now.After(cached.expireAt) {
+			delete(problemMatchers, id)
+		}
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Select("problem_matchers").Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
+		hlog.Errorf("get job runner error: %s", err)
+		return nil
+	}
+	var globals []dal.ProblemMatcher
+	if err := dal.DB.Order("id ASC").Find(&globals).Error; err != nil {
+		hlog.Errorf("get problem matchers error: %s", err)
+		return nil
+	}
+	var count int64
+	if err := dal.DB.Model(&dal.Annotation{}).Where("job_runner_id = ?", jobRunnerID).Count(&count).Error; err != nil {
+		hlog.Errorf("count annotations error: %s", err)
+		return nil
+	}
+
+	rules := append([]dal.ProblemMatcherRule{}, jobRunner.ProblemMatchers...)
+	for _, matcher := range globals {
+		rules = append(rules, matcher.ProblemMatcherRule)
+	}
+	cached := &cachedMatchers{count: int(count), expireAt: now.Add(maskerTTL)}
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			hlog.Warnf("compile problem matcher %s error: %s", rule.Name, err)
+			continue
+		}
+		cached.matchers = append(cached.matchers, compiledMatcher{name: rule.Name, pattern: pattern, severity: rule.Severity})
+	}
+	problemMatchers[jobRunnerID] = cached
+	return cached
+}
+
+// resetMatchers 全局规则修改后清空缓存，新写入的日志使用新规则
+func resetMatchers() {
+	matcherMutex.Lock()
+	defer matcherMutex.Unlock()
+	problemMatchers = make(map[uint]*cachedMatchers)
+}
+
+// match 返回第一条匹配日志行的规则生成的注解
+func (m compiledMatcher) match(text string) (dal.Annotation, bool) {
+	groups := m.pattern.FindStringSubmatch(text)
+	if groups == nil {
+		return dal.Annotation{}, false
+	}
+	annotation := dal.Annotation{Matcher: m.name, Severity: m.severity}
+	for i, name := range m.pattern.SubexpNames() {
+		value := strings.TrimSpace(groups[i])
+		switch name {
+		case "file":
+			annotation.File = value
+		case "line":
+			annotation.Line, _ = strconv.Atoi(value)
+		case "column":
+			annotation.Column, _ = strconv.Atoi(value)
+		case "severity":
+			if value != "" {
+				annotation.Severity = value
+			}
+		case "code":
+			annotation.Code = value
+		case "message":
+			annotation.Message = value
+		}
+	}
+	if annotation.Message == "" {
+		annotation.Message = strings.TrimSpace(text)
+	}
+	if len(annotation.Message) > annotationMaxMessage {
+		annotation.Message = strings.ToValidUTF8(annotation.Message[:annotationMaxMessage], "")
+	}
+	annotation.Severity = dal.NormalizeSeverity(annotation.Severity)
+	return annotation, true
+}
+
+// annotateRecords 用问题匹配规则检查脱敏后的日志记录，匹配的行保存为注解。
+// 失败时只记录错误，不影响日志写入
+func annotateRecords(jobRunnerID uint, records []types.LogRecord) {
+	cached := logMatchers(jobRunnerID)
+	if cached == nil || len(cached.matchers) == 0 {
+		return
+	}
+	matcherMutex.Lock()
+	remain := annotationLimit - cached.count
+	matcherMutex.Unlock()
+	if remain <= 0 {
+		return
+	}
+
+	var annotations []dal.Annotation
+	for _, record := range records {
+		if record.Type != "" || strings.TrimSpace(record.Text) == "" {
+			continue
+		}
+		text := ansiPattern.ReplaceAllString(record.Text, "")
+		for _, matcher := range cached.matchers {
+			if annotation, ok := matcher.match(text); ok {
+				annotation.JobRunnerID = jobRunnerID
+				annotation.Runner = record.Runner
+				annotation.Seq = record.Seq
+				annotations = append(annotations, annotation)
+				break
+			}
+		}
+		if len(annotations) >= remain {
+			break
+		}
+	}
+	if len(annotations) == 0 {
+		return
+	}
+
+	// 并发写入同一步骤的日志时重新检查剩余数量
+	matcherMutex.Lock()
+	n := min(len(annotations), annotationLimit-cached.count)
+	cached.count += max(n, 0)
+	matcherMutex.Unlock()
+	if n <= 0 {
+		return
+	}
+	if err := dal.DB.CreateInBatches(annotations[:n], 100).Error; err != nil {
+		hlog.Errorf("save annotations of job runner %d error: %s", jobRunnerID, err)
+	}
+}
+
+// jobRunnerAnnotations 步骤的注解，按日志中出现的顺序返回
+func jobRunnerAnnotations(jobRunnerID uint) ([]types.AnnotationResp, error) {
+	var annotations []dal.Annotation
+	if err := dal.DB.Order("id ASC").Limit(annotationLimit).Find(&annotations, "job_runner_id = ?", jobRunnerID).Error; err != nil {
+		return nil, err
+	}
+	return lo.Map(annotations, func(item dal.Annotation, _ int) types.AnnotationResp {
+		return item.Format()
+	}), nil
+}
+
+func ListProblemMatcher(ctx context.Context, c *app.RequestContext) {
+	var matchers []dal.ProblemMatcher
+	if err := dal.DB.Order("id ASC").Find(&matchers).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, utils.H{"list": lo.Map(matchers, func(matcher dal.ProblemMatcher, _ int) types.ProblemMatcherResp {
+		return matcher.Format()
+	})})
+}
+
+func CreateProblemMatcher(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.ProblemMatcherRule
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	matcher := dal.ProblemMatcher{
+		ProblemMatcherRule: dal.ProblemMatcherRule{Name: req.Name, Pattern: req.Pattern, Severity: req.Severity},
+	}
+	if err := matcher.Validate(); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	if err := dal.DB.Create(&matcher).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	resetMatchers()
+	c.JSON(consts.StatusOK, matcher.Format())
+}
+
+func UpdateProblemMatcher(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.UpdateProblemMatcherReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	var matcher dal.ProblemMatcher
+	if err := dal.DB.First(&matcher, "id = ?", req.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	matcher.ProblemMatcherRule = dal.ProblemMatcherRule{Name: req.Name, Pattern: req.Pattern, Severity: req.Severity}
+	if err := matcher.Validate(); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	if err := dal.DB.Save(&matcher).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	resetMatchers()
+	c.JSON(consts.StatusOK, matcher.Format())
+}
+
+func DeleteProblemMatcher(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.ProblemMatcherPathReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if err := dal.DB.Delete(&dal.ProblemMatcher{}, "id = ?", req.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	resetMatchers()
+	c.JSON(consts.StatusOK, utils.H{"message": "删除成功"})
+}
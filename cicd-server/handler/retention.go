@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"context"
+
+	jobexec "cicd-server/job_exec"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// RetentionReport 返回最近一次按保留规则清理任务历史的结果
+func RetentionReport(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	c.JSON(consts.StatusOK, jobexec.LastRetentionReport())
+}
@@ -3,11 +3,14 @@ package handler
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"cicd-server/dal"
 	"cicd-server/types"
+	cutils "cicd-server/utils"
 
 	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/samber/lo"
@@ -21,6 +24,32 @@ func RegisterRunner(ctx context.Context, c *app.RequestContext) {
 		return
 	}
 
+	if runner.Protocol < dal.MinRunnerProtocol {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": fmt.Sprintf("runner protocol %d is not supported, minimum protocol is %d, please upgrade runner", runner.Protocol, dal.MinRunnerProtocol)})
+		return
+	}
+	var message string
+	if runner.Protocol < dal.RunnerProtocol {
+		message = fmt.Sprintf("runner protocol %d is outdated, current protocol is %d, please upgrade runner", runner.Protocol, dal.RunnerProtocol)
+		hlog.Warnf("runner[%s] %s", runner.Name, message)
+	}
+
+	var token dal.RunnerToken
+	if err := dal.DB.Last(&token, "token = ?", runner.Token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(consts.StatusUnauthorized, utils.H{"error": "invalid runner token"})
+			return
+		}
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := cutils.RandomHex(32)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
 	if err := dal.DB.Transaction(func(tx *gorm.DB) error {
 		var r dal.Runner
 		if err := tx.Last(&r, "name = ?", runner.Name).Error; err != nil {
@@ -29,8 +58,14 @@ func RegisterRunner(ctx context.Context, c *app.RequestContext) {
 					Name:     runner.Name,
 					Endpoint: runner.Endpoint,
 					Status:   dal.Online,
-					Message:  "",
+					Message:  message,
 					IP:       runner.IP,
+					Secret:   secret,
+					Version:  runner.Version,
+					OS:       runner.OS,
+					Arch:     runner.Arch,
+					Protocol: runner.Protocol,
+					Features: runner.Features,
 				}
 				if err := tx.Create(&r).Error; err != nil {
 					return err
@@ -50,11 +85,20 @@ func RegisterRunner(ctx context.Context, c *app.RequestContext) {
 		}
 		if r.Endpoint != runner.Endpoint {
 			return errors.New("runner name already exists")
+		} else if !reregisterAllowed(c, r) {
+			return errRunnerRegistered
 		} else {
 			if runner.Name != "" {
 				r.Name = runner.Name
 			}
 			r.Status = dal.Online
+			r.Secret = secret
+			r.Message = message
+			r.Version = runner.Version
+			r.OS = runner.OS
+			r.Arch = runner.Arch
+			r.Protocol = runner.Protocol
+			r.Features = runner.Features
 			if runner.IP != "" {
 				r.IP = runner.IP
 			}
@@ -76,6 +120,49 @@ func RegisterRunner(ctx context.Context, c *app.RequestContext) {
 			return nil
 		}
 	}); err != nil {
+		if errors.Is(err, errRunnerRegistered) {
+			c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+			return
+		}
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, types.RegisterRunnerResp{Data: "success", Secret: secret})
+}
+
+var errRunnerRegistered = errors.New("runner already registered, sign the request with its saved secret or ask an admin to reset the runner secret")
+
+// reregisterAllowed 已注册的 runner 重新注册时需要用原来的密钥签名，避免持有注册 token 的人冒用 runner 身份。
+// 密钥为空（管理员重置过或旧版本注册的 runner）时允许直接注册
+func reregisterAllowed(c *app.RequestContext, r dal.Runner) bool {
+	if r.Secret == "" {
+		return true
+	}
+	if string(c.GetHeader(cutils.HeaderRunner)) != r.Name {
+		return false
+	}
+	return cutils.VerifySignature(r.Secret, string(c.Method()), string(c.Path()),
+		string(c.GetHeader(cutils.HeaderTimestamp)), string(c.GetHeader(cutils.HeaderSignature)), c.Request.Body()) == nil
+}
+
+// ResetRunnerSecret 清除 runner 的密钥并下线，runner 丢失密钥后可以重新注册
+func ResetRunnerSecret(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var runner types.PathRunnerReq
+	if err := c.BindAndValidate(&runner); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if err := dal.DB.Model(&dal.Runner{}).Where("id = ?", runner.ID).Updates(map[string]interface{}{"secret": "", "status": dal.Offline}).Error; err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
 	}
@@ -190,3 +277,225 @@ func ListRunnerLabel(ctx context.Context, c *app.RequestContext) {
 
 	c.JSON(consts.StatusOK, lo.Uniq(rs))
 }
+
+func CreateRunnerToken(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.CreateRunnerTokenReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	value, err := cutils.RandomHex(24)
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	token := dal.RunnerToken{
+		Token:  value,
+		Remark: req.Remark,
+	}
+	if err := dal.DB.Create(&token).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, token.Format())
+}
+
+func ListRunnerToken(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var tokens []dal.RunnerToken
+	if err := dal.DB.Order("id desc").Find(&tokens).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, lo.Map(tokens, func(item dal.RunnerToken, _ int) types.RunnerTokenResp {
+		return item.Format()
+	}))
+}
+
+func DeleteRunnerToken(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.PathRunnerReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	if err := dal.DB.Delete(&dal.RunnerToken{}, "id = ?", req.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// RunnerStatus runner 排空或退出时上报状态
+func RunnerStatus(ctx context.Context, c *app.RequestContext) {
+	var req types.RunnerStatusReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	r := c.MustGet("runner").(dal.Runner)
+	if err := dal.DB.Model(&dal.Runner{}).Where("id = ?", r.ID).Update("status", dal.RunnerStatus(req.Status)).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// RunnerMetrics runner 定期上报资源使用情况
+func RunnerMetrics(ctx context.Context, c *app.RequestContext) {
+	var req types.RunnerMetricReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	r := c.MustGet("runner").(dal.Runner)
+	metric := dal.RunnerMetric{
+		RunnerID:     r.ID,
+		CPUs:         req.CPUs,
+		CPUPercent:   req.CPUPercent,
+		MemTotal:     req.MemTotal,
+		MemAvailable: req.MemAvailable,
+		DiskFree:     req.DiskFree,
+		Load1:        req.Load1,
+		Load5:        req.Load5,
+		Load15:       req.Load15,
+	}
+	if err := dal.SaveRunnerMetric(&metric); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+func RunnerMetricHistory(ctx context.Context, c *app.RequestContext) {
+	var runner types.PathRunnerReq
+	if err := c.BindAndValidate(&runner); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var metrics []dal.RunnerMetric
+	if err := dal.DB.Order("id asc").Find(&metrics, "runner_id = ?", runner.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, lo.Map(metrics, func(item dal.RunnerMetric, _ int) types.RunnerMetricResp {
+		return item.Format()
+	}))
+}
+
+func DrainRunner(ctx context.Context, c *app.RequestContext) {
+	var runner types.PathRunnerReq
+	if err := c.BindAndValidate(&runner); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var r dal.Runner
+	if err := dal.DB.First(&r, "id = ?", runner.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	if r.Status != dal.Online {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "runner is not online"})
+		return
+	}
+
+	if err := callRunner(&r, "/drain", nil); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	if err := dal.DB.Model(&dal.Runner{}).Where("id = ?", r.ID).Update("status", dal.Draining).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// RunnerAuth 校验 runner 请求的签名，并把 runner 放入上下文
+func RunnerAuth(ctx context.Context, c *app.RequestContext) {
+	name := string(c.GetHeader(cutils.HeaderRunner))
+	if name == "" {
+		c.AbortWithStatusJSON(consts.StatusUnauthorized, utils.H{"error": "missing runner signature"})
+		return
+	}
+
+	var r dal.Runner
+	if err := dal.DB.Last(&r, "name = ?", name).Error; err != nil {
+		c.AbortWithStatusJSON(consts.StatusUnauthorized, utils.H{"error": "unknown runner"})
+		return
+	}
+	if r.Secret == "" {
+		c.AbortWithStatusJSON(consts.StatusUnauthorized, utils.H{"error": "runner not registered"})
+		return
+	}
+
+	// 流式上传的请求体还没有读取，签名的是 X-Cicd-Content-Sha256，由接口在接收时校验内容
+	body := c.GetHeader(cutils.HeaderContentSha256)
+	if !streamBody(c) {
+		body = c.Request.Body()
+	}
+	// 旧版本 runner 的签名不包含查询参数
+	path := string(c.Path())
+	if r.HasFeature("signed_query") {
+		path = cutils.SignedPath(path, string(c.Request.URI().QueryString()))
+	}
+	if err := cutils.VerifySignature(r.Secret, string(c.Method()), path,
+		string(c.GetHeader(cutils.HeaderTimestamp)), string(c.GetHeader(cutils.HeaderSignature)), body); err != nil {
+		c.AbortWithStatusJSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.Set("runner", r)
+	c.Next(ctx)
+}
+
+// assignedToRunner 判断步骤是否分配给了当前请求的 runner
+func assignedToRunner(c *app.RequestContext, jobRunnerID uint) bool {
+	data, ok := c.Get("runner")
+	if !ok {
+		return false
+	}
+	r := data.(dal.Runner)
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
+		return false
+	}
+	return lo.Contains(jobRunner.AssignRunnerIds, r.ID)
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+var releaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func UploadRunnerRelease(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var req types.UploadRunnerReleaseReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	// 版本、系统和架构会作为目录名使用
+	for _, v := range []string{req.Version, req.OS, req.Arch} {
+		if !releaseNamePattern.MatchString(v) || v == "." || v == ".." {
+			c.JSON(consts.StatusBadRequest, utils.H{"error": "invalid version, os or arch: " + v})
+			return
+		}
+	}
+
+	var count int64
+	if err := dal.DB.Model(&dal.RunnerRelease{}).Where("version = ? AND os = ? AND arch = ?", req.Version, req.OS, req.Arch).Count(&count).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	// 已发布的版本不能覆盖，否则已有记录的校验和与文件不一致，正在下载的 runner 也会拿到不完整的文件
+	if count > 0 {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "release already exists: " + req.Version + " " + req.OS + "/" + req.Arch})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	path := filepath.Join(homeDir, ".cicd-server", "releases", req.Version, req.OS+"-"+req.Arch, "cicd-runner")
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	checksum, size, err := saveRelease(path, src)
+	if errors.Is(err, os.ErrExist) {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "release already exists: " + req.Version + " " + req.OS + "/" + req.Arch})
+		return
+	}
+	if err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	release := dal.RunnerRelease{
+		Version:  req.Version,
+		OS:       req.OS,
+		Arch:     req.Arch,
+		Path:     path,
+		Checksum: checksum,
+		Size:     size,
+	}
+	if err := dal.DB.Create(&release).Error; err != nil {
+		os.Remove(path)
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, release.Format())
+}
+
+// saveRelease 先写入临时文件，完成后以硬链接发布到 path，path 已存在时返回 os.ErrExist，不会覆盖已发布的文件
+func saveRelease(path string, src io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func ListRunnerRelease(ctx context.Context, c *app.RequestContext) {
+	var releases []dal.RunnerRelease
+	if err := dal.DB.Order("id desc").Find(&releases).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(consts.StatusOK, lo.Map(releases, func(item dal.RunnerRelease, _ int) types.RunnerReleaseResp {
+		return item.Format()
+	}))
+}
+
+// DownloadRunnerRelease runner 升级时下载二进制
+func DownloadRunnerRelease(ctx context.Context, c *app.RequestContext) {
+	var req types.PathRunnerReleaseReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var release dal.RunnerRelease
+	if err := dal.DB.First(&release, "id = ?", req.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	c.File(release.Path)
+}
+
+// UpgradeRunner 通知 runner 在空闲时升级到与其平台匹配的最新版本
+func UpgradeRunner(ctx context.Context, c *app.RequestContext) {
+	user, err := cutils.LoginUser(ctx, c)
+	if err != nil {
+		c.JSON(consts.StatusUnauthorized, utils.H{"error": err.Error()})
+		return
+	}
+	if !user.IsAdmin {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "无权限"})
+		return
+	}
+
+	var runner types.PathRunnerReq
+	if err := c.BindAndValidate(&runner); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var r dal.Runner
+	if err := dal.DB.First(&r, "id = ?", runner.ID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	if r.Status != dal.Online {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "runner is not online"})
+		return
+	}
+	if !r.HasFeature("upgrade") {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "runner does not support remote upgrade"})
+		return
+	}
+
+	var release dal.RunnerRelease
+	if err := dal.DB.Last(&release, "os = ? AND arch = ?", r.OS, r.Arch).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(consts.StatusBadRequest, utils.H{"error": "no release for " + r.OS + "/" + r.Arch})
+			return
+		}
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	if release.Version == r.Version {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": "runner is already at version " + r.Version})
+		return
+	}
+
+	body, _ := json.Marshal(types.RunnerUpgrade{
+		ReleaseID: release.ID,
+		Version:   release.Version,
+		Checksum:  release.Checksum,
+	})
+	if err := callRunner(&r, "/upgrade", body); err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"cmp"
+	"context"
+
 	"cicd-server/dal"
 	"cicd-server/types"
-	"context"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/common/utils"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
 )
 
 func ListStep(ctx context.Context, c *app.RequestContext) {
@@ -61,6 +64,28 @@ func CreateStep(ctx context.Context, c *app.RequestContext) {
 	s.Trigger = dal.Trigger(step.Trigger)
 	s.RunnerLabelMatch = step.RunnerLabelMatch
 	s.MultipleRunnerExec = step.MultipleRunnerExec
+	s.MinDiskFree = step.MinDiskFree
+	s.Image = step.Image
+	s.Mode = dal.StepMode(cmp.Or(step.Mode, string(dal.StepModeCommand)))
+	s.Interpreter = step.Interpreter
+	s.CacheKey = step.CacheKey
+	s.CachePaths = step.CachePaths
+	s.Artifacts = step.Artifacts
+	s.ArtifactDownloads = step.ArtifactDownloads
+	s.TestReports = step.TestReports
+	s.ProblemMatchers = lo.Map(step.ProblemMatchers, func(item types.ProblemMatcherRule, _ int) dal.ProblemMatcherRule {
+		return dal.ProblemMatcherRule{Name: item.Name, Pattern: item.Pattern, Severity: item.Severity}
+	})
+	for _, rule := range s.ProblemMatchers {
+		if err := rule.Validate(); err != nil {
+			c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+			return
+		}
+	}
+	s.AllowFailure = step.AllowFailure
+	s.CommandOptions = lo.Map(step.CommandOptions, func(item types.CommandOption, _ int) dal.CommandOption {
+		return dal.CommandOption{ContinueOnError: item.ContinueOnError, SuccessExitCodes: item.SuccessExitCodes}
+	})
 	if err := dal.DB.Create(&s).Error; err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
@@ -121,6 +146,28 @@ func UpdateStep(ctx context.Context, c *app.RequestContext) {
 	s.Trigger = dal.Trigger(step.Trigger)
 	s.RunnerLabelMatch = step.RunnerLabelMatch
 	s.MultipleRunnerExec = step.MultipleRunnerExec
+	s.MinDiskFree = step.MinDiskFree
+	s.Image = step.Image
+	s.Mode = dal.StepMode(cmp.Or(step.Mode, string(dal.StepModeCommand)))
+	s.Interpreter = step.Interpreter
+	s.CacheKey = step.CacheKey
+	s.CachePaths = step.CachePaths
+	s.Artifacts = step.Artifacts
+	s.ArtifactDownloads = step.ArtifactDownloads
+	s.TestReports = step.TestReports
+	s.ProblemMatchers = lo.Map(step.ProblemMatchers, func(item types.ProblemMatcherRule, _ int) dal.ProblemMatcherRule {
+		return dal.ProblemMatcherRule{Name: item.Name, Pattern: item.Pattern, Severity: item.Severity}
+	})
+	for _, rule := range s.ProblemMatchers {
+		if err := rule.Validate(); err != nil {
+			c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+			return
+		}
+	}
+	s.AllowFailure = step.AllowFailure
+	s.CommandOptions = lo.Map(step.CommandOptions, func(item types.CommandOption, _ int) dal.CommandOption {
+		return dal.CommandOption{ContinueOnError: item.ContinueOnError, SuccessExitCodes: item.SuccessExitCodes}
+	})
 	if err := dal.DB.Save(&s).Error; err != nil {
 		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
 		return
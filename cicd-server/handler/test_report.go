@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"context"
+	"sync"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+	cutils "cicd-server/utils"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/common/utils"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	// 解压后的测试报告大小上限
+	testReportMaxSize = 64 << 20
+	// 步骤详情中最多返回的测试用例数
+	testCaseLimit = 1000
+	// 单个测试最多返回的历史结果数
+	testHistoryLimit = 50
+)
+
+// 同一流水线的测试历史需要串行更新，避免并行步骤同时创建相同的 TestStat
+var testStatMutex sync.Mutex
+
+type testKey struct {
+	suite, name string
+}
+
+// TestReport 接收 runner 上报的测试报告，保存测试结果并更新流水线的测试历史
+func TestReport(ctx context.Context, c *app.RequestContext) {
+	if err := gunzipBody(c, testReportMaxSize); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var report types.TestReport
+	if err := c.BindAndValidate(&report); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+	if !assignedToRunner(c, report.JobRunnerID) {
+		c.JSON(consts.StatusForbidden, utils.H{"error": "job runner not assigned to this runner"})
+		return
+	}
+	if len(report.Cases) == 0 {
+		c.JSON(consts.StatusOK, utils.H{"data": "success"})
+		return
+	}
+
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", report.JobRunnerID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	var job dal.Job
+	if err := dal.DB.Last(&job, "id = ?", jobRunner.JobID).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+
+	runner := c.MustGet("runner").(dal.Runner)
+	if err := saveTestReport(&report, job, runner.Name, logMasker(report.JobRunnerID)); err != nil {
+		hlog.Errorf("save test report error: %s", err)
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, utils.H{"data": "success"})
+}
+
+// saveTestReport 脱敏后保存测试结果，并根据历史标记不稳定的测试
+func saveTestReport(report *types.TestReport, job dal.Job, runner string, masker *cutils.Masker) error {
+	testStatMutex.Lock()
+	defer testStatMutex.Unlock()
+
+	return dal.DB.Transaction(func(tx *gorm.DB) error {
+		names := lo.Uniq(lo.Map(report.Cases, func(item types.TestCase, _ int) string { return item.Name }))
+		stats := make(map[testKey]*dal.TestStat)
+		// 分批查询，避免超过 sqlite 的参数个数限制
+		for _, chunk := range lo.Chunk(names, 500) {
+			var existing []*dal.TestStat
+			if err := tx.Find(&existing, "pipeline_id = ? AND name IN ?", job.PipelineID, chunk).Error; err != nil {
+				return err
+			}
+			for _, stat := range existing {
+				stats[testKey{stat.Suite, stat.Name}] = stat
+			}
+		}
+
+		cases := make([]dal.TestCase, 0, len(report.Cases))
+		for _, item := range report.Cases {
+			key := testKey{item.Suite, item.Name}
+			stat, ok := stats[key]
+			if !ok {
+				stat = &dal.TestStat{PipelineID: job.PipelineID, Suite: item.Suite, Name: item.Name}
+				stats[key] = stat
+			}
+			cases = append(cases, dal.TestCase{
+				JobRunnerID: report.JobRunnerID,
+				JobID:       job.ID,
+				PipelineID:  job.PipelineID,
+				Suite:       item.Suite,
+				Name:        item.Name,
+				Runner:      runner,
+				File:        report.File,
+				Status:      item.Status,
+				Duration:    item.Duration,
+				Message:     masker.Mask(item.Message),
+				Flaky:       stat.Record(item.Status, job.CommitID, report.JobRunnerID),
+			})
+		}
+		if err := tx.CreateInBatches(cases, 500).Error; err != nil {
+			return err
+		}
+		for _, stat := range stats {
+			if err := tx.Save(stat).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// testSummary 步骤的测试结果汇总，没有测试报告时返回 nil
+func testSummary(jobRunnerID uint) (*types.TestSummary, error) {
+	var counts []struct {
+		Status   string
+		Count    int
+		Flaky    int
+		Duration int64
+	}
+	if err := dal.DB.Model(&dal.TestCase{}).
+		Select("status, COUNT(*) AS count, SUM(flaky) AS flaky, SUM(duration) AS duration").
+		Where("job_runner_id = ?", jobRunnerID).
+		Group("status").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	summary := &types.TestSummary{}
+	for _, count := range counts {
+		summary.Total += count.Count
+		summary.Flaky += count.Flaky
+		summary.Duration += count.Duration
+		switch count.Status {
+		case types.TestPassed:
+			summary.Passed = count.Count
+		case types.TestFailed:
+			summary.Failed = count.Count
+		case types.TestSkipped:
+			summary.Skipped = count.Count
+		}
+	}
+
+	var cases []dal.TestCase
+	if err := dal.DB.
+		Order("CASE status WHEN '"+types.TestFailed+"' THEN 0 ELSE 1 END, flaky DESC, id ASC").
+		Limit(testCaseLimit).
+		Find(&cases, "job_runner_id = ?", jobRunnerID).Error; err != nil {
+		return nil, err
+	}
+	summary.Cases = lo.Map(cases, func(item dal.TestCase, _ int) types.TestCaseResp {
+		return item.Format()
+	})
+	return summary, nil
+}
+
+// FlakyTests 返回流水线中被判定为不稳定的测试
+func FlakyTests(ctx context.Context, c *app.RequestContext) {
+	var req types.PipelineTestReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var stats []dal.TestStat
+	if err := dal.DB.Order("failures DESC, id ASC").Find(&stats, "pipeline_id = ? AND flaky = ?", req.PipelineID, true).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	c.JSON(consts.StatusOK, lo.Map(stats, func(item dal.TestStat, _ int) types.TestStatResp {
+		return item.Format()
+	}))
+}
+
+// TestHistory 返回测试在流水线最近几次任务中的结果
+func TestHistory(ctx context.Context, c *app.RequestContext) {
+	var req types.TestHistoryReq
+	if err := c.BindAndValidate(&req); err != nil {
+		c.JSON(consts.StatusBadRequest, utils.H{"error": err.Error()})
+		return
+	}
+
+	var cases []dal.TestCase
+	if err := dal.DB.Order("id DESC").Limit(testHistoryLimit).
+		Find(&cases, "pipeline_id = ? AND suite = ? AND name = ?", req.PipelineID, req.Suite, req.Name).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	var jobs []dal.Job
+	if err := dal.DB.Find(&jobs, "id IN ?", lo.Uniq(lo.Map(cases, func(item dal.TestCase, _ int) uint { return item.JobID }))).Error; err != nil {
+		c.JSON(consts.StatusInternalServerError, utils.H{"error": err.Error()})
+		return
+	}
+	tags := lo.Associate(jobs, func(job dal.Job) (uint, string) { return job.ID, job.Tag })
+
+	c.JSON(consts.StatusOK, lo.Map(cases, func(item dal.TestCase, _ int) types.TestHistoryResp {
+		return types.TestHistoryResp{
+			JobRunnerID: item.JobRunnerID,
+			JobID:       item.JobID,
+			Tag:         tags[item.JobID],
+			Status:      item.Status,
+			Duration:    item.Duration,
+			Message:     item.Message,
+			CreatedAt:   item.CreatedAt.Format("2006-01-02 15:04:05"),
+		}
+	}))
+}
@@ -0,0 +1,34 @@
+package jobexec
+
+import (
+	"os"
+	"time"
+
+	"cicd-server/dal"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// CleanArtifacts 定期删除超过保留时长的构建产物
+func CleanArtifacts() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		var artifacts []dal.Artifact
+		if err := dal.DB.Find(&artifacts, "expire_at < ?", time.Now()).Error; err != nil {
+			hlog.Errorf("get expired artifacts error: %s", err)
+		}
+		for _, artifact := range artifacts {
+			if err := os.Remove(artifact.Path); err != nil && !os.IsNotExist(err) {
+				hlog.Warnf("remove artifact[%d] error: %s", artifact.ID, err)
+				continue
+			}
+			if err := dal.DB.Unscoped().Delete(&artifact).Error; err != nil {
+				hlog.Errorf("delete artifact[%d] error: %s", artifact.ID, err)
+				continue
+			}
+			hlog.Infof("artifact expired: %s", artifact.Path)
+		}
+		<-ticker.C
+	}
+}
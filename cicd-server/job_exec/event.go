@@ -22,60 +22,72 @@ func AddEvent(event *types.Event) {
 
 func StartEventProcess() {
 	for event := range eventChan {
-		var jobRunner dal.JobRunner
-		if err := dal.DB.Last(&jobRunner, "id = ?", event.JobRunnerID).Error; err != nil {
-			hlog.Errorf("get job runner error: %s", err)
-			continue
-		}
+		processEvent(event)
+	}
+}
 
-		// 没有分配runner，直接添加到事件队列
-		if len(jobRunner.AssignRunnerIds) == 0 {
-			AddEvent(event)
-			continue
-		}
+// processEvent 记录 runner 上报的步骤结果，所有 runner 都上报后更新步骤状态并启动下一步
+func processEvent(event *types.Event) {
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", event.JobRunnerID).Error; err != nil {
+		hlog.Errorf("get job runner error: %s", err)
+		return
+	}
 
-		eventStatus := jobRunner.EventStatus
-		for _, runnerId := range jobRunner.AssignRunnerIds {
-			if err := dal.DB.Model(&dal.Runner{}).Where("id = ?", runnerId).Updates(map[string]interface{}{"pipeline_id": 0, "pipeline_name": ""}).Error; err != nil {
-				hlog.Errorf("update runner error: %s", err)
-			}
-		}
-		eventStatus[lo.Ternary(event.Success, dal.Success, dal.Failed)]++
-		updateColumns := map[string]interface{}{
-			"event_status": eventStatus,
-		}
+	// 没有分配runner，直接添加到事件队列
+	if len(jobRunner.AssignRunnerIds) == 0 {
+		AddEvent(event)
+		return
+	}
 
-		var sum int
-		for _, count := range jobRunner.EventStatus {
-			sum += count
+	eventStatus := jobRunner.EventStatus
+	for _, runnerId := range jobRunner.AssignRunnerIds {
+		if err := dal.DB.Model(&dal.Runner{}).Where("id = ?", runnerId).Updates(map[string]interface{}{"pipeline_id": 0, "pipeline_name": ""}).Error; err != nil {
+			hlog.Errorf("update runner error: %s", err)
 		}
+	}
+	eventStatus[lo.Ternary(event.Success, dal.Success, dal.Failed)]++
+	updateColumns := map[string]interface{}{
+		"event_status": eventStatus,
+	}
 
-		if sum == len(jobRunner.AssignRunnerIds) {
-			if c, ok := jobRunner.EventStatus[dal.Success]; ok && c == len(jobRunner.AssignRunnerIds) {
-				jobRunner.Status = dal.Success
-			} else if c > 0 {
-				jobRunner.Status = dal.PartialSuccess
-			} else {
-				jobRunner.Status = dal.Failed
-			}
-			updateColumns["status"] = jobRunner.Status
-		}
+	var sum int
+	for _, count := range jobRunner.EventStatus {
+		sum += count
+	}
 
-		if event.Message != "" {
-			updateColumns["message"] = jobRunner.Message + event.Message + "; "
+	// 已取消的步骤保持取消状态，不会因为 allow_failure 变为允许失败而继续执行后续步骤
+	canceled := jobRunner.Status == dal.Canceled
+	if sum == len(jobRunner.AssignRunnerIds) && !canceled {
+		if c, ok := jobRunner.EventStatus[dal.Success]; ok && c == len(jobRunner.AssignRunnerIds) {
+			jobRunner.Status = dal.Success
+		} else if jobRunner.AllowFailure {
+			jobRunner.Status = dal.AllowedFailure
+		} else if c > 0 {
+			jobRunner.Status = dal.PartialSuccess
+		} else {
+			jobRunner.Status = dal.Failed
 		}
-		updateColumns["end_time"] = time.Now()
+		updateColumns["status"] = jobRunner.Status
+	}
 
-		if err := dal.DB.Model(&dal.JobRunner{}).Where("id = ?", jobRunner.ID).Updates(updateColumns).Error; err != nil {
-			hlog.Errorf("update job runner error: %s", err)
-			continue
-		}
+	if event.Message != "" {
+		updateColumns["message"] = jobRunner.Message + event.Message + "; "
+	}
+	updateColumns["end_time"] = time.Now()
 
-		if sum == len(jobRunner.AssignRunnerIds) {
-			// 判断是否有下一步
-			if !StartNextStep(jobRunner.ID) {
-				StartOtherStep(jobRunner)
-			}
+	if err := dal.DB.Model(&dal.JobRunner{}).Where("id = ?", jobRunner.ID).Updates(updateColumns).Error; err != nil {
+		hlog.Errorf("update job runner error: %s", err)
+		return
+	}
+	NotifyLog(jobRunner.ID)
+	// runner 已空闲，排队的任务可以继续分发
+	queue.notify()
+
+	if sum == len(jobRunner.AssignRunnerIds) && !canceled {
+		// 判断是否有下一步
+		if !StartNextStep(jobRunner.ID) {
+			StartOtherStep(jobRunner)
 		}
 	}
 }
@@ -88,7 +100,7 @@ func StartNextStep(jobRunnerID uint) bool {
 	if err := dal.DB.Last(&jobRunner, "id = ?", jobRunnerID).Error; err != nil {
 		return false
 	}
-	if jobRunner.Status != dal.Success {
+	if !jobRunner.Status.Passed() {
 		return false
 	}
 	if jobRunner.Parallel && jobRunner.StageID > 0 {
@@ -97,7 +109,7 @@ func StartNextStep(jobRunnerID uint) bool {
 			return false
 		}
 		for _, runner := range jobRunners {
-			if runner.Status != dal.Success {
+			if !runner.Status.Passed() {
 				return false
 			}
 		}
@@ -179,7 +191,7 @@ func StartOtherStep(jobRunner dal.JobRunner) {
 			return
 		}
 		for _, runner := range jobRunners {
-			if runner.Status != dal.Success {
+			if !runner.Status.Passed() {
 				db = db.Where("job_id != ?", runner.JobID)
 				break
 			}
@@ -192,6 +204,7 @@ func StartOtherStep(jobRunner dal.JobRunner) {
 	if len(jobRunners) == 0 {
 		return
 	}
+	sortQueued(jobRunners)
 
 	var runnerLabels []dal.RunnerLabel
 	if err := dal.DB.Find(&runnerLabels, "runner_id IN (?)", assignRunnerIds).Error; err != nil {
@@ -0,0 +1,86 @@
+package jobexec
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cicd-server/dal"
+	"cicd-server/types"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func initTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cicd.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&dal.Job{}, &dal.JobRunner{}, &dal.Runner{}, &dal.Git{}); err != nil {
+		t.Fatal(err)
+	}
+	dal.DB = db
+}
+
+// 创建一个任务，第一步允许失败并以 status 状态运行在 runner 上，第二步等待执行
+func createTestSteps(t *testing.T, status dal.Status) (first, second dal.JobRunner) {
+	t.Helper()
+	job := dal.Job{}
+	if err := dal.DB.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	first = dal.JobRunner{JobID: job.ID, StepID: 1, AllowFailure: true, Status: status, AssignRunnerIds: dal.AssignRunnerIds{1}, EventStatus: dal.EventStatus{}}
+	second = dal.JobRunner{JobID: job.ID, StepID: 2, Status: dal.Pending, EventStatus: dal.EventStatus{}}
+	if err := dal.DB.Create(&first).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := dal.DB.Create(&second).Error; err != nil {
+		t.Fatal(err)
+	}
+	return first, second
+}
+
+func jobRunnerStatus(t *testing.T, id uint) dal.Status {
+	t.Helper()
+	var jobRunner dal.JobRunner
+	if err := dal.DB.Last(&jobRunner, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return jobRunner.Status
+}
+
+func TestEventAllowFailure(t *testing.T) {
+	initTestDB(t)
+	first, second := createTestSteps(t, dal.Running)
+
+	processEvent(&types.Event{JobRunnerID: first.ID, Success: false})
+	if status := jobRunnerStatus(t, first.ID); status != dal.AllowedFailure {
+		t.Errorf("first step status = %s", status)
+	}
+	if status := jobRunnerStatus(t, second.ID); status != dal.Queueing {
+		t.Errorf("second step status = %s", status)
+	}
+	// 丢弃 StartNextStep 加入的任务
+	<-jobChan
+}
+
+// 取消允许失败的步骤后，runner 上报的失败不能把步骤改为允许失败并继续执行后续步骤
+func TestEventCanceledAllowFailure(t *testing.T) {
+	initTestDB(t)
+	first, second := createTestSteps(t, dal.Canceled)
+
+	processEvent(&types.Event{JobRunnerID: first.ID, Success: false})
+	if status := jobRunnerStatus(t, first.ID); status != dal.Canceled {
+		t.Errorf("first step status = %s", status)
+	}
+	if status := jobRunnerStatus(t, second.ID); status != dal.Pending {
+		t.Errorf("second step status = %s", status)
+	}
+	if len(jobChan) != 0 {
+		t.Errorf("%d jobs started", len(jobChan))
+	}
+}
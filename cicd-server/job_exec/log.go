@@ -0,0 +1,48 @@
+package jobexec
+
+import (
+	"errors"
+	"time"
+
+	"cicd-server/dal"
+	"cicd-server/logstore"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"gorm.io/gorm"
+)
+
+// 步骤结束且日志超过该时间没有更新后压缩归档，留出时间接收 runner 重传的日志
+const logFinalizeDelay = 2 * time.Minute
+
+// FinalizeLogs 定期把已结束步骤的日志压缩写入日志存储
+func FinalizeLogs() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		logs, err := logstore.Default.Live()
+		if err != nil {
+			hlog.Errorf("list live logs error: %s", err)
+		}
+		for _, log := range logs {
+			if time.Since(log.ModTime) < logFinalizeDelay {
+				continue
+			}
+			var jobRunner dal.JobRunner
+			err := dal.DB.Select("status").Last(&jobRunner, "id = ?", log.JobRunnerID).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				hlog.Errorf("get job runner error: %s", err)
+				continue
+			}
+			// 步骤记录已删除的日志同样归档
+			if err == nil && !jobRunner.Status.Finished() {
+				continue
+			}
+			if err := logstore.Default.Finalize(log.JobRunnerID); err != nil {
+				hlog.Errorf("finalize log[%d] error: %s", log.JobRunnerID, err)
+				continue
+			}
+			hlog.Infof("log finalized: %d", log.JobRunnerID)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,43 @@
+package jobexec
+
+import "sync"
+
+var (
+	logSubscriberMutex sync.Mutex
+	logSubscribers     = make(map[uint]map[chan struct{}]struct{})
+)
+
+// SubscribeLog 订阅步骤日志的更新通知，多次更新会合并为一次通知
+func SubscribeLog(jobRunnerID uint) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	logSubscriberMutex.Lock()
+	subscribers, ok := logSubscribers[jobRunnerID]
+	if !ok {
+		subscribers = make(map[chan struct{}]struct{})
+		logSubscribers[jobRunnerID] = subscribers
+	}
+	subscribers[ch] = struct{}{}
+	logSubscriberMutex.Unlock()
+
+	return ch, func() {
+		logSubscriberMutex.Lock()
+		defer logSubscriberMutex.Unlock()
+		delete(subscribers, ch)
+		if len(subscribers) == 0 {
+			delete(logSubscribers, jobRunnerID)
+		}
+	}
+}
+
+// NotifyLog 通知订阅者步骤有新日志或状态发生变化
+func NotifyLog(jobRunnerID uint) {
+	logSubscriberMutex.Lock()
+	defer logSubscriberMutex.Unlock()
+	for ch := range logSubscribers[jobRunnerID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"cicd-server/dal"
+	"cicd-server/utils"
 
 	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/samber/lo"
@@ -53,98 +55,113 @@ func (j *JobExec) UpdateJobRunner(jobRunner dal.JobRunner, status dal.Status, me
 }
 
 func Run() {
-	for job := range jobChan {
-		for _, jr := range job.AllJobRunners {
-			hlog.Infof("start job runner: %+v", jr)
+	go func() {
+		for job := range jobChan {
+			queue.push(job)
+		}
+	}()
 
-			if job.Git.ID > 0 {
-				if job.Git.CommitID == "" {
-					job.UpdateJobRunner(jr, dal.Failed, "git commit id is empty", nil, nil, nil)
-					continue
-				}
-			}
+	queue.run((*JobExec).dispatch)
+}
 
-			// 检查任务是否被取消
-			var jobRunner dal.JobRunner
-			if err := dal.DB.Last(&jobRunner, "id = ?", jr.ID).Error; err != nil {
-				hlog.Errorf("get job runner[%d] error: %s", jr.ID, err)
+// dispatch 把步骤发送到匹配的 runner，返回没有空闲 runner、需要继续排队的步骤
+func (job *JobExec) dispatch(jobRunners []dal.JobRunner) []dal.JobRunner {
+	var waiting []dal.JobRunner
+	for _, jr := range jobRunners {
+		if job.Git.ID > 0 {
+			if job.Git.CommitID == "" {
+				job.UpdateJobRunner(jr, dal.Failed, "git commit id is empty", nil, nil, nil)
 				continue
 			}
+		}
 
-			if jobRunner.Status != dal.Queueing {
-				hlog.Infof("job runner[%d] status is not queueing, skip", jr.ID)
-				continue
-			}
+		// 检查任务是否被取消
+		var jobRunner dal.JobRunner
+		if err := dal.DB.Last(&jobRunner, "id = ?", jr.ID).Error; err != nil {
+			hlog.Errorf("get job runner[%d] error: %s", jr.ID, err)
+			continue
+		}
 
-			var s dal.Step
-			if err := dal.DB.Last(&s, "id = ?", jr.StepID).Error; err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					hlog.Infof("step[%d] not found", jr.StepID)
-					continue
-				}
-				hlog.Errorf("get step[%d] error: %s", jr.StepID, err)
-				job.UpdateJobRunner(jr, dal.Failed, err.Error(), nil, nil, nil)
-				continue
-			}
+		if jobRunner.Status != dal.Queueing {
+			hlog.Infof("job runner[%d] status is not queueing, skip", jr.ID)
+			continue
+		}
 
-			runners, err := matchRunners(s.RunnerLabelMatch)
-			if err != nil {
-				hlog.Errorf("detect idle runners error: %s", err)
-				job.UpdateJobRunner(jr, dal.Failed, err.Error(), nil, nil, nil)
+		var s dal.Step
+		if err := dal.DB.Last(&s, "id = ?", jr.StepID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				hlog.Infof("step[%d] not found", jr.StepID)
 				continue
 			}
-			start := time.Now()
-			if len(runners) > 0 {
-				if s.MultipleRunnerExec {
-					var runnerIds dal.AssignRunnerIds
-					var status dal.Status
-					var message string
-					for _, runner := range runners {
-						runnerIds = append(runnerIds, runner.ID)
-						// 发送到runner
-						if err := sendJob(runner, *job, jr); err != nil {
-							hlog.Errorf("send job error: %s", err)
-							switch status {
-							case "":
-								status = dal.Failed
-							case dal.Success:
-								status = dal.PartialRunning
-							}
-							message += fmt.Sprintf("send job to runner[%s] error: %s; ", runner.Name, err)
-							continue
-						}
+			hlog.Errorf("get step[%d] error: %s", jr.StepID, err)
+			job.UpdateJobRunner(jr, dal.Failed, err.Error(), nil, nil, nil)
+			continue
+		}
 
-						// if step success
-						if status == dal.Failed || status == dal.PartialRunning {
+		runners, err := matchRunners(s)
+		if err != nil {
+			hlog.Errorf("detect idle runners error: %s", err)
+			job.UpdateJobRunner(jr, dal.Failed, err.Error(), nil, nil, nil)
+			continue
+		}
+		start := time.Now()
+		if len(runners) > 0 {
+			if s.MultipleRunnerExec {
+				hlog.Infof("start job runner: %+v", jr)
+				var runnerIds dal.AssignRunnerIds
+				var status dal.Status
+				var message string
+				for _, runner := range runners {
+					runnerIds = append(runnerIds, runner.ID)
+					// 发送到runner
+					if err := sendJob(runner, *job, jr); err != nil {
+						hlog.Errorf("send job error: %s", err)
+						switch status {
+						case "":
+							status = dal.Failed
+						case dal.Success:
 							status = dal.PartialRunning
-						} else {
-							status = dal.Running
 						}
+						message += fmt.Sprintf("send job to runner[%s] error: %s; ", runner.Name, err)
+						continue
 					}
-					job.UpdateJobRunner(jr, status, message, runnerIds, &start, nil)
-				} else {
-					for _, runner := range runners {
-						if runner.PipelineID == 0 || (runner.StageID == jr.StageID && runner.StageParallel) {
-							// 发送到runner
-							runnerIds := dal.AssignRunnerIds{runner.ID}
-							if err := sendJob(runner, *job, jr); err != nil {
-								hlog.Errorf("send job error: %s", err)
-								job.UpdateJobRunner(jr, dal.Failed, err.Error(), runnerIds, nil, nil)
-								break
-							}
-
-							// if step success
-							job.UpdateJobRunner(jr, dal.Running, "", runnerIds, &start, nil)
-							break
-						}
+
+					// if step success
+					if status == dal.Failed || status == dal.PartialRunning {
+						status = dal.PartialRunning
+					} else {
+						status = dal.Running
 					}
 				}
+				job.UpdateJobRunner(jr, status, message, runnerIds, &start, nil)
+			} else {
+				runner, ok := lo.Find(runners, func(runner *dal.Runner) bool {
+					return runner.PipelineID == 0 || (runner.StageID == jr.StageID && runner.StageParallel)
+				})
+				if !ok {
+					// 没有空闲的 runner，继续排队
+					waiting = append(waiting, jr)
+					continue
+				}
+				hlog.Infof("start job runner: %+v", jr)
+				// 发送到runner
+				runnerIds := dal.AssignRunnerIds{runner.ID}
+				if err := sendJob(runner, *job, jr); err != nil {
+					hlog.Errorf("send job error: %s", err)
+					job.UpdateJobRunner(jr, dal.Failed, err.Error(), runnerIds, nil, nil)
+					continue
+				}
+
+				// if step success
+				job.UpdateJobRunner(jr, dal.Running, "", runnerIds, &start, nil)
 			}
 		}
 	}
+	return waiting
 }
 
-func matchRunners(labelMatch string) ([]*dal.Runner, error) {
+func matchRunners(step dal.Step) ([]*dal.Runner, error) {
+	labelMatch := step.RunnerLabelMatch
 	var runnerLabels []dal.RunnerLabel
 	if err := dal.DB.Find(&runnerLabels, "label = ?", labelMatch).Error; err != nil {
 		return nil, err
@@ -166,15 +183,100 @@ func matchRunners(labelMatch string) ([]*dal.Runner, error) {
 		return nil, fmt.Errorf("no available runner: %s", labelMatch)
 	}
 
+	if step.Image != "" {
+		runners = lo.Filter(runners, func(runner *dal.Runner, _ int) bool {
+			return runner.HasFeature("container")
+		})
+		if len(runners) == 0 {
+			return nil, fmt.Errorf("no available runner supports container executor: %s", labelMatch)
+		}
+	}
+
+	if step.Mode == dal.StepModeScript {
+		runners = lo.Filter(runners, func(runner *dal.Runner, _ int) bool {
+			return runner.HasFeature("script")
+		})
+		if len(runners) == 0 {
+			return nil, fmt.Errorf("no available runner supports script mode: %s", labelMatch)
+		}
+	}
+
+	if len(step.Artifacts) > 0 || len(step.ArtifactDownloads) > 0 {
+		runners = lo.Filter(runners, func(runner *dal.Runner, _ int) bool {
+			return runner.HasFeature("artifacts")
+		})
+		if len(runners) == 0 {
+			return nil, fmt.Errorf("no available runner supports artifacts: %s", labelMatch)
+		}
+	}
+
+	if len(step.TestReports) > 0 {
+		runners = lo.Filter(runners, func(runner *dal.Runner, _ int) bool {
+			return runner.HasFeature("test_reports")
+		})
+		if len(runners) == 0 {
+			return nil, fmt.Errorf("no available runner supports test reports: %s", labelMatch)
+		}
+	}
+
+	return placeRunners(runners, step.MinDiskFree)
+}
+
+// placeRunners 过滤剩余磁盘不足的 runner，并按负载从低到高排序，没有监控数据的排在最后
+func placeRunners(runners []*dal.Runner, minDiskFree int64) ([]*dal.Runner, error) {
+	metrics := make(map[uint]*dal.RunnerMetric, len(runners))
+	for _, runner := range runners {
+		metrics[runner.ID] = dal.LatestRunnerMetric(runner.ID)
+	}
+
+	if minDiskFree > 0 {
+		runners = lo.Filter(runners, func(runner *dal.Runner, _ int) bool {
+			m := metrics[runner.ID]
+			return m == nil || m.DiskFree >= uint64(minDiskFree)*1024*1024
+		})
+		if len(runners) == 0 {
+			return nil, fmt.Errorf("no runner has %d MB free disk", minDiskFree)
+		}
+	}
+
+	sort.SliceStable(runners, func(i, j int) bool {
+		mi, mj := metrics[runners[i].ID], metrics[runners[j].ID]
+		if mi == nil || mj == nil {
+			return mi != nil
+		}
+		return mi.Load() < mj.Load()
+	})
 	return runners, nil
 }
 
 func sendJob(runner *dal.Runner, job JobExec, jobRunner dal.JobRunner) error {
 	client := &http.Client{}
 	job.JobRunner = jobRunner
+	// 数据库中只保存加密后的 secret 变量和 git 密码，下发时才解密
+	envs, err := job.Job.Envs.Decrypt()
+	if err != nil {
+		return fmt.Errorf("decrypt envs error: %s", err)
+	}
+	job.Job.Envs = envs
+	if job.Git.Password, err = job.Git.PlainPassword(); err != nil {
+		return fmt.Errorf("decrypt git password error: %s", err)
+	}
 	jsonBytes, _ := json.Marshal(job)
+	encrypted := runner.HasFeature("encrypted_job")
+	if encrypted {
+		// runner 支持时加密请求体，凭据不以明文经过网络
+		if jsonBytes, err = utils.Seal(utils.TransportKey(runner.Secret), jsonBytes); err != nil {
+			return fmt.Errorf("encrypt job error: %s", err)
+		}
+	}
 	httpReq, _ := http.NewRequest("POST", runner.Endpoint+"/start_job", bytes.NewReader(jsonBytes))
-	httpReq.Header.Set("Content-Type", "application/json")
+	if encrypted {
+		httpReq.Header.Set("Content-Type", "application/octet-stream")
+		httpReq.Header.Set(utils.HeaderEncryption, "aes-256-gcm")
+	} else {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	utils.SignRequest(httpReq, runner.Name, runner.Secret, jsonBytes)
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		if opErr, ok := err.(*net.OpError); ok {
@@ -0,0 +1,175 @@
+package jobexec
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cicd-server/dal"
+	"cicd-server/logstore"
+	"cicd-server/types"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+var (
+	retentionReport      types.RetentionReport
+	retentionReportMutex sync.Mutex
+)
+
+// LastRetentionReport 最近一次清理的结果
+func LastRetentionReport() types.RetentionReport {
+	retentionReportMutex.Lock()
+	defer retentionReportMutex.Unlock()
+	return retentionReport
+}
+
+// CleanJobs 定期按保留规则删除过期任务的日志、构建产物和数据库记录
+func CleanJobs() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		report := cleanJobs()
+		if report.Jobs > 0 {
+			hlog.Infof("retention: deleted %d jobs, %d job runners, %d logs (%s), %d artifacts (%s)",
+				report.Jobs, report.JobRunners, report.Logs, formatBytes(report.LogBytes), report.Artifacts, formatBytes(report.ArtifactBytes))
+		}
+		retentionReportMutex.Lock()
+		retentionReport = report
+		retentionReportMutex.Unlock()
+		<-ticker.C
+	}
+}
+
+func cleanJobs() types.RetentionReport {
+	report := types.RetentionReport{StartTime: time.Now()}
+	defer func() {
+		report.EndTime = time.Now()
+	}()
+
+	// 流水线删除后，遗留的任务按全局规则清理
+	var pipelineIDs []uint
+	if err := dal.DB.Model(&dal.Job{}).Distinct().Pluck("pipeline_id", &pipelineIDs).Error; err != nil {
+		hlog.Errorf("get job pipelines error: %s", err)
+		return report
+	}
+	for _, pipelineID := range pipelineIDs {
+		retention := dal.GlobalJobRetention()
+		var pipeline dal.Pipeline
+		if err := dal.DB.Last(&pipeline, "id = ?", pipelineID).Error; err == nil {
+			retention = pipeline.JobRetention()
+		}
+		if !retention.Enabled() {
+			continue
+		}
+
+		var jobs []dal.Job
+		if err := dal.DB.Order("id DESC").Find(&jobs, "pipeline_id = ?", pipelineID).Error; err != nil {
+			hlog.Errorf("get jobs of pipeline %d error: %s", pipelineID, err)
+			continue
+		}
+		// 已部署过的 tag，每个 tag 最近一次成功的任务始终保留，用于回滚到旧版本
+		deployedTags := make(map[string]bool)
+		for i, job := range jobs {
+			var jobRunners []dal.JobRunner
+			if err := dal.DB.Find(&jobRunners, "job_id = ?", job.ID).Error; err != nil {
+				hlog.Errorf("get job runners of job %d error: %s", job.ID, err)
+				continue
+			}
+			if !deployedTags[job.Tag] && deployed(jobRunners) {
+				deployedTags[job.Tag] = true
+				continue
+			}
+			if !retention.Expired(i, job.CreatedAt) {
+				continue
+			}
+			if lo.SomeBy(jobRunners, func(jr dal.JobRunner) bool {
+				return jr.Status == dal.Queueing || jr.Status == dal.Running || jr.Status == dal.PartialRunning
+			}) {
+				continue
+			}
+			if err := deleteJob(job, jobRunners, &report); err != nil {
+				hlog.Errorf("delete job %d error: %s", job.ID, err)
+			}
+		}
+	}
+	return report
+}
+
+// deployed 任务是否成功部署：所有步骤都已通过，且不是只靠允许失败的步骤通过
+func deployed(jobRunners []dal.JobRunner) bool {
+	return lo.EveryBy(jobRunners, func(jr dal.JobRunner) bool { return jr.Status.Passed() }) &&
+		lo.SomeBy(jobRunners, func(jr dal.JobRunner) bool { return jr.Status == dal.Success })
+}
+
+// deleteJob 删除任务的日志、构建产物和数据库记录
+func deleteJob(job dal.Job, jobRunners []dal.JobRunner, report *types.RetentionReport) error {
+	for _, jobRunner := range jobRunners {
+		size, err := logstore.Default.Delete(jobRunner.ID)
+		if err != nil {
+			return fmt.Errorf("delete log of job runner %d: %w", jobRunner.ID, err)
+		}
+		if size > 0 {
+			report.Logs++
+			report.LogBytes += size
+		}
+	}
+
+	var artifacts []dal.Artifact
+	if err := dal.DB.Find(&artifacts, "job_id = ?", job.ID).Error; err != nil {
+		return err
+	}
+	for _, artifact := range artifacts {
+		if err := os.Remove(artifact.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		report.Artifacts++
+		report.ArtifactBytes += artifact.Size
+	}
+
+	jobRunnerIDs := lo.Map(jobRunners, func(jr dal.JobRunner, _ int) uint { return jr.ID })
+	if err := dal.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&dal.Artifact{}, "job_id = ?", job.ID).Error; err != nil {
+			return err
+		}
+		if len(jobRunnerIDs) > 0 {
+			if err := tx.Unscoped().Delete(&dal.LogCursor{}, "job_runner_id IN ?", jobRunnerIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&dal.LogLine{}, "job_runner_id IN ?", jobRunnerIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&dal.Annotation{}, "job_runner_id IN ?", jobRunnerIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&dal.TestCase{}, "job_runner_id IN ?", jobRunnerIDs).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Delete(&dal.JobRunner{}, "job_id = ?", job.ID).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&dal.Job{}, "id = ?", job.ID).Error
+	}); err != nil {
+		return err
+	}
+	report.Jobs++
+	report.JobRunners += len(jobRunners)
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,176 @@
+package jobexec
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"cicd-server/dal"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/samber/lo"
+)
+
+var queue = newJobQueue()
+
+// 没有新任务或 runner 空闲的通知时，按该间隔重新尝试分发，覆盖 runner 上线等情况
+const scheduleInterval = 5 * time.Second
+
+// jobQueue 按优先级和分组公平份额排序的待分发任务队列，任务一直排队到有空闲的 runner
+type jobQueue struct {
+	mu   sync.Mutex
+	seq  uint64
+	jobs []*queuedJob
+	wake chan struct{}
+}
+
+type queuedJob struct {
+	seq uint64
+	job *JobExec
+	// 还在等待空闲 runner 的步骤
+	waiting []dal.JobRunner
+}
+
+// dispatchFunc 分发任务中的步骤，返回仍需等待空闲 runner 的步骤
+type dispatchFunc func(job *JobExec, jobRunners []dal.JobRunner) []dal.JobRunner
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{wake: make(chan struct{}, 1)}
+}
+
+// push 加入队列，已经在排队的步骤不重复加入
+func (q *jobQueue) push(job *JobExec) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := make(map[uint]bool)
+	for _, qj := range q.jobs {
+		for _, jr := range qj.waiting {
+			queued[jr.ID] = true
+		}
+	}
+	waiting := lo.Filter(job.AllJobRunners, func(jr dal.JobRunner, _ int) bool { return !queued[jr.ID] })
+	if len(waiting) == 0 {
+		return
+	}
+	q.seq++
+	q.jobs = append(q.jobs, &queuedJob{seq: q.seq, job: job, waiting: waiting})
+	q.notify()
+}
+
+// notify 唤醒调度，在加入任务或 runner 空闲时调用
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 不断分发队列中的任务，没有可以分发的任务时等待唤醒
+func (q *jobQueue) run(dispatch dispatchFunc) {
+	timer := time.NewTimer(scheduleInterval)
+	defer timer.Stop()
+	for {
+		for q.dispatchNext(dispatch, groupRunningCount()) {
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(scheduleInterval)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// dispatchNext 每次分发前重新排序：优先级高的先分发；优先级相同时，正在运行任务最少的分组先分发；再按入队顺序。
+// 按顺序分发第一个有空闲 runner 的任务，返回是否有步骤被分发
+func (q *jobQueue) dispatchNext(dispatch dispatchFunc, running map[string]int) bool {
+	q.mu.Lock()
+	jobs := append([]*queuedJob(nil), q.jobs...)
+	q.mu.Unlock()
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return lessJob(jobs[i].job.Job, jobs[i].seq, jobs[j].job.Job, jobs[j].seq, running)
+	})
+
+	for _, qj := range jobs {
+		// 只有调度协程修改 waiting 和删除任务，分发时不持有锁，避免阻塞入队
+		waiting := dispatch(qj.job, qj.waiting)
+		if len(waiting) == len(qj.waiting) {
+			continue
+		}
+		q.mu.Lock()
+		qj.waiting = waiting
+		if len(waiting) == 0 {
+			q.jobs = lo.Filter(q.jobs, func(item *queuedJob, _ int) bool { return item != qj })
+		}
+		q.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+func lessJob(a dal.Job, aSeq uint64, b dal.Job, bSeq uint64, running map[string]int) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if running[a.GroupName] != running[b.GroupName] {
+		return running[a.GroupName] < running[b.GroupName]
+	}
+	return aSeq < bSeq
+}
+
+// groupRunningCount 统计每个流水线分组正在运行的步骤数
+func groupRunningCount() map[string]int {
+	running := make(map[string]int)
+
+	var jobRunners []dal.JobRunner
+	if err := dal.DB.Select("job_id").Find(&jobRunners, "status IN (?)", []dal.Status{dal.Running, dal.PartialRunning}).Error; err != nil {
+		hlog.Errorf("get running job runners error: %s", err)
+		return running
+	}
+	if len(jobRunners) == 0 {
+		return running
+	}
+
+	var jobs []dal.Job
+	if err := dal.DB.Select("id", "group_name").Find(&jobs, "id IN (?)", lo.Uniq(lo.Map(jobRunners, func(item dal.JobRunner, _ int) uint {
+		return item.JobID
+	}))).Error; err != nil {
+		hlog.Errorf("get running jobs error: %s", err)
+		return running
+	}
+	groupBy := lo.Associate(jobs, func(item dal.Job) (uint, string) {
+		return item.ID, item.GroupName
+	})
+	for _, jobRunner := range jobRunners {
+		running[groupBy[jobRunner.JobID]]++
+	}
+	return running
+}
+
+// sortQueued 对排队中的步骤按调度顺序排序
+func sortQueued(jobRunners []dal.JobRunner) {
+	if len(jobRunners) < 2 {
+		return
+	}
+
+	var jobs []dal.Job
+	if err := dal.DB.Find(&jobs, "id IN (?)", lo.Uniq(lo.Map(jobRunners, func(item dal.JobRunner, _ int) uint {
+		return item.JobID
+	}))).Error; err != nil {
+		hlog.Errorf("get queued jobs error: %s", err)
+		return
+	}
+	jobBy := lo.Associate(jobs, func(item dal.Job) (uint, dal.Job) {
+		return item.ID, item
+	})
+
+	running := groupRunningCount()
+	sort.SliceStable(jobRunners, func(i, j int) bool {
+		return lessJob(jobBy[jobRunners[i].JobID], uint64(jobRunners[i].ID), jobBy[jobRunners[j].JobID], uint64(jobRunners[j].ID), running)
+	})
+}
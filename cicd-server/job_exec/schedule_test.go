@@ -0,0 +1,84 @@
+package jobexec
+
+import (
+	"testing"
+
+	"cicd-server/dal"
+)
+
+func newTestJob(jobRunnerID uint, priority int, group string) *JobExec {
+	job := NewJobExec(dal.Job{Priority: priority, GroupName: group}, []dal.JobRunner{{}}, dal.Git{})
+	job.AllJobRunners[0].ID = jobRunnerID
+	return job
+}
+
+// testRunners 模拟 free 个空闲 runner，按分发顺序记录步骤
+type testRunners struct {
+	free       int
+	dispatched []uint
+}
+
+func (r *testRunners) dispatch(job *JobExec, jobRunners []dal.JobRunner) []dal.JobRunner {
+	var waiting []dal.JobRunner
+	for _, jr := range jobRunners {
+		if r.free == 0 {
+			waiting = append(waiting, jr)
+			continue
+		}
+		r.free--
+		r.dispatched = append(r.dispatched, jr.ID)
+	}
+	return waiting
+}
+
+// 先入队的低优先级任务在没有空闲 runner 时继续排队，runner 空闲后后入队的高优先级任务先分发
+func TestQueuePriority(t *testing.T) {
+	q := newJobQueue()
+	runners := &testRunners{}
+	q.push(newTestJob(1, 0, "a"))
+	if q.dispatchNext(runners.dispatch, nil) {
+		t.Fatal("dispatched without free runner")
+	}
+	q.push(newTestJob(2, 10, "a"))
+
+	runners.free = 1
+	if !q.dispatchNext(runners.dispatch, nil) {
+		t.Fatal("nothing dispatched")
+	}
+	runners.free = 1
+	q.dispatchNext(runners.dispatch, nil)
+	if len(runners.dispatched) != 2 || runners.dispatched[0] != 2 || runners.dispatched[1] != 1 {
+		t.Errorf("dispatched = %v", runners.dispatched)
+	}
+	if len(q.jobs) != 0 {
+		t.Errorf("%d jobs left in queue", len(q.jobs))
+	}
+}
+
+// 优先级相同时，正在运行任务较少的分组先分发，每次分发前按最新的运行数重新排序
+func TestQueueFairShare(t *testing.T) {
+	q := newJobQueue()
+	runners := &testRunners{}
+	q.push(newTestJob(1, 0, "a"))
+	q.push(newTestJob(2, 0, "a"))
+	q.push(newTestJob(3, 0, "b"))
+
+	running := map[string]int{"a": 1}
+	runners.free = 1
+	q.dispatchNext(runners.dispatch, running)
+	running["b"]++
+	runners.free = 1
+	q.dispatchNext(runners.dispatch, running)
+	if len(runners.dispatched) != 2 || runners.dispatched[0] != 3 || runners.dispatched[1] != 1 {
+		t.Errorf("dispatched = %v", runners.dispatched)
+	}
+}
+
+func TestQueueDuplicate(t *testing.T) {
+	q := newJobQueue()
+	q.push(newTestJob(1, 0, "a"))
+	q.push(newTestJob(1, 0, "a"))
+	if len(q.jobs) != 1 {
+		t.Errorf("%d jobs in queue", len(q.jobs))
+	}
+}
@@ -0,0 +1,43 @@
+package logstore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FSBackend 把已完成的日志保存在本地目录
+type FSBackend struct {
+	dir string
+}
+
+func NewFSBackend(dir string) *FSBackend {
+	return &FSBackend{dir: dir}
+}
+
+func (b *FSBackend) Put(key string, r io.Reader, size int64) error {
+	return writeFile(filepath.Join(b.dir, key), r)
+}
+
+func (b *FSBackend) Get(key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(b.dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (b *FSBackend) Delete(key string) (int64, error) {
+	path := filepath.Join(b.dir, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Remove(path)
+}
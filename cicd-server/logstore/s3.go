@@ -0,0 +1,216 @@
+package logstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Backend 把已完成的日志保存在 S3 兼容的对象存储（AWS S3、MinIO 等），使用 path-style 地址访问
+type S3Backend struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewS3Backend 通过环境变量配置：CICD_LOG_S3_ENDPOINT（如 http://127.0.0.1:9000）、CICD_LOG_S3_BUCKET、
+// CICD_LOG_S3_ACCESS_KEY、CICD_LOG_S3_SECRET_KEY，以及可选的 CICD_LOG_S3_REGION（默认 us-east-1）
+// 和 CICD_LOG_S3_PREFIX（默认 logs/）
+func NewS3Backend() (*S3Backend, error) {
+	endpoint, err := url.Parse(os.Getenv("CICD_LOG_S3_ENDPOINT"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, errors.New("CICD_LOG_S3_ENDPOINT must be an url like http://127.0.0.1:9000")
+	}
+	b := &S3Backend{
+		endpoint:  endpoint,
+		bucket:    os.Getenv("CICD_LOG_S3_BUCKET"),
+		prefix:    "logs/",
+		region:    "us-east-1",
+		accessKey: os.Getenv("CICD_LOG_S3_ACCESS_KEY"),
+		secretKey: os.Getenv("CICD_LOG_S3_SECRET_KEY"),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if prefix, ok := os.LookupEnv("CICD_LOG_S3_PREFIX"); ok {
+		b.prefix = prefix
+	}
+	if region := os.Getenv("CICD_LOG_S3_REGION"); region != "" {
+		b.region = region
+	}
+	if b.bucket == "" || b.accessKey == "" || b.secretKey == "" {
+		return nil, errors.New("CICD_LOG_S3_BUCKET, CICD_LOG_S3_ACCESS_KEY and CICD_LOG_S3_SECRET_KEY are required")
+	}
+	return b, nil
+}
+
+func (b *S3Backend) objectURL(key string) string {
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.bucket + "/" + b.prefix + key
+	return u.String()
+}
+
+func (b *S3Backend) Put(key string, r io.Reader, size int64) error {
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *S3Backend) Get(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, b.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *S3Backend) Delete(key string) (int64, error) {
+	// 先查询对象大小，用于统计清理释放的空间
+	req, err := http.NewRequest(http.MethodHead, b.objectURL(key), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := b.do(req)
+	if err == ErrNotExist {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	size := resp.ContentLength
+
+	req, err = http.NewRequest(http.MethodDelete, b.objectURL(key), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err = b.do(req)
+	if err == ErrNotExist {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// do 签名并发送请求，对象不存在时返回 ErrNotExist，其他非 2xx 响应返回错误
+func (b *S3Backend) do(req *http.Request) (*http.Response, error) {
+	// 请求体不参与签名，上传时无需先读取整个文件计算摘要
+	signV4(req, b.accessKey, b.secretKey, b.region, "UNSIGNED-PAYLOAD", time.Now())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("%s %s: status code: %d, body: %s", req.Method, req.URL.Path, resp.StatusCode, string(body))
+}
+
+// signV4 按 AWS Signature Version 4 为请求添加 Authorization 头，签名 host、range、content-type 和 x-amz-* 头
+func signV4(req *http.Request, accessKey, secretKey, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if key == "range" || key == "content-type" || strings.HasPrefix(key, "x-amz-") {
+			headers[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
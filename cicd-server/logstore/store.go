@@ -0,0 +1,358 @@
+package logstore
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotExist 日志不存在
+var ErrNotExist = errors.New("log not exist")
+
+// 已完成日志解压后的本地缓存超过该时间没有读取时删除
+const cacheTTL = 10 * time.Minute
+
+// Backend 保存已完成的日志。日志完成后压缩写入一次，之后只读
+type Backend interface {
+	Put(key string, r io.Reader, size int64) error
+	// Get 读取对象，对象不存在时返回 ErrNotExist
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除对象并返回释放的字节数，对象不存在时不返回错误
+	Delete(key string) (int64, error)
+}
+
+// Store 步骤日志存储。执行中的日志追加到本地 live 目录，步骤结束后压缩写入 Backend，
+// 读取已完成的日志时解压到本地 cache 目录，之后的读取不再从头下载和解压
+type Store struct {
+	liveDir  string
+	cacheDir string
+	backend  Backend
+	mutex    sync.Mutex
+}
+
+// LiveLog 尚未完成的日志
+type LiveLog struct {
+	JobRunnerID uint
+	ModTime     time.Time
+}
+
+var Default *Store
+
+// Init 根据环境变量初始化默认日志存储：
+// CICD_LOG_DIR 日志目录，默认 ~/.cicd-server/logs；
+// CICD_LOG_STORAGE 已完成日志的存储方式，fs（默认）或 s3，s3 的配置见 NewS3Backend
+func Init() {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatalf("get home dir error: %s", err)
+	}
+	dir := os.Getenv("CICD_LOG_DIR")
+	if dir == "" {
+		dir = filepath.Join(homeDir, ".cicd-server", "logs")
+		migrateLegacyLogs(filepath.Join(homeDir, ".cicd-runner", "logs"), filepath.Join(dir, "live"))
+	}
+
+	var backend Backend
+	switch storage := os.Getenv("CICD_LOG_STORAGE"); storage {
+	case "", "fs":
+		backend = NewFSBackend(filepath.Join(dir, "finished"))
+	case "s3":
+		if backend, err = NewS3Backend(); err != nil {
+			log.Fatalf("init s3 log storage error: %s", err)
+		}
+	default:
+		log.Fatalf("unknown log storage: %s", storage)
+	}
+
+	if Default, err = New(filepath.Join(dir, "live"), filepath.Join(dir, "cache"), backend); err != nil {
+		log.Fatalf("init log storage error: %s", err)
+	}
+}
+
+// migrateLegacyLogs 旧版本把日志写在 ~/.cicd-runner/logs，迁移到 live 目录后按未完成日志处理
+func migrateLegacyLogs(legacyDir, liveDir string) {
+	if _, err := os.Stat(legacyDir); err != nil {
+		return
+	}
+	if _, err := os.Stat(liveDir); err == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(liveDir), os.ModePerm); err != nil {
+		log.Fatalf("mkdir error: %s", err)
+	}
+	if err := os.Rename(legacyDir, liveDir); err != nil {
+		log.Fatalf("migrate logs from %s error: %s", legacyDir, err)
+	}
+	log.Printf("migrate logs from %s to %s", legacyDir, liveDir)
+}
+
+func New(liveDir, cacheDir string, backend Backend) (*Store, error) {
+	if err := os.MkdirAll(liveDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	// 缓存只在本次运行中有效，日志可能在 server 停止期间被修改或删除
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Store{liveDir: liveDir, cacheDir: cacheDir, backend: backend}, nil
+}
+
+func (s *Store) livePath(jobRunnerID uint) string {
+	return filepath.Join(s.liveDir, fmt.Sprintf("%d.log", jobRunnerID))
+}
+
+func (s *Store) cachePath(jobRunnerID uint) string {
+	return filepath.Join(s.cacheDir, fmt.Sprintf("%d.log", jobRunnerID))
+}
+
+func objectKey(jobRunnerID uint) string {
+	return fmt.Sprintf("%d.log.gz", jobRunnerID)
+}
+
+// Append 追加日志。日志已完成时先恢复到 live 目录，下次完成时重新压缩
+func (s *Store) Append(jobRunnerID uint, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := s.livePath(jobRunnerID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := s.reopen(jobRunnerID); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	return err
+}
+
+func (s *Store) reopen(jobRunnerID uint) error {
+	os.Remove(s.cachePath(jobRunnerID))
+	reader, err := s.openFinished(jobRunnerID)
+	if err == ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return writeFile(s.livePath(jobRunnerID), reader)
+}
+
+// Open 读取完整的日志
+func (s *Store) Open(jobRunnerID uint) (io.ReadCloser, error) {
+	file, err := os.Open(s.livePath(jobRunnerID))
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.openFinished(jobRunnerID)
+}
+
+// Read 从未压缩日志的 offset 处读取最多 limit 字节，日志不存在时返回空
+func (s *Store) Read(jobRunnerID uint, offset int64, limit int) ([]byte, error) {
+	buf := make([]byte, limit)
+	if file, err := os.Open(s.livePath(jobRunnerID)); err == nil {
+		defer file.Close()
+		n, err := file.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return buf[:n], nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := s.openCached(jobRunnerID)
+	if err == ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// openCached 打开已完成日志解压后的本地缓存，缓存不存在时从 Backend 下载解压
+func (s *Store) openCached(jobRunnerID uint) (*os.File, error) {
+	path := s.cachePath(jobRunnerID)
+	if file, err := os.Open(path); err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return file, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.evictCache()
+	reader, err := s.openFinished(jobRunnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// 同时读取同一日志时各自写入临时文件再改名，不会读到不完整的缓存
+	if err := writeFile(path, reader); err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// evictCache 删除超过 cacheTTL 没有读取的缓存
+func (s *Store) evictCache() {
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > cacheTTL {
+			os.Remove(filepath.Join(s.cacheDir, entry.Name()))
+		}
+	}
+}
+
+func (s *Store) openFinished(jobRunnerID uint) (io.ReadCloser, error) {
+	object, err := s.backend.Get(objectKey(jobRunnerID))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(object)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: reader, object: object}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	object io.ReadCloser
+}
+
+func (r *gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.object.Close()
+}
+
+// Finalize 压缩已完成的日志写入 Backend，并删除 live 目录中的日志
+func (s *Store) Finalize(jobRunnerID uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := s.livePath(jobRunnerID)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	tmp, err := os.CreateTemp(s.liveDir, "finalize-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	gw := gzip.NewWriter(tmp)
+	if _, err := io.Copy(gw, file); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.backend.Put(objectKey(jobRunnerID), tmp, size); err != nil {
+		return err
+	}
+	os.Remove(s.cachePath(jobRunnerID))
+	return os.Remove(path)
+}
+
+// Delete 删除日志，返回释放的字节数
+func (s *Store) Delete(jobRunnerID uint) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	os.Remove(s.cachePath(jobRunnerID))
+	var size int64
+	path := s.livePath(jobRunnerID)
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+		size = info.Size()
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	n, err := s.backend.Delete(objectKey(jobRunnerID))
+	return size + n, err
+}
+
+// Live 列出尚未完成的日志
+func (s *Store) Live() ([]LiveLog, error) {
+	entries, err := os.ReadDir(s.liveDir)
+	if err != nil {
+		return nil, err
+	}
+	var logs []LiveLog
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), ".log"), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		logs = append(logs, LiveLog{JobRunnerID: uint(id), ModTime: info.ModTime()})
+	}
+	return logs, nil
+}
+
+// writeFile 先写临时文件再改名，避免留下不完整的文件
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
 	"strings"
 
 	"cicd-server/dal"
 	"cicd-server/handler"
 	jobexec "cicd-server/job_exec"
+	"cicd-server/logstore"
+	"cicd-server/utils"
 
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
@@ -14,11 +19,22 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rotate-secret-key" {
+		rotateSecretKey()
+		return
+	}
+
 	dal.Init()
+	logstore.Init()
 	go jobexec.Run()
 	go jobexec.StartEventProcess()
+	go jobexec.CleanArtifacts()
+	go jobexec.FinalizeLogs()
+	go jobexec.CleanJobs()
 
-	h := server.Default(server.WithHostPorts(":8029"))
+	// 缓存、构建产物和 runner 版本通过流式请求体上传，其余接口由 LimitBody 限制请求体大小
+	h := server.Default(server.WithHostPorts(":8029"), server.WithStreamBody(true), server.WithDisablePreParseMultipartForm(true))
+	h.Use(handler.LimitBody)
 
 	h.LoadHTMLGlob("./web/views/*")
 
@@ -35,8 +51,19 @@ func main() {
 
 	h.POST("/api/register_runner", handler.RegisterRunner)
 
-	h.POST("/api/events/:job_runner_id", handler.Events)
-	h.POST("/api/logs/:job_runner_id", handler.Log)
+	runnerApi := h.Group("/api", handler.RunnerAuth)
+	runnerApi.POST("/events/:job_runner_id", handler.Events)
+	runnerApi.POST("/logs/:job_runner_id", handler.Log)
+	runnerApi.POST("/log_batches/:job_runner_id", handler.LogBatch)
+	runnerApi.POST("/runner_status", handler.RunnerStatus)
+	runnerApi.POST("/runner_metrics", handler.RunnerMetrics)
+	runnerApi.GET("/runner_release/:id/download", handler.DownloadRunnerRelease)
+	runnerApi.GET("/cache/download", handler.DownloadCache)
+	runnerApi.POST("/cache/upload", handler.UploadCache)
+	runnerApi.POST("/artifacts/:job_runner_id/upload", handler.UploadArtifact)
+	runnerApi.GET("/artifacts/:job_runner_id/list", handler.RunnerArtifacts)
+	runnerApi.GET("/artifacts/:job_runner_id/download/:id", handler.RunnerDownloadArtifact)
+	runnerApi.POST("/test_reports/:job_runner_id", handler.TestReport)
 
 	h.Use(mws()...)
 	h.GET("/api/userinfo", handler.UserInfo)
@@ -50,18 +77,41 @@ func main() {
 	h.DELETE("/api/delete_role/:id", handler.DeleteRole)
 	h.GET("/api/list_role", handler.ListRole)
 
+	h.GET("/api/list_problem_matcher", handler.ListProblemMatcher)
+	h.POST("/api/create_problem_matcher", handler.CreateProblemMatcher)
+	h.PUT("/api/update_problem_matcher/:id", handler.UpdateProblemMatcher)
+	h.DELETE("/api/delete_problem_matcher/:id", handler.DeleteProblemMatcher)
+
 	h.GET("/api/list_runner", handler.ListRunner)
 	h.PUT("/api/enable_runner/:id", handler.EnableRunner)
 	h.PUT("/api/set_runner_busy/:id", handler.SetRunnerBusy)
+	h.PUT("/api/drain_runner/:id", handler.DrainRunner)
+	h.PUT("/api/reset_runner_secret/:id", handler.ResetRunnerSecret)
+	h.GET("/api/runner_metrics/:id", handler.RunnerMetricHistory)
 	h.DELETE("/api/delete_runner/:id", handler.DeleteRunner)
 	h.GET("/api/list_runner_label", handler.ListRunnerLabel)
+	h.POST("/api/create_runner_token", handler.CreateRunnerToken)
+	h.GET("/api/list_runner_token", handler.ListRunnerToken)
+	h.DELETE("/api/delete_runner_token/:id", handler.DeleteRunnerToken)
+	h.POST("/api/upload_runner_release", handler.UploadRunnerRelease)
+	h.GET("/api/list_runner_release", handler.ListRunnerRelease)
+	h.PUT("/api/upgrade_runner/:id", handler.UpgradeRunner)
 
 	h.POST("/api/start_job/:pipeline_id", handler.StartJob)
 	h.POST("/api/start_job_step/:job_runner_id", handler.StartJobStep)
 	h.GET("/api/pipeline_jobs/:pipeline_id", handler.PipelineJobs)
 	h.GET("/api/job_runner/:job_runner_id", handler.JobRunnerDetail)
 	h.GET("/api/job_runner_log/:job_runner_id", handler.JobRunnerLog)
+	h.GET("/api/job_runner_log/:job_runner_id/raw", handler.JobRunnerRawLog)
+	h.GET("/api/job_runner_log_stream/:job_runner_id", handler.JobRunnerLogStream)
+	h.GET("/api/job_log_archive/:job_id", handler.JobLogArchive)
 	h.POST("/api/cancel_job_runner/:job_runner_id", handler.CancelJobRunner)
+	h.GET("/api/job_artifacts/:job_id", handler.JobArtifacts)
+	h.GET("/api/download_artifact/:id", handler.DownloadArtifact)
+	h.GET("/api/flaky_tests/:pipeline_id", handler.FlakyTests)
+	h.GET("/api/test_history/:pipeline_id", handler.TestHistory)
+	h.GET("/api/retention_report", handler.RetentionReport)
+	h.GET("/api/search_log", handler.SearchLog)
 
 	h.GET("/api/list_step", handler.ListStep)
 	h.GET("/api/step/:id", handler.StepDetail)
@@ -90,6 +140,53 @@ func main() {
 	h.Spin()
 }
 
+// rotateSecretKey 使用新的主密钥重新加密 git 密码和 secret 变量，需要先停止 server。
+// 新密钥取自 CICD_NEW_SECRET_KEY，未设置时随机生成；主密钥保存在文件时直接替换文件，
+// 否则输出新密钥，由管理员更新 CICD_SECRET_KEY
+func rotateSecretKey() {
+	dal.Init()
+
+	oldKey, err := utils.CurrentSecretKey()
+	if err != nil {
+		log.Fatalf("get secret key error: %s", err)
+	}
+	newKey, err := utils.GenerateSecretKey()
+	if encoded := os.Getenv("CICD_NEW_SECRET_KEY"); encoded != "" {
+		newKey, err = utils.ParseSecretKey(encoded)
+	}
+	if err != nil {
+		log.Fatalf("new secret key error: %s", err)
+	}
+
+	// 先保存新密钥再提交，避免数据已重新加密但新密钥丢失
+	file, err := utils.SecretKeyFile()
+	if err != nil {
+		log.Fatalf("get secret key file error: %s", err)
+	}
+	fromFile := os.Getenv("CICD_SECRET_KEY") == ""
+	if fromFile {
+		if err := utils.WriteSecretKey(file+".new", newKey); err != nil {
+			log.Fatalf("write new secret key error: %s", err)
+		}
+	}
+	if err := dal.RotateSecretKey(newKey); err != nil {
+		log.Fatalf("rotate secret key error: %s", err)
+	}
+
+	if fromFile {
+		// 备份旧密钥后直接覆盖，任何时刻密钥文件都存在
+		if err := utils.WriteSecretKey(file+".old", oldKey); err != nil {
+			log.Fatalf("backup secret key error: %s", err)
+		}
+		if err := os.Rename(file+".new", file); err != nil {
+			log.Fatalf("replace secret key error: %s", err)
+		}
+		fmt.Printf("secret key rotated, new key saved to %s, old key backed up to %s.old\n", file, file)
+		return
+	}
+	fmt.Printf("secret key rotated, set CICD_SECRET_KEY to the new key: %s\n", utils.EncodeSecretKey(newKey))
+}
+
 func getPathRewriter(prefix string) app.PathRewriteFunc {
 	// Cannot have an empty prefix
 	if prefix == "" {
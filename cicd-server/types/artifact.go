@@ -0,0 +1,33 @@
+package types
+
+type UploadArtifactReq struct {
+	JobRunnerID uint   `path:"job_runner_id" vd:"$>0"`
+	Name        string `query:"name" vd:"len($)>0"`
+	Checksum    string `query:"checksum"`
+}
+
+type PathArtifactReq struct {
+	ID uint `path:"id" vd:"$>0"`
+}
+
+type RunnerArtifactReq struct {
+	JobRunnerID uint `path:"job_runner_id" vd:"$>0"`
+	ID          uint `path:"id"`
+}
+
+type JobArtifactReq struct {
+	JobID uint `path:"job_id" vd:"$>0"`
+}
+
+type ArtifactResp struct {
+	ID          uint   `json:"id"`
+	JobID       uint   `json:"job_id"`
+	JobRunnerID uint   `json:"job_runner_id"`
+	StepName    string `json:"step_name"`
+	RunnerName  string `json:"runner_name"`
+	Name        string `json:"name"`
+	Checksum    string `json:"checksum"`
+	Size        int64  `json:"size"`
+	CreatedAt   string `json:"created_at"`
+	ExpireAt    string `json:"expire_at"`
+}
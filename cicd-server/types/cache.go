@@ -0,0 +1,8 @@
+package types
+
+type CacheReq struct {
+	// JobRunnerID 缓存按该步骤所属的流水线隔离
+	JobRunnerID uint   `query:"job_runner_id" vd:"$>0"`
+	Key         string `query:"key" vd:"len($)>0"`
+	Prefix      string `query:"prefix"`
+}
@@ -10,3 +10,79 @@ type Log struct {
 	JobRunnerID uint   `path:"job_runner_id" vd:"$>0"`
 	Log         string `json:"log"`
 }
+
+type LogStreamReq struct {
+	JobRunnerID uint  `path:"job_runner_id" vd:"$>0"`
+	Offset      int64 `query:"offset" vd:"$>=0"`
+	// Format 为 text（默认）时返回纯文本日志，为 json 时返回结构化的日志记录
+	Format string `query:"format" vd:"$=='' || $=='text' || $=='json'"`
+}
+
+// LogChunkResp 不支持 SSE 时按 offset 轮询的日志片段
+type LogChunkResp struct {
+	Log      string      `json:"log,omitempty"`
+	Records  []LogRecord `json:"records,omitempty"`
+	Offset   int64       `json:"offset"`
+	Status   string      `json:"status"`
+	Finished bool        `json:"finished"`
+}
+
+// RawLogReq 下载纯文本日志，Tail 大于 0 时只返回最后 Tail 行
+type RawLogReq struct {
+	JobRunnerID uint `path:"job_runner_id" vd:"$>0"`
+	Tail        int  `query:"tail" vd:"$>=0"`
+	// StripANSI 去掉颜色、光标移动等终端控制序列
+	StripANSI bool `query:"strip_ansi"`
+}
+
+// JobLogArchiveReq 打包下载一次任务中所有步骤的纯文本日志
+type JobLogArchiveReq struct {
+	JobID     uint `path:"job_id" vd:"$>0"`
+	StripANSI bool `query:"strip_ansi"`
+}
+
+const (
+	LogStdout = "stdout"
+	LogStderr = "stderr"
+	// LogSystem runner 自身输出的日志，例如执行的命令、环境变量和错误信息
+	LogSystem = "system"
+
+	// LogSectionStart 和 LogSectionEnd 标记一条命令的开始和结束
+	LogSectionStart = "section_start"
+	LogSectionEnd   = "section_end"
+)
+
+// LogRecord 结构化的日志记录。旧版本 runner 上报的文本和迁移前的日志没有时间，
+// Text 为包含时间和 runner 名称的完整行
+type LogRecord struct {
+	Seq    uint64 `json:"seq"`
+	Runner string `json:"runner,omitempty"`
+	// Time 记录时间，unix 毫秒
+	Time int64 `json:"time"`
+	// Elapsed 距步骤开始的毫秒数，使用 runner 的单调时钟
+	Elapsed int64  `json:"elapsed"`
+	Stream  string `json:"stream"`
+	// Command 所属命令的下标，-1 表示不属于任何命令
+	Command int `json:"command"`
+	// Type 为空表示普通日志行，否则为命令段的开始或结束
+	Type string `json:"type,omitempty"`
+	// ExitCode 和 Duration 只在命令段结束时出现，Duration 为毫秒
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+	Text     string `json:"text"`
+}
+
+// LogBatch runner 批量上报的日志，Stream 标识一次执行，序号在 Stream 内从 1 开始连续递增
+type LogBatch struct {
+	JobRunnerID uint        `path:"job_runner_id" vd:"$>0"`
+	Stream      string      `json:"stream" vd:"len($)>0"`
+	Records     []LogRecord `json:"records"`
+	// Lines 早期版本 runner 上报的文本行，与 Records 同属一个协议版本，需要继续接收
+	Lines []LogLine `json:"lines"`
+}
+
+// LogLine 早期版本 runner 上报的带序号的日志行，Log 为包含时间和 runner 名称的完整行
+type LogLine struct {
+	Seq uint64 `json:"seq"`
+	Log string `json:"log"`
+}
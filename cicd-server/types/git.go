@@ -5,4 +5,8 @@ type TestGitReq struct {
 	Branch     string `json:"branch"`
 	Username   string `json:"username"`
 	Password   string `json:"password"`
+	// PipelineID 编辑流水线时密码留空，使用该流水线保存的密码
+	PipelineID uint `json:"pipeline_id"`
+	// ClearPassword 为 true 时不使用保存的密码
+	ClearPassword bool `json:"clear_password"`
 }
@@ -9,6 +9,7 @@ type PipelineJobReq struct {
 type StartJobReq struct {
 	PipelineID uint `path:"pipeline_id" vd:"$>0"`
 	Envs       Envs `json:"envs"`
+	Priority   *int `json:"priority"`
 }
 
 type StartStepReq struct {
@@ -23,6 +24,7 @@ type JobResp struct {
 	JobRunners []JobRunner `json:"job_runners"`
 	Branch     string      `json:"branch"`
 	CommitID   string      `json:"commit_id"`
+	Priority   int         `json:"priority"`
 }
 
 type JobRunner struct {
@@ -50,4 +52,8 @@ type JobRunnerResp struct {
 	JobRunners []JobRunner  `json:"job_runners"`
 	Job        JobResp      `json:"job"`
 	JobRunner  JobRunner    `json:"job_runner"`
+	// Tests 当前步骤的测试结果，没有测试报告时为空
+	Tests *TestSummary `json:"tests,omitempty"`
+	// Annotations 问题匹配规则从当前步骤日志中提取的错误和警告
+	Annotations []AnnotationResp `json:"annotations"`
 }
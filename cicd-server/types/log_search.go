@@ -0,0 +1,38 @@
+package types
+
+type LogSearchReq struct {
+	Query      string `query:"q"`
+	PipelineID uint   `query:"pipeline_id"`
+	StepID     uint   `query:"step_id"`
+	Status     string `query:"status"`
+	// 步骤开始时间范围，unix 毫秒
+	Start    int64 `query:"start"`
+	End      int64 `query:"end"`
+	Page     int   `query:"page" vd:"$>0"`
+	PageSize int   `query:"page_size" vd:"$>0&&$<=100"`
+}
+
+// LogSearchResp 一个步骤中匹配的日志
+type LogSearchResp struct {
+	JobRunnerID  uint             `json:"job_runner_id"`
+	JobID        uint             `json:"job_id"`
+	Tag          string           `json:"tag"`
+	PipelineID   uint             `json:"pipeline_id"`
+	PipelineName string           `json:"pipeline_name"`
+	StepID       uint             `json:"step_id"`
+	StepName     string           `json:"step_name"`
+	Status       string           `json:"status"`
+	StartTime    string           `json:"start_time"`
+	MatchCount   int              `json:"match_count"`
+	Matches      []LogSearchMatch `json:"matches"`
+}
+
+// LogSearchMatch 匹配的日志行及其前后的上下文
+type LogSearchMatch struct {
+	Runner string   `json:"runner"`
+	Stream string   `json:"stream"`
+	Time   int64    `json:"time"`
+	Text   string   `json:"text"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
@@ -3,17 +3,26 @@ package types
 import "time"
 
 type CreatePipelineReq struct {
-	Name        string `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
-	GroupName   string `json:"group_name"`
-	TagTemplate string `json:"tag_template"`
-	Envs        Envs   `json:"envs"`
-	UseGit      bool   `json:"use_git"`
-	Repository  string `json:"repository"`
-	Branch      string `json:"branch"`
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	Sort        int    `json:"sort"`
-	Roles       []uint `json:"roles"`
+	Name        string   `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
+	GroupName   string   `json:"group_name"`
+	TagTemplate string   `json:"tag_template"`
+	Envs        Envs     `json:"envs"`
+	UseGit      bool     `json:"use_git"`
+	Repository  string   `json:"repository"`
+	Branch      string   `json:"branch"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	Depth       int      `json:"depth" vd:"$>=0"`
+	Submodules  bool     `json:"submodules"`
+	LFS         bool     `json:"lfs"`
+	SparsePaths []string `json:"sparse_paths"`
+	Clean       bool     `json:"clean"`
+	Sort        int      `json:"sort"`
+	Roles       []uint   `json:"roles"`
+	Priority    int      `json:"priority"`
+	// 任务历史保留规则，为 0 时使用全局配置
+	RetentionCount int `json:"retention_count" vd:"$>=0"`
+	RetentionDays  int `json:"retention_days" vd:"$>=0"`
 }
 
 type Envs []Env
@@ -21,21 +30,37 @@ type Envs []Env
 type Env struct {
 	Key string `json:"key"`
 	Val string `json:"val"`
+	// Secret 为 true 时不在日志中打印，日志中出现的值会被替换为 ***。
+	// secret 变量加密保存，接口不返回值，提交时留空表示不修改
+	Secret bool `json:"secret"`
+	// Set secret 变量是否已设置值，只在返回中使用
+	Set bool `json:"set,omitempty"`
 }
 
 type UpdatePipelineReq struct {
-	ID          uint   `path:"id" vd:"$>0"`
-	Name        string `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
-	GroupName   string `json:"group_name"`
-	TagTemplate string `json:"tag_template"`
-	Envs        Envs   `json:"envs"`
-	UseGit      bool   `json:"use_git"`
-	Repository  string `json:"repository"`
-	Branch      string `json:"branch"`
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	Sort        int    `json:"sort"`
-	Roles       []uint `json:"roles"`
+	ID          uint     `path:"id" vd:"$>0"`
+	Name        string   `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
+	GroupName   string   `json:"group_name"`
+	TagTemplate string   `json:"tag_template"`
+	Envs        Envs     `json:"envs"`
+	UseGit      bool     `json:"use_git"`
+	Repository  string   `json:"repository"`
+	Branch      string   `json:"branch"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"` // 留空且仓库地址不变时沿用已保存的密码
+	Depth       int      `json:"depth" vd:"$>=0"`
+	Submodules  bool     `json:"submodules"`
+	LFS         bool     `json:"lfs"`
+	SparsePaths []string `json:"sparse_paths"`
+	Clean       bool     `json:"clean"`
+	Sort        int      `json:"sort"`
+	Roles       []uint   `json:"roles"`
+	Priority    int      `json:"priority"`
+	// 任务历史保留规则，为 0 时使用全局配置
+	RetentionCount int `json:"retention_count" vd:"$>=0"`
+	RetentionDays  int `json:"retention_days" vd:"$>=0"`
+	// ClearPassword 清除已保存的 git 密码
+	ClearPassword bool `json:"clear_password"`
 }
 
 type PathPipelineReq struct {
@@ -55,11 +80,19 @@ type PipelineResp struct {
 	Repository     string         `json:"repository"`
 	Branch         string         `json:"branch"`
 	Username       string         `json:"username"`
-	Password       string         `json:"password"`
+	PasswordSet    bool           `json:"password_set"`
+	Depth          int            `json:"depth"`
+	Submodules     bool           `json:"submodules"`
+	LFS            bool           `json:"lfs"`
+	SparsePaths    []string       `json:"sparse_paths"`
+	Clean          bool           `json:"clean"`
 	GroupName      string         `json:"group_name"`
 	Sort           int            `json:"sort"`
 	Roles          []uint         `json:"roles"`
 	StagesAndSteps []StageAndStep `json:"stages_and_steps"`
+	Priority       int            `json:"priority"`
+	RetentionCount int            `json:"retention_count"`
+	RetentionDays  int            `json:"retention_days"`
 }
 
 type StageAndStep struct {
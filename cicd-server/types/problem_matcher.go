@@ -0,0 +1,43 @@
+package types
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNotice  = "notice"
+)
+
+// ProblemMatcherRule 把匹配的日志行转换为注解，pattern 可以使用命名分组
+// file、line、column、severity、code、message
+type ProblemMatcherRule struct {
+	Name     string `json:"name" vd:"len($)>0"`
+	Pattern  string `json:"pattern" vd:"len($)>0"`
+	Severity string `json:"severity" vd:"in($, '', 'error', 'warning', 'notice')"`
+}
+
+type ProblemMatcherResp struct {
+	ID uint `json:"id"`
+	ProblemMatcherRule
+}
+
+type UpdateProblemMatcherReq struct {
+	ID uint `path:"id" vd:"$>0"`
+	ProblemMatcherRule
+}
+
+type ProblemMatcherPathReq struct {
+	ID uint `path:"id" vd:"$>0"`
+}
+
+// AnnotationResp 从步骤日志中提取的一条错误或警告，runner 和 seq 对应日志记录
+type AnnotationResp struct {
+	ID       uint   `json:"id"`
+	Matcher  string `json:"matcher"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+	Runner   string `json:"runner"`
+	Seq      uint64 `json:"seq"`
+}
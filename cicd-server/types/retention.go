@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+// RetentionReport 按保留规则清理任务历史的结果
+type RetentionReport struct {
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Jobs          int       `json:"jobs"`
+	JobRunners    int       `json:"job_runners"`
+	Logs          int       `json:"logs"`
+	LogBytes      int64     `json:"log_bytes"`
+	Artifacts     int       `json:"artifacts"`
+	ArtifactBytes int64     `json:"artifact_bytes"`
+}
@@ -5,18 +5,31 @@ type RegisterRunnerReq struct {
 	Endpoint string   `json:"endpoint"`
 	Labels   []string `json:"labels"`
 	IP       string   `json:"ip"`
+	Token    string   `json:"token" vd:"len($)>0"`
+	Version  string   `json:"version"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features"`
 }
 
 type RunnerResp struct {
-	ID           uint     `json:"id"`
-	Name         string   `json:"name"`
-	Status       string   `json:"status"`
-	PipelineID   uint     `json:"pipeline_id"`
-	PipelineName string   `json:"pipeline_name"`
-	Enable       bool     `json:"enable"`
-	Labels       []string `json:"labels"`
-	CreatedAt    string   `json:"created_at"`
-	IP           string   `json:"ip"`
+	ID           uint              `json:"id"`
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	PipelineID   uint              `json:"pipeline_id"`
+	PipelineName string            `json:"pipeline_name"`
+	Enable       bool              `json:"enable"`
+	Labels       []string          `json:"labels"`
+	CreatedAt    string            `json:"created_at"`
+	IP           string            `json:"ip"`
+	Message      string            `json:"message"`
+	Version      string            `json:"version"`
+	OS           string            `json:"os"`
+	Arch         string            `json:"arch"`
+	Protocol     int               `json:"protocol"`
+	Features     []string          `json:"features"`
+	Metrics      *RunnerMetricResp `json:"metrics"`
 }
 
 type ListRunnerReq struct {
@@ -28,3 +41,72 @@ type ListRunnerReq struct {
 type PathRunnerReq struct {
 	ID uint `path:"id" vd:"$>0"`
 }
+
+type RegisterRunnerResp struct {
+	Data   string `json:"data"`
+	Secret string `json:"secret"`
+}
+
+type CreateRunnerTokenReq struct {
+	Remark string `json:"remark"`
+}
+
+type RunnerTokenResp struct {
+	ID        uint   `json:"id"`
+	Token     string `json:"token"`
+	Remark    string `json:"remark"`
+	CreatedAt string `json:"created_at"`
+}
+
+type RunnerStatusReq struct {
+	Status string `json:"status" vd:"in($, 'online', 'draining', 'offline')"`
+}
+
+type UploadRunnerReleaseReq struct {
+	Version string `form:"version" vd:"len($)>0"`
+	OS      string `form:"os" vd:"len($)>0"`
+	Arch    string `form:"arch" vd:"len($)>0"`
+}
+
+type RunnerReleaseResp struct {
+	ID        uint   `json:"id"`
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Checksum  string `json:"checksum"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"created_at"`
+}
+
+type PathRunnerReleaseReq struct {
+	ID uint `path:"id" vd:"$>0"`
+}
+
+type RunnerUpgrade struct {
+	ReleaseID uint   `json:"release_id" vd:"$>0"`
+	Version   string `json:"version"`
+	Checksum  string `json:"checksum"`
+}
+
+type RunnerMetricReq struct {
+	CPUs         int     `json:"cpus"`
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemTotal     uint64  `json:"mem_total"`
+	MemAvailable uint64  `json:"mem_available"`
+	DiskFree     uint64  `json:"disk_free"`
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
+}
+
+type RunnerMetricResp struct {
+	CPUs         int     `json:"cpus"`
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemTotal     uint64  `json:"mem_total"`
+	MemAvailable uint64  `json:"mem_available"`
+	DiskFree     uint64  `json:"disk_free"`
+	Load1        float64 `json:"load1"`
+	Load5        float64 `json:"load5"`
+	Load15       float64 `json:"load15"`
+	CreatedAt    string  `json:"created_at"`
+}
@@ -3,24 +3,48 @@ package types
 import "time"
 
 type CreateStepReq struct {
-	PipelineID         uint     `json:"pipeline_id" vd:"$>0"`
-	StageID            uint     `json:"stage_id"`
-	Name               string   `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
-	Commands           []string `json:"commands"`
-	Trigger            string   `json:"trigger"`
-	RunnerLabelMatch   string   `json:"runner_label_match"`
-	MultipleRunnerExec bool     `json:"multiple_runner_exec"`
+	PipelineID         uint                 `json:"pipeline_id" vd:"$>0"`
+	StageID            uint                 `json:"stage_id"`
+	Name               string               `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
+	Commands           []string             `json:"commands"`
+	Trigger            string               `json:"trigger"`
+	RunnerLabelMatch   string               `json:"runner_label_match"`
+	MultipleRunnerExec bool                 `json:"multiple_runner_exec"`
+	MinDiskFree        int64                `json:"min_disk_free"`
+	Image              string               `json:"image"`
+	Mode               string               `json:"mode" vd:"in($, '', 'command', 'script')"`
+	Interpreter        string               `json:"interpreter"`
+	CacheKey           string               `json:"cache_key"`
+	CachePaths         []string             `json:"cache_paths"`
+	Artifacts          []string             `json:"artifacts"`
+	ArtifactDownloads  []string             `json:"artifact_downloads"`
+	AllowFailure       bool                 `json:"allow_failure"`
+	CommandOptions     []CommandOption      `json:"command_options"`
+	TestReports        []string             `json:"test_reports"`
+	ProblemMatchers    []ProblemMatcherRule `json:"problem_matchers"`
 }
 
 type UpdateStepReq struct {
-	ID                 uint     `path:"id" vd:"$>0"`
-	PipelineID         uint     `json:"pipeline_id" vd:"$>0"`
-	StageID            uint     `json:"stage_id"`
-	Name               string   `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
-	Commands           []string `json:"commands"`
-	Trigger            string   `json:"trigger"`
-	RunnerLabelMatch   string   `json:"runner_label_match"`
-	MultipleRunnerExec bool     `json:"multiple_runner_exec"`
+	ID                 uint                 `path:"id" vd:"$>0"`
+	PipelineID         uint                 `json:"pipeline_id" vd:"$>0"`
+	StageID            uint                 `json:"stage_id"`
+	Name               string               `json:"name" vd:"regexp('^[a-zA-Z0-9_-]+$')"`
+	Commands           []string             `json:"commands"`
+	Trigger            string               `json:"trigger"`
+	RunnerLabelMatch   string               `json:"runner_label_match"`
+	MultipleRunnerExec bool                 `json:"multiple_runner_exec"`
+	MinDiskFree        int64                `json:"min_disk_free"`
+	Image              string               `json:"image"`
+	Mode               string               `json:"mode" vd:"in($, '', 'command', 'script')"`
+	Interpreter        string               `json:"interpreter"`
+	CacheKey           string               `json:"cache_key"`
+	CachePaths         []string             `json:"cache_paths"`
+	Artifacts          []string             `json:"artifacts"`
+	ArtifactDownloads  []string             `json:"artifact_downloads"`
+	AllowFailure       bool                 `json:"allow_failure"`
+	CommandOptions     []CommandOption      `json:"command_options"`
+	TestReports        []string             `json:"test_reports"`
+	ProblemMatchers    []ProblemMatcherRule `json:"problem_matchers"`
 }
 
 type PathStepReq struct {
@@ -28,17 +52,35 @@ type PathStepReq struct {
 }
 
 type StepResp struct {
-	ID                 uint      `json:"id"`
-	PipelineID         uint      `json:"pipeline_id"`
-	StageID            uint      `json:"stage_id"`
-	LastRunnerID       uint      `json:"last_runner_id"`
-	Name               string    `json:"name"`
-	Commands           []string  `json:"commands"`
-	Trigger            string    `json:"trigger"`
-	RunnerLabelMatch   string    `json:"runner_label_match"`
-	LastStatus         string    `json:"last_status"`
-	MultipleRunnerExec bool      `json:"multiple_runner_exec"`
-	Sort               int       `json:"sort"`
-	CreatedAt          time.Time `json:"created_at"`
-	Parallel           bool      `json:"parallel"`
+	ID                 uint                 `json:"id"`
+	PipelineID         uint                 `json:"pipeline_id"`
+	StageID            uint                 `json:"stage_id"`
+	LastRunnerID       uint                 `json:"last_runner_id"`
+	Name               string               `json:"name"`
+	Commands           []string             `json:"commands"`
+	Trigger            string               `json:"trigger"`
+	RunnerLabelMatch   string               `json:"runner_label_match"`
+	LastStatus         string               `json:"last_status"`
+	MultipleRunnerExec bool                 `json:"multiple_runner_exec"`
+	Sort               int                  `json:"sort"`
+	CreatedAt          time.Time            `json:"created_at"`
+	Parallel           bool                 `json:"parallel"`
+	MinDiskFree        int64                `json:"min_disk_free"`
+	Image              string               `json:"image"`
+	Mode               string               `json:"mode"`
+	Interpreter        string               `json:"interpreter"`
+	CacheKey           string               `json:"cache_key"`
+	CachePaths         []string             `json:"cache_paths"`
+	Artifacts          []string             `json:"artifacts"`
+	ArtifactDownloads  []string             `json:"artifact_downloads"`
+	AllowFailure       bool                 `json:"allow_failure"`
+	CommandOptions     []CommandOption      `json:"command_options"`
+	TestReports        []string             `json:"test_reports"`
+	ProblemMatchers    []ProblemMatcherRule `json:"problem_matchers"`
+}
+
+// CommandOption 按下标对应 commands 中的命令
+type CommandOption struct {
+	ContinueOnError  bool  `json:"continue_on_error"`
+	SuccessExitCodes []int `json:"success_exit_codes"`
 }
@@ -0,0 +1,79 @@
+package types
+
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestSkipped = "skipped"
+)
+
+// TestReport runner 上报的一个测试报告文件
+type TestReport struct {
+	JobRunnerID uint       `path:"job_runner_id" vd:"$>0"`
+	File        string     `json:"file"`
+	Format      string     `json:"format"`
+	Cases       []TestCase `json:"cases"`
+}
+
+type TestCase struct {
+	Suite    string `json:"suite"`
+	Name     string `json:"name"`
+	Status   string `json:"status" vd:"in($, 'passed', 'failed', 'skipped')"`
+	Duration int64  `json:"duration"`
+	Message  string `json:"message"`
+}
+
+type TestCaseResp struct {
+	ID          uint   `json:"id"`
+	JobRunnerID uint   `json:"job_runner_id"`
+	Suite       string `json:"suite"`
+	Name        string `json:"name"`
+	Runner      string `json:"runner"`
+	File        string `json:"file"`
+	Status      string `json:"status"`
+	Duration    int64  `json:"duration"`
+	Message     string `json:"message,omitempty"`
+	Flaky       bool   `json:"flaky"`
+}
+
+// TestSummary 步骤的测试结果，Cases 中失败的排在前面
+type TestSummary struct {
+	Total    int            `json:"total"`
+	Passed   int            `json:"passed"`
+	Failed   int            `json:"failed"`
+	Skipped  int            `json:"skipped"`
+	Flaky    int            `json:"flaky"`
+	Duration int64          `json:"duration"`
+	Cases    []TestCaseResp `json:"cases"`
+}
+
+type TestStatResp struct {
+	Suite           string `json:"suite"`
+	Name            string `json:"name"`
+	Runs            int    `json:"runs"`
+	Failures        int    `json:"failures"`
+	History         string `json:"history"`
+	LastStatus      string `json:"last_status"`
+	LastJobRunnerID uint   `json:"last_job_runner_id"`
+	Flaky           bool   `json:"flaky"`
+}
+
+type PipelineTestReq struct {
+	PipelineID uint `path:"pipeline_id" vd:"$>0"`
+}
+
+type TestHistoryReq struct {
+	PipelineID uint   `path:"pipeline_id" vd:"$>0"`
+	Suite      string `query:"suite"`
+	Name       string `query:"name" vd:"len($)>0"`
+}
+
+// TestHistoryResp 测试在一次任务中的结果
+type TestHistoryResp struct {
+	JobRunnerID uint   `json:"job_runner_id"`
+	JobID       uint   `json:"job_id"`
+	Tag         string `json:"tag"`
+	Status      string `json:"status"`
+	Duration    int64  `json:"duration"`
+	Message     string `json:"message,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestMasker(t *testing.T) {
+	secret := "p@ss word/+?"
+	masker := NewMasker(secret, "abc")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"raw", "password: " + secret, "password: ***"},
+		{"repeated", secret + secret, "******"},
+		{"base64", "auth " + base64.StdEncoding.EncodeToString([]byte(secret)), "auth ***"},
+		{"raw base64", base64.RawStdEncoding.EncodeToString([]byte(secret)), "***"},
+		{"base64 url", base64.URLEncoding.EncodeToString([]byte(secret)), "***"},
+		{"raw base64 url", base64.RawURLEncoding.EncodeToString([]byte(secret)), "***"},
+		{"query escape", "?token=" + url.QueryEscape(secret), "?token=***"},
+		{"path escape", "/" + url.PathEscape(secret) + "/", "/***/"},
+		{"short secret", "abc def", "abc def"},
+		{"no secret", "hello world", "hello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := masker.Mask(tt.in); got != tt.want {
+				t.Errorf("Mask(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskerEmpty(t *testing.T) {
+	for _, masker := range []*Masker{nil, NewMasker(), NewMasker("", "ab")} {
+		if got := masker.Mask("hello"); got != "hello" {
+			t.Errorf("Mask = %q", got)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// 加密后的值的前缀，格式为 enc:v1:<主密钥 id>:<base64(nonce+密文)>
+	secretPrefix = "enc:v1:"
+	secretKeyLen = 32
+)
+
+var secretKey []byte
+
+// SecretKeyFile 没有设置 CICD_SECRET_KEY 时主密钥的保存位置
+func SecretKeyFile() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".cicd-server", "secret.key"), nil
+}
+
+// InitSecretKey 加载加密变量和 git 凭据使用的主密钥：优先使用 CICD_SECRET_KEY（base64 编码的 32 字节），
+// 否则读取 SecretKeyFile，文件不存在时生成新的密钥
+func InitSecretKey() error {
+	if encoded := os.Getenv("CICD_SECRET_KEY"); encoded != "" {
+		key, err := ParseSecretKey(encoded)
+		if err != nil {
+			return fmt.Errorf("CICD_SECRET_KEY: %w", err)
+		}
+		SetSecretKey(key)
+		return nil
+	}
+
+	file, err := SecretKeyFile()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := GenerateSecretKey()
+		if err != nil {
+			return err
+		}
+		if err := WriteSecretKey(file, key); err != nil {
+			return err
+		}
+		SetSecretKey(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key, err := ParseSecretKey(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	SetSecretKey(key)
+	return nil
+}
+
+func GenerateSecretKey() ([]byte, error) {
+	key := make([]byte, secretKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ParseSecretKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != secretKeyLen {
+		return nil, fmt.Errorf("secret key must be %d bytes", secretKeyLen)
+	}
+	return key, nil
+}
+
+func EncodeSecretKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// WriteSecretKey 先写临时文件再重命名，避免中途失败留下不完整的密钥
+func WriteSecretKey(file string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(EncodeSecretKey(key)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func SetSecretKey(key []byte) {
+	secretKey = key
+}
+
+// CurrentSecretKey 返回已加载的主密钥
+func CurrentSecretKey() ([]byte, error) {
+	if len(secretKey) == 0 {
+		return nil, errors.New("secret key not initialized")
+	}
+	return secretKey, nil
+}
+
+// keyID 密文中记录的主密钥标识，用于发现使用了错误的密钥
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// IsEncrypted 值是否已经加密，迁移前写入的明文返回 false
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// EncryptSecret 使用主密钥加密，空值和已经加密的值原样返回
+func EncryptSecret(plain string) (string, error) {
+	return EncryptSecretWith(secretKey, plain)
+}
+
+// DecryptSecret 使用主密钥解密，没有加密的值原样返回
+func DecryptSecret(value string) (string, error) {
+	return DecryptSecretWith(secretKey, value)
+}
+
+func EncryptSecretWith(key []byte, plain string) (string, error) {
+	if plain == "" || IsEncrypted(plain) {
+		return plain, nil
+	}
+	sealed, err := Seal(key, []byte(plain))
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + keyID(key) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptSecretWith(key []byte, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, secretPrefix), ":")
+	if !ok {
+		return "", errors.New("invalid encrypted secret")
+	}
+	if id != keyID(key) {
+		return "", fmt.Errorf("secret encrypted with another key %s", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	plain, err := Open(key, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Seal 使用 AES-256-GCM 加密，返回 nonce+密文
+func Seal(key, plain []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("secret key not initialized")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func Open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// TransportKey 下发任务时加密请求体使用的密钥，由 runner 注册时下发的密钥派生
+func TransportKey(runnerSecret string) []byte {
+	sum := sha256.Sum256([]byte("cicd-job:" + runnerSecret))
+	return sum[:]
+}
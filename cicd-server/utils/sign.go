@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderRunner    = "X-Cicd-Runner"
+	HeaderTimestamp = "X-Cicd-Timestamp"
+	HeaderSignature = "X-Cicd-Signature"
+	// HeaderEncryption 请求体使用 TransportKey 加密
+	HeaderEncryption = "X-Cicd-Encryption"
+	// HeaderContentSha256 流式上传时签名的是请求体的 sha256，接收方边读边校验
+	HeaderContentSha256 = "X-Cicd-Content-Sha256"
+
+	// 签名有效期，超出视为重放
+	signatureTTL = 5 * time.Minute
+)
+
+func RandomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign 对 method、path（含查询参数）、timestamp 和 body 计算 HMAC-SHA256 签名
+func Sign(secret, method, path, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest 为 runner 与 server 之间的请求添加签名头
+func SignRequest(req *http.Request, runnerName, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderRunner, runnerName)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(secret, req.Method, SignedPath(req.URL.Path, req.URL.RawQuery), timestamp, body))
+}
+
+// SignedPath 参与签名的路径，带上原始查询参数，避免重放请求时修改参数
+func SignedPath(path, rawQuery string) string {
+	if rawQuery == "" {
+		return path
+	}
+	return path + "?" + rawQuery
+}
+
+func VerifySignature(secret, method, path, timestamp, signature string, body []byte) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > signatureTTL || d < -signatureTTL {
+		return errors.New("signature expired")
+	}
+	if !hmac.Equal([]byte(Sign(secret, method, path, timestamp, body)), []byte(signature)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+// 签名包含查询参数，修改参数后重放的请求无法通过校验
+func TestSignRequestQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://server/api/cache/download?job_runner_id=1&key=a", nil)
+	SignRequest(req, "runner", "secret", nil)
+	timestamp, signature := req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature)
+
+	if err := VerifySignature("secret", "GET", SignedPath("/api/cache/download", "job_runner_id=1&key=a"), timestamp, signature, nil); err != nil {
+		t.Errorf("verify error: %s", err)
+	}
+	if err := VerifySignature("secret", "GET", SignedPath("/api/cache/download", "job_runner_id=1&key=b"), timestamp, signature, nil); err == nil {
+		t.Error("modified query verified")
+	}
+	if SignedPath("/api/events/1", "") != "/api/events/1" {
+		t.Error("path without query changed")
+	}
+}
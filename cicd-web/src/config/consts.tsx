@@ -6,6 +6,7 @@ export const colors: any = {
     queueing: "#a0d8ef",
     partial_running: "#89c3eb",
     partial_success: "#98d98e",
+    allowed_failure: "#f8b862",
     canceled: "#afafb0",
   };
   
@@ -17,6 +18,7 @@ export const colors: any = {
     failed: "✗",
     partial_running: "...🚀",
     partial_success: "...✔️",
+    allowed_failure: "⚠️",
     canceled: "🚫",
   };
   
@@ -28,5 +30,6 @@ export const colors: any = {
     queueing: "队列中",
     partial_running: "部分运行中",
     partial_success: "部分成功",
+    allowed_failure: "失败(已容忍)",
     canceled: "取消",
   }
\ No newline at end of file
@@ -16,6 +16,10 @@ const App: React.FC = () => {
       key: "pipeline",
       label: "pipeline",
     },
+    {
+      key: "log_search",
+      label: "日志搜索",
+    },
     {
       key: "runner",
       label: "执行机器",
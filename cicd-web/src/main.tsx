@@ -8,6 +8,7 @@ import CreatePipeline from "./page/pipeline/create_pipeline.tsx";
 import CreateStep from "./page/pipeline/create_step.tsx";
 import History from "./page/pipeline/history.tsx";
 import Logs from "./page/pipeline/logs.tsx";
+import LogSearch from "./page/pipeline/log_search.tsx";
 import Runner from "./page/runner/index.tsx";
 import Layout from "./layout.tsx";
 import Login from "./page/login.tsx";
@@ -32,6 +33,7 @@ createRoot(document.getElementById("root")!).render(
           </Route>
           <Route path="/history" element={<History />} />
           <Route path="/logs" element={<Logs />} />
+          <Route path="/log_search" element={<LogSearch />} />
           <Route path="/runner" element={<Runner />} />
           <Route path="/user" element={<User />} />
           <Route path="/profile" element={<Profile />} />
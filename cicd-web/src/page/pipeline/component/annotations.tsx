@@ -0,0 +1,70 @@
+import { Collapse, Tag } from "antd";
+import { logLineId } from "./log_view";
+
+export const severityColors: Record<string, string> = {
+  error: "red",
+  warning: "orange",
+  notice: "blue",
+};
+
+// 跳转到注解对应的日志行，折叠的命令段先展开
+function scrollToLine(annotation: any) {
+  const el = document.getElementById(
+    logLineId(annotation.runner, annotation.seq)
+  );
+  if (!el) {
+    return;
+  }
+  el.closest("details")?.setAttribute("open", "");
+  el.scrollIntoView({ block: "center" });
+  el.classList.add("bg-yellow-900");
+  setTimeout(() => el.classList.remove("bg-yellow-900"), 2000);
+}
+
+function location(annotation: any) {
+  if (!annotation.file) {
+    return "";
+  }
+  return [annotation.file, annotation.line, annotation.column]
+    .filter((v) => v)
+    .join(":");
+}
+
+// 问题匹配规则从日志中提取的错误和警告
+export default function Annotations({ annotations }: { annotations: any[] }) {
+  const errors = annotations.filter((a) => a.severity === "error").length;
+
+  return (
+    <Collapse
+      className="mt-4"
+      size="small"
+      defaultActiveKey={errors > 0 ? ["annotations"] : []}
+      items={[
+        {
+          key: "annotations",
+          label: `注解 (${errors} 错误 / ${annotations.length})`,
+          children: (
+            <div className="max-h-[200px] overflow-y-auto">
+              {annotations.map((a) => (
+                <div
+                  key={a.id}
+                  className="cursor-pointer hover:bg-gray-100 py-[2px]"
+                  onClick={() => scrollToLine(a)}
+                >
+                  <Tag color={severityColors[a.severity]}>{a.severity}</Tag>
+                  {location(a) && (
+                    <span className="font-mono text-gray-500 mr-2">
+                      {location(a)}
+                    </span>
+                  )}
+                  <span className="font-mono">{a.message}</span>
+                  {a.code && <span className="text-gray-400 ml-2">{a.code}</span>}
+                </div>
+              ))}
+            </div>
+          ),
+        },
+      ]}
+    />
+  );
+}
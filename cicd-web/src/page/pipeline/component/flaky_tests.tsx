@@ -0,0 +1,86 @@
+import { useEffect, useState } from "react";
+import { Modal, Table, Tooltip } from "antd";
+import { fetchRequest } from "../../../utils/fetch";
+import { TestHistory } from "./test_report";
+
+const marks: Record<string, string> = {
+  P: "bg-green-500",
+  F: "bg-red-500",
+  S: "bg-gray-300",
+};
+
+// 流水线中通过和失败反复交替，或者同一 commit 结果不一致的测试
+export default function FlakyTests({
+  pipelineId,
+  open,
+  onClose,
+}: {
+  pipelineId: number;
+  open: boolean;
+  onClose: () => void;
+}) {
+  const [tests, setTests] = useState<any[]>([]);
+  const [selected, setSelected] = useState<{ suite: string; name: string }>();
+
+  useEffect(() => {
+    if (open) {
+      loadFlakyTests();
+    }
+  }, [open, pipelineId]);
+
+  const loadFlakyTests = async () => {
+    const res = await fetchRequest("/api/flaky_tests/" + pipelineId, {
+      method: "GET",
+    });
+    setTests(res || []);
+  };
+
+  return (
+    <Modal
+      title="不稳定的测试"
+      open={open}
+      onCancel={onClose}
+      footer={null}
+      width={900}
+    >
+      <Table
+        rowKey={(record) => record.suite + "\x00" + record.name}
+        size="small"
+        dataSource={tests}
+        columns={[
+          {
+            title: "测试",
+            dataIndex: "name",
+            render: (name, record) => (
+              <a onClick={() => setSelected(record)}>{name}</a>
+            ),
+          },
+          { title: "Suite", dataIndex: "suite" },
+          { title: "执行次数", dataIndex: "runs" },
+          { title: "失败次数", dataIndex: "failures" },
+          {
+            title: "最近结果",
+            dataIndex: "history",
+            render: (history: string) => (
+              <Tooltip title="从左到右由旧到新，绿色通过，红色失败，灰色跳过">
+                <span className="inline-flex gap-[2px]">
+                  {history.split("").map((mark, i) => (
+                    <span
+                      key={i}
+                      className={`inline-block w-[6px] h-[14px] ${marks[mark]}`}
+                    />
+                  ))}
+                </span>
+              </Tooltip>
+            ),
+          },
+        ]}
+      />
+      <TestHistory
+        pipelineId={pipelineId}
+        test={selected}
+        onClose={() => setSelected(undefined)}
+      />
+    </Modal>
+  );
+}
@@ -0,0 +1,127 @@
+export interface LogRecord {
+  seq: number;
+  runner?: string;
+  time: number;
+  elapsed: number;
+  stream: string;
+  command: number;
+  type?: string;
+  exit_code?: number;
+  duration?: number;
+  text: string;
+}
+
+// 日志行的元素 id，注解通过它定位到对应的行
+export function logLineId(runner: string | undefined, seq: number) {
+  return `log-${runner || ""}-${seq}`;
+}
+
+// 一条命令的输出，或者命令之外的连续日志（没有 start）
+interface Section {
+  runner?: string;
+  start?: LogRecord;
+  end?: LogRecord;
+  lines: LogRecord[];
+}
+
+function buildSections(records: LogRecord[]): Section[] {
+  const sections: Section[] = [];
+  // 多台 runner 执行同一步骤时日志交错，按 runner 分别跟踪当前命令
+  const open: Record<string, Section> = {};
+  for (const r of records) {
+    const key = r.runner || "";
+    if (r.type === "section_start") {
+      const section = { runner: r.runner, start: r, lines: [] };
+      sections.push(section);
+      open[key] = section;
+      continue;
+    }
+    const current = open[key];
+    if (r.type === "section_end") {
+      if (current && current.start?.command === r.command) {
+        current.end = r;
+        delete open[key];
+      }
+      continue;
+    }
+    if (current && r.command === current.start?.command) {
+      current.lines.push(r);
+      continue;
+    }
+    const last = sections[sections.length - 1];
+    if (last && !last.start && last.runner === r.runner) {
+      last.lines.push(r);
+    } else {
+      sections.push({ runner: r.runner, lines: [r] });
+    }
+  }
+  return sections;
+}
+
+function formatTime(ms: number) {
+  const d = new Date(ms);
+  return [d.getHours(), d.getMinutes(), d.getSeconds()]
+    .map((n) => String(n).padStart(2, "0"))
+    .join(":");
+}
+
+function formatDuration(ms: number) {
+  if (ms < 1000) {
+    return ms + "ms";
+  }
+  if (ms < 60000) {
+    return (ms / 1000).toFixed(1) + "s";
+  }
+  return Math.floor(ms / 60000) + "m" + Math.round((ms % 60000) / 1000) + "s";
+}
+
+function Line({ record, showRunner }: { record: LogRecord; showRunner: boolean }) {
+  return (
+    <div
+      id={record.seq > 0 ? logLineId(record.runner, record.seq) : undefined}
+      className={record.stream === "stderr" ? "text-red-300" : ""}
+    >
+      {/* 旧版本的日志没有时间，内容中已经包含时间和 runner */}
+      {record.time > 0 && record.text !== "" && (
+        <span className="text-gray-500 select-none">
+          {formatTime(record.time)} {showRunner && `[${record.runner}] `}
+        </span>
+      )}
+      {record.text}
+    </div>
+  );
+}
+
+export default function LogView({ records }: { records: LogRecord[] }) {
+  const showRunner = new Set(records.map((r) => r.runner)).size > 1;
+
+  return (
+    <pre style={{ whiteSpace: "pre-wrap" }}>
+      {buildSections(records).map((section, i) => {
+        const lines = section.lines.map((r) => (
+          <Line key={r.runner + "-" + r.seq} record={r} showRunner={showRunner} />
+        ));
+        if (!section.start) {
+          return <div key={i}>{lines}</div>;
+        }
+        const failed = section.end && section.end.exit_code !== 0;
+        return (
+          // 执行中和失败的命令默认展开
+          <details key={i} open={!section.end || failed}>
+            <summary className="cursor-pointer text-cyan-300">
+              {showRunner && `[${section.runner}] `}
+              {section.start.text}
+              {section.end && (
+                <span className={failed ? "text-red-400 ml-2" : "text-gray-500 ml-2"}>
+                  ({formatDuration(section.end.duration || 0)}, exit code:{" "}
+                  {section.end.exit_code})
+                </span>
+              )}
+            </summary>
+            <div className="pl-4">{lines}</div>
+          </details>
+        );
+      })}
+    </pre>
+  );
+}
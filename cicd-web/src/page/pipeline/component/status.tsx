@@ -69,7 +69,8 @@ export default function Status(props: any) {
               <Button
                 disabled={
                   stepGroups[groupIndex - 1].steps[stepGroups[groupIndex - 1].steps.length - 1].last_status !== "success" &&
-                  stepGroups[groupIndex - 1].steps[stepGroups[groupIndex - 1].steps.length - 1].last_status !== "failed"
+                  stepGroups[groupIndex - 1].steps[stepGroups[groupIndex - 1].steps.length - 1].last_status !== "failed" &&
+                  stepGroups[groupIndex - 1].steps[stepGroups[groupIndex - 1].steps.length - 1].last_status !== "allowed_failure"
                 }
                 type="text"
                 size="small"
@@ -109,7 +110,8 @@ export default function Status(props: any) {
                   <Button
                     disabled={
                       step.last_status !== "success" &&
-                      step.last_status !== "failed"
+                      step.last_status !== "failed" &&
+                      step.last_status !== "allowed_failure"
                     }
                     type="text"
                     size="small"
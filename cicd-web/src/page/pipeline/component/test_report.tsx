@@ -0,0 +1,177 @@
+import { useEffect, useState } from "react";
+import { useNavigate } from "react-router-dom";
+import { Modal, Space, Statistic, Table, Tag, Tooltip } from "antd";
+import { fetchRequest } from "../../../utils/fetch";
+
+export const testColors: Record<string, string> = {
+  passed: "green",
+  failed: "red",
+  skipped: "default",
+};
+
+export function formatDuration(ms: number) {
+  if (ms < 1000) {
+    return ms + "ms";
+  }
+  return (ms / 1000).toFixed(2) + "s";
+}
+
+// 测试在最近几次任务中的结果
+export function TestHistory({
+  pipelineId,
+  test,
+  onClose,
+}: {
+  pipelineId: number;
+  test?: { suite: string; name: string };
+  onClose: () => void;
+}) {
+  const navigate = useNavigate();
+  const [history, setHistory] = useState<any[]>([]);
+
+  useEffect(() => {
+    setHistory([]);
+    if (test) {
+      loadHistory(test);
+    }
+  }, [test]);
+
+  const loadHistory = async (test: { suite: string; name: string }) => {
+    const params = new URLSearchParams({ suite: test.suite, name: test.name });
+    const res = await fetchRequest(
+      `/api/test_history/${pipelineId}?${params.toString()}`,
+      {
+        method: "GET",
+      }
+    );
+    setHistory(res || []);
+  };
+
+  return (
+    <Modal
+      title={test && `${test.suite} ${test.name}`}
+      open={!!test}
+      onCancel={onClose}
+      footer={null}
+      width={800}
+    >
+      <Table
+        rowKey="job_runner_id"
+        size="small"
+        dataSource={history}
+        pagination={false}
+        expandable={{
+          rowExpandable: (record) => !!record.message,
+          expandedRowRender: (record) => (
+            <pre style={{ whiteSpace: "pre-wrap" }}>{record.message}</pre>
+          ),
+        }}
+        columns={[
+          {
+            title: "Tag",
+            dataIndex: "tag",
+            render: (tag, record) => (
+              <a
+                onClick={() => {
+                  onClose();
+                  navigate("/logs?id=" + record.job_runner_id);
+                }}
+              >
+                {tag}
+              </a>
+            ),
+          },
+          {
+            title: "结果",
+            dataIndex: "status",
+            render: (status) => <Tag color={testColors[status]}>{status}</Tag>,
+          },
+          {
+            title: "耗时",
+            dataIndex: "duration",
+            render: (duration) => formatDuration(duration),
+          },
+          { title: "时间", dataIndex: "created_at" },
+        ]}
+      />
+    </Modal>
+  );
+}
+
+export default function TestReport({
+  pipelineId,
+  tests,
+}: {
+  pipelineId: number;
+  tests: any;
+}) {
+  const [selected, setSelected] = useState<{ suite: string; name: string }>();
+
+  return (
+    <div>
+      <Space size="large">
+        <Statistic title="总数" value={tests.total} />
+        <Statistic
+          title="通过"
+          value={tests.passed}
+          valueStyle={{ color: "#50d71e" }}
+        />
+        <Statistic
+          title="失败"
+          value={tests.failed}
+          valueStyle={{ color: "#ea5506" }}
+        />
+        <Statistic title="跳过" value={tests.skipped} />
+        <Statistic
+          title="不稳定"
+          value={tests.flaky}
+          valueStyle={{ color: "#f8b862" }}
+        />
+        <Statistic title="耗时" value={formatDuration(tests.duration)} />
+      </Space>
+      <Table
+        className="mt-4"
+        rowKey="id"
+        size="small"
+        dataSource={tests.cases}
+        expandable={{
+          rowExpandable: (record) => !!record.message,
+          expandedRowRender: (record) => (
+            <pre style={{ whiteSpace: "pre-wrap" }}>{record.message}</pre>
+          ),
+        }}
+        columns={[
+          {
+            title: "测试",
+            dataIndex: "name",
+            render: (name, record) => (
+              <Space>
+                <Tooltip title="查看历史结果">
+                  <a onClick={() => setSelected(record)}>{name}</a>
+                </Tooltip>
+                {record.flaky && <Tag color="orange">不稳定</Tag>}
+              </Space>
+            ),
+          },
+          { title: "Suite", dataIndex: "suite" },
+          {
+            title: "结果",
+            dataIndex: "status",
+            render: (status) => <Tag color={testColors[status]}>{status}</Tag>,
+          },
+          {
+            title: "耗时",
+            dataIndex: "duration",
+            render: (duration) => formatDuration(duration),
+          },
+          { title: "报告", dataIndex: "file" },
+        ]}
+      />
+      <TestHistory
+        pipelineId={pipelineId}
+        test={selected}
+        onClose={() => setSelected(undefined)}
+      />
+    </div>
+  );
+}
@@ -10,6 +10,7 @@ import {
   message,
   Switch,
   Select,
+  Checkbox,
 } from "antd";
 import { MinusCircleOutlined, PlusOutlined } from "@ant-design/icons";
 import { fetchRequest } from "../../utils/fetch";
@@ -47,12 +48,14 @@ export default function NewPipeline() {
     name: string;
     group_name: string;
     tag_template: string;
-    envs: { key: string; val: string }[];
+    envs: { key: string; val: string; secret?: boolean; set?: boolean }[];
     use_git: boolean;
     repository?: string;
     branch?: string;
     username?: string;
     password?: string;
+    password_set?: boolean;
+    clear_password?: boolean;
   };
 
   const onFinish: FormProps<FieldType>["onFinish"] = async (values) => {
@@ -104,7 +107,8 @@ export default function NewPipeline() {
     try {
       await fetchRequest("/api/test_git", {
         method: "POST",
-        body: JSON.stringify(values),
+        // 密码留空时使用流水线保存的密码
+        body: JSON.stringify({ ...values, pipeline_id: Number(pipelineId) }),
       });
       setTestGitSuccess(true);
     } catch (e: any) {
@@ -168,12 +172,46 @@ export default function NewPipeline() {
                     >
                       <Input placeholder="Key" />
                     </Form.Item>
+                    <Form.Item
+                      noStyle
+                      shouldUpdate={(prev, cur) =>
+                        prev.envs?.[name]?.secret !== cur.envs?.[name]?.secret
+                      }
+                    >
+                      {({ getFieldValue }) => {
+                        // secret 变量加密保存，接口只返回是否已设置，留空表示不修改
+                        const env = getFieldValue(["envs", name]) || {};
+                        const keep = env.secret && env.set;
+                        return (
+                          <Form.Item
+                            {...restField}
+                            name={[name, "val"]}
+                            rules={[
+                              { required: !keep, message: "请输入value" },
+                              // 过短的值无法在日志中隐藏，server 同样会拒绝
+                              ...(env.secret
+                                ? [{ min: 4, message: "secret 的值至少 4 个字符" }]
+                                : []),
+                            ]}
+                          >
+                            {env.secret ? (
+                              <Input.Password
+                                placeholder={keep ? "已设置，留空不修改" : "Value"}
+                                autoComplete="new-password"
+                              />
+                            ) : (
+                              <Input placeholder="Value" />
+                            )}
+                          </Form.Item>
+                        );
+                      }}
+                    </Form.Item>
                     <Form.Item
                       {...restField}
-                      name={[name, "val"]}
-                      rules={[{ required: true, message: "请输入value" }]}
+                      name={[name, "secret"]}
+                      valuePropName="checked"
                     >
-                      <Input placeholder="Value" />
+                      <Checkbox>Secret</Checkbox>
                     </Form.Item>
                     <MinusCircleOutlined
                       onClick={() => {
@@ -250,8 +288,24 @@ export default function NewPipeline() {
                 </Form.Item>
 
                 <Form.Item label="密码" name="password">
-                  <Input placeholder="请输入密码" />
+                  <Input.Password
+                    placeholder={
+                      getFieldValue("password_set")
+                        ? "已设置，仓库地址不变时留空不修改"
+                        : "请输入密码"
+                    }
+                    autoComplete="new-password"
+                  />
                 </Form.Item>
+                {getFieldValue("password_set") && (
+                  <Form.Item
+                    label={null}
+                    name="clear_password"
+                    valuePropName="checked"
+                  >
+                    <Checkbox>清除已保存的密码</Checkbox>
+                  </Form.Item>
+                )}
                 <Form.Item label={null}>
                   <Button onClick={testGit} loading={testGitLoading}>
                     测试连接
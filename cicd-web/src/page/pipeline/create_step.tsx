@@ -52,6 +52,8 @@ export default function NewStep() {
     trigger_policy: boolean;
     runner_label_match: string;
     multiple_runner_exec: boolean;
+    test_reports: string[];
+    problem_matchers: { name: string; pattern: string; severity?: string }[];
   };
 
   const onFinish: FormProps<FieldType>["onFinish"] = async (values) => {
@@ -192,6 +194,74 @@ export default function NewStep() {
           <Switch />
         </Form.Item>
 
+        <Form.Item<FieldType>
+          label="测试报告"
+          name="test_reports"
+          tooltip="命令结束后解析的 JUnit XML 或 go test -json 输出，填写相对工作目录的路径，支持通配符"
+        >
+          <Select
+            mode="tags"
+            placeholder="例如 reports/*.xml、test.json"
+            open={false}
+          />
+        </Form.Item>
+
+        <Form.Item
+          label="问题匹配规则"
+          tooltip="匹配的日志行显示为注解，正则可以使用命名分组 file、line、column、severity、code、message。步骤的规则优先于全局规则"
+        >
+          <Form.List name="problem_matchers">
+            {(fields, { add, remove }) => (
+              <>
+                {fields.map(({ key, name, ...restField }) => (
+                  <Row key={key} gutter={10}>
+                    <Col span={6}>
+                      <Form.Item
+                        {...restField}
+                        name={[name, "name"]}
+                        rules={[{ required: true, message: "请输入名称" }]}
+                      >
+                        <Input placeholder="名称" />
+                      </Form.Item>
+                    </Col>
+                    <Col span={11}>
+                      <Form.Item
+                        {...restField}
+                        name={[name, "pattern"]}
+                        rules={[{ required: true, message: "请输入正则" }]}
+                      >
+                        <Input placeholder="^(?P<file>\S+):(?P<line>\d+): (?P<message>.+)$" />
+                      </Form.Item>
+                    </Col>
+                    <Col span={5}>
+                      <Form.Item {...restField} name={[name, "severity"]}>
+                        <Select
+                          allowClear
+                          placeholder="error"
+                          options={["error", "warning", "notice"].map((s) => ({ value: s, label: s }))}
+                        />
+                      </Form.Item>
+                    </Col>
+                    <Col span={2}>
+                      <MinusCircleOutlined onClick={() => remove(name)} />
+                    </Col>
+                  </Row>
+                ))}
+                <Form.Item>
+                  <Button
+                    type="dashed"
+                    onClick={() => add()}
+                    block
+                    icon={<PlusOutlined />}
+                  >
+                    新增规则
+                  </Button>
+                </Form.Item>
+              </>
+            )}
+          </Form.List>
+        </Form.Item>
+
         <Form.Item label={null}>
           <Space>
             <Button type="primary" htmlType="submit">
@@ -1,7 +1,8 @@
 import { useEffect, useState } from "react";
 import { useSearchParams } from "react-router-dom";
-import { List } from "antd";
+import { Button, List } from "antd";
 import Status from "./component/status";
+import FlakyTests from "./component/flaky_tests";
 import { fetchRequest } from "../../utils/fetch";
 
 interface LoadDataParams {
@@ -19,6 +20,7 @@ export default function History() {
     page_size: 10,
   });
   const [total, setTotal] = useState(0);
+  const [flakyOpen, setFlakyOpen] = useState(false);
 
   useEffect(() => {
     if (pipelineId) {
@@ -45,6 +47,12 @@ export default function History() {
 
   return (
     <div>
+      <Button onClick={() => setFlakyOpen(true)}>不稳定的测试</Button>
+      <FlakyTests
+        pipelineId={Number(pipelineId)}
+        open={flakyOpen}
+        onClose={() => setFlakyOpen(false)}
+      />
       <List
         pagination={{
           pageSize: queryParams.page_size,
@@ -0,0 +1,228 @@
+import { useEffect, useState } from "react";
+import { useNavigate } from "react-router-dom";
+import { Input, Select, DatePicker, List, Space, Tag, Typography } from "antd";
+import { fetchRequest } from "../../utils/fetch";
+import { colors, intro } from "../../config/consts";
+
+interface SearchParams {
+  q: string;
+  pipeline_id?: number;
+  step_id?: number;
+  status?: string;
+  range?: [any, any] | null;
+  page: number;
+  page_size: number;
+}
+
+// 高亮匹配的关键字，trigram 检索不区分大小写
+function highlight(text: string, keyword: string) {
+  const lower = text.toLowerCase();
+  const key = keyword.toLowerCase();
+  const parts = [];
+  let start = 0;
+  let i;
+  while (key && (i = lower.indexOf(key, start)) >= 0) {
+    parts.push(text.slice(start, i));
+    parts.push(
+      <mark key={i} className="bg-yellow-300">
+        {text.slice(i, i + key.length)}
+      </mark>
+    );
+    start = i + key.length;
+  }
+  parts.push(text.slice(start));
+  return parts;
+}
+
+export default function LogSearch() {
+  const navigate = useNavigate();
+  const [pipelines, setPipelines] = useState<any[]>([]);
+  const [steps, setSteps] = useState<any[]>([]);
+  const [params, setParams] = useState<SearchParams>({
+    q: "",
+    page: 1,
+    page_size: 10,
+  });
+  const [results, setResults] = useState<any[]>([]);
+  const [total, setTotal] = useState(0);
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    loadPipelines();
+  }, []);
+
+  useEffect(() => {
+    setSteps([]);
+    if (params.pipeline_id) {
+      loadSteps(params.pipeline_id);
+    }
+  }, [params.pipeline_id]);
+
+  useEffect(() => {
+    if (params.q.trim().length >= 3) {
+      search();
+    }
+  }, [params]);
+
+  const loadPipelines = async () => {
+    const res = await fetchRequest("/api/list_pipeline", {
+      method: "GET",
+    });
+    setPipelines(
+      (res || []).flatMap((group: any) =>
+        group.pipelines.map((p: any) => ({ value: p.id, label: p.name }))
+      )
+    );
+  };
+
+  const loadSteps = async (pipelineId: number) => {
+    const res = await fetchRequest("/api/pipeline/" + pipelineId, {
+      method: "GET",
+    });
+    setSteps(
+      (res.stages_and_steps || [])
+        .flatMap((item: any) =>
+          item.type === "stage" ? item.children || [] : [item]
+        )
+        .map((step: any) => ({ value: step.id, label: step.name }))
+    );
+  };
+
+  const search = async () => {
+    const query: Record<string, string> = {
+      q: params.q.trim(),
+      page: String(params.page),
+      page_size: String(params.page_size),
+    };
+    if (params.pipeline_id) {
+      query.pipeline_id = String(params.pipeline_id);
+    }
+    if (params.step_id) {
+      query.step_id = String(params.step_id);
+    }
+    if (params.status) {
+      query.status = params.status;
+    }
+    if (params.range) {
+      query.start = String(params.range[0].startOf("day").valueOf());
+      query.end = String(params.range[1].endOf("day").valueOf());
+    }
+    setLoading(true);
+    try {
+      const res = await fetchRequest(
+        "/api/search_log?" + new URLSearchParams(query).toString(),
+        {
+          method: "GET",
+        }
+      );
+      setResults(res.list || []);
+      setTotal(res.total);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div>
+      <Space wrap>
+        <Input.Search
+          placeholder="搜索日志，至少 3 个字符"
+          allowClear
+          className="w-[360px]"
+          onSearch={(q) => setParams({ ...params, q, page: 1 })}
+        />
+        <Select
+          placeholder="流水线"
+          allowClear
+          showSearch
+          optionFilterProp="label"
+          className="w-[200px]"
+          options={pipelines}
+          onChange={(pipeline_id) =>
+            setParams({ ...params, pipeline_id, step_id: undefined, page: 1 })
+          }
+        />
+        <Select
+          placeholder="步骤"
+          allowClear
+          className="w-[200px]"
+          options={steps}
+          value={params.step_id}
+          disabled={!params.pipeline_id}
+          onChange={(step_id) => setParams({ ...params, step_id, page: 1 })}
+        />
+        <Select
+          placeholder="状态"
+          allowClear
+          className="w-[150px]"
+          options={Object.keys(intro).map((key) => ({
+            value: key,
+            label: intro[key],
+          }))}
+          onChange={(status) => setParams({ ...params, status, page: 1 })}
+        />
+        <DatePicker.RangePicker
+          onChange={(range) =>
+            setParams({ ...params, range: range as any, page: 1 })
+          }
+        />
+      </Space>
+      <List
+        className="mt-4"
+        loading={loading}
+        pagination={{
+          pageSize: params.page_size,
+          current: params.page,
+          total: total,
+          onChange: (page, pageSize) => {
+            setParams({ ...params, page, page_size: pageSize });
+          },
+        }}
+        dataSource={results}
+        renderItem={(item) => (
+          <List.Item>
+            <div className="w-full">
+              <Space>
+                <a onClick={() => navigate("/logs?id=" + item.job_runner_id)}>
+                  {item.pipeline_name} / {item.step_name} / {item.tag}
+                </a>
+                <Tag color={colors[item.status]}>
+                  {intro[item.status] || item.status}
+                </Tag>
+                <Typography.Text type="secondary">
+                  {item.start_time}，匹配 {item.match_count} 行
+                </Typography.Text>
+              </Space>
+              {item.matches?.map((match: any, i: number) => (
+                <pre
+                  key={i}
+                  className="mt-2 bg-black text-white p-2 rounded-md"
+                  style={{ whiteSpace: "pre-wrap" }}
+                >
+                  {match.before?.map((line: string, j: number) => (
+                    <div key={"b" + j} className="text-gray-400">
+                      {line}
+                    </div>
+                  ))}
+                  <div className={match.stream === "stderr" ? "text-red-300" : ""}>
+                    {match.runner && (
+                      <span className="text-gray-500 select-none">
+                        [{match.runner}]{" "}
+                      </span>
+                    )}
+                    {highlight(match.text, params.q.trim())}
+                  </div>
+                  {match.after?.map((line: string, j: number) => (
+                    <div key={"a" + j} className="text-gray-400">
+                      {line}
+                    </div>
+                  ))}
+                </pre>
+              ))}
+            </div>
+          </List.Item>
+        )}
+      />
+    </div>
+  );
+}
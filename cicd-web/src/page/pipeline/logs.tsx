@@ -8,11 +8,19 @@ import {
   Space,
   Button,
   Typography,
+  Tabs,
   message as msg,
 } from "antd";
 import type { DescriptionsProps } from "antd";
-import { ReloadOutlined, CloseOutlined } from "@ant-design/icons";
-import { fetchRequest } from "../../utils/fetch";
+import {
+  ReloadOutlined,
+  CloseOutlined,
+  DownloadOutlined,
+} from "@ant-design/icons";
+import { fetchRequest, downloadFile } from "../../utils/fetch";
+import LogView, { LogRecord } from "./component/log_view";
+import TestReport from "./component/test_report";
+import Annotations from "./component/annotations";
 
 export default function Logs() {
   const navigate = useNavigate();
@@ -22,7 +30,7 @@ export default function Logs() {
   const [job, setJob] = useState<any>();
   const [items, setItems] = useState<DescriptionsProps["items"]>([]);
   const [jobRunners, setJobRunners] = useState<any[]>([]);
-  const [log, setLog] = useState<string>("");
+  const [records, setRecords] = useState<LogRecord[]>([]);
   const [message, setMessage] = useState<string>("");
 
   useEffect(() => {
@@ -30,20 +38,78 @@ export default function Logs() {
   }, [id]);
 
   useEffect(() => {
-    if (job?.job_runner?.last_runner_id) {
-      loadLogs(job?.job_runner?.last_runner_id);
-      const timer = setInterval(() => {
-        loadLogs(job?.job_runner?.last_runner_id);
-      }, 5000);
+    const runnerId = job?.job_runner?.last_runner_id;
+    if (runnerId) {
+      setRecords([]);
+      const controller = new AbortController();
+      streamLogs(runnerId, controller.signal);
 
       return () => {
-        clearInterval(timer); // 清理定时器避免内存泄漏
+        controller.abort(); // 切换步骤或离开页面时关闭日志流
       };
     }
   }, [job?.job_runner?.last_runner_id]);
 
+  // 通过 SSE 跟随日志，断线后从最后的 offset 续传；EventSource 无法携带 token，这里使用 fetch 读取
+  const streamLogs = async (runnerId: number, signal: AbortSignal) => {
+    let offset = 0;
+    while (!signal.aborted) {
+      try {
+        const res = await fetch(
+          `/api/job_runner_log_stream/${runnerId}?format=json&offset=${offset}`,
+          {
+            headers: {
+              Authorization: `Bearer ${localStorage.getItem("token")}`,
+            },
+            signal,
+          }
+        );
+        if (!res.ok || !res.body) {
+          throw new Error(`请求失败，状态码：${res.status}`);
+        }
+        const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+        let buffer = "";
+        for (;;) {
+          const { value, done } = await reader.read();
+          if (done) {
+            break;
+          }
+          buffer += value;
+          let end;
+          while ((end = buffer.indexOf("\n\n")) >= 0) {
+            const event = buffer.slice(0, end);
+            buffer = buffer.slice(end + 2);
+            let type = "message";
+            const data: string[] = [];
+            for (const line of event.split("\n")) {
+              if (line.startsWith("event: ")) {
+                type = line.slice(7);
+              } else if (line.startsWith("id: ")) {
+                offset = Number(line.slice(4));
+              } else if (line.startsWith("data: ")) {
+                data.push(line.slice(6));
+              }
+            }
+            if (type === "status") {
+              loadDetail();
+              return;
+            }
+            if (data.length > 0) {
+              const rs = data.map((d) => JSON.parse(d) as LogRecord);
+              setRecords((prev) => [...prev, ...rs]);
+            }
+          }
+        }
+      } catch (e) {
+        if (signal.aborted) {
+          return;
+        }
+      }
+      await new Promise((resolve) => setTimeout(resolve, 3000));
+    }
+  };
+
   const loadDetail = async () => {
-    setLog("");
     const res = await fetchRequest("/api/job_runner/" + id, {
       method: "GET",
     });
@@ -90,24 +156,6 @@ export default function Logs() {
     ]);
   };
 
-  const loadLogs = async (id: number) => {
-    const res = await fetchRequest("/api/job_runner_log/" + id, {
-      method: "GET",
-    });
-    setLog(res);
-    if (job?.job_runner?.length > 0) {
-      const rs = job?.job_runner?.filter((r: any) => r.id === id);
-      if (rs.length > 0) {
-        const message = rs[0].message;
-        if (message) {
-          setMessage(message);
-        }
-      } else {
-        setMessage("");
-      }
-    }
-  };
-
   const startStep = async (jobRunnerId: number) => {
     await fetchRequest("/api/start_job_step/" + jobRunnerId, {
       method: "POST",
@@ -123,6 +171,20 @@ export default function Logs() {
     loadDetail();
   };
 
+  const logPanel = (
+    <div
+      className="overflow-y-scroll mt-4 bg-black text-white p-4 rounded-md"
+      style={{ height: "calc(100vh - 300px)", minHeight: "300px" }}
+      ref={(el) => {
+        if (el) {
+          el.scrollTop = el.scrollHeight;
+        }
+      }}
+    >
+      <LogView records={records} />
+    </div>
+  );
+
   return (
     <div>
       <Descriptions title={job?.pipeline?.name} items={items} />
@@ -138,9 +200,36 @@ export default function Logs() {
             }}
           />
         )}
+        {job?.job_runner?.last_runner_id && (
+          <Button
+            icon={<DownloadOutlined />}
+            onClick={() =>
+              downloadFile(
+                `/api/job_runner_log/${job.job_runner.last_runner_id}/raw?strip_ansi=true`,
+                `${job?.pipeline?.name}-${job.job_runner.last_runner_id}.log`
+              )
+            }
+          >
+            下载日志
+          </Button>
+        )}
+        {job?.job?.id && (
+          <Button
+            icon={<DownloadOutlined />}
+            onClick={() =>
+              downloadFile(
+                `/api/job_log_archive/${job.job.id}?strip_ansi=true`,
+                `${job?.pipeline?.name}-${job.job.tag}-logs.zip`
+              )
+            }
+          >
+            下载全部步骤日志
+          </Button>
+        )}
         {job?.job_runner?.last_status === "canceled" ||
         job?.job_runner?.last_status === "failed" ||
         job?.job_runner?.last_status === "partial_success" ||
+        job?.job_runner?.last_status === "allowed_failure" ||
         job?.job_runner?.last_status === "success" ? (
           <Button
             type="primary"
@@ -166,21 +255,26 @@ export default function Logs() {
         )}
       </Space>
       {message && <Alert message={message} type="error" className="mt-4" />}
-      <div
-        className="overflow-y-scroll mt-4 bg-black text-white p-4 rounded-md"
-        style={{ height: "calc(100vh - 300px)", minHeight: "300px" }}
-        ref={(el) => {
-          if (el) {
-            el.scrollTop = el.scrollHeight;
-          }
-        }}
-      >
-        <pre style={{ whiteSpace: "pre-wrap" }}>
-          {(log || "").split("\n").map((line, i) => (
-            <div key={i}>{line}</div>
-          ))}
-        </pre>
-      </div>
+      {job?.annotations?.length > 0 && (
+        <Annotations annotations={job.annotations} />
+      )}
+      {job?.tests ? (
+        <Tabs
+          className="mt-2"
+          items={[
+            { key: "logs", label: "日志", children: logPanel },
+            {
+              key: "tests",
+              label: `测试 (${job.tests.failed} 失败 / ${job.tests.total})`,
+              children: (
+                <TestReport pipelineId={job.pipeline.id} tests={job.tests} />
+              ),
+            },
+          ]}
+        />
+      ) : (
+        logPanel
+      )}
     </div>
   );
 }
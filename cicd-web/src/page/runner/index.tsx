@@ -49,6 +49,22 @@ const IndexApplication: React.FC = () => {
     message.success("已设置为空闲");
   };
 
+  const drainRunner = async (runnerId: string) => {
+    await fetchRequest("/api/drain_runner/" + runnerId, {
+      method: "PUT",
+    });
+    loadData();
+    message.success("已开始排空");
+  };
+
+  const resetRunnerSecret = async (runnerId: string) => {
+    await fetchRequest("/api/reset_runner_secret/" + runnerId, {
+      method: "PUT",
+    });
+    loadData();
+    message.success("已重置密钥");
+  };
+
   const deleteRunner = async (runnerId: string) => {
     await fetchRequest("/api/delete_runner/" + runnerId, {
       method: "DELETE",
@@ -92,6 +108,10 @@ const IndexApplication: React.FC = () => {
           <Tag bordered={false} color="processing">
             在线
           </Tag>
+        ) : obj === "draining" ? (
+          <Tag bordered={false} color="orange">
+            排空中
+          </Tag>
         ) : (
           <Tag bordered={false} color="gold">
             下线
@@ -138,6 +158,26 @@ const IndexApplication: React.FC = () => {
               设置为空闲
             </Button>
           )}
+          {record.status === "online" && (
+            <Popconfirm
+              title="提示"
+              description={`是否排空${record.name}? 排空后runner不再接收新任务，当前任务结束后退出`}
+              onConfirm={() => drainRunner(record.id)}
+              okText="确定"
+              cancelText="取消"
+            >
+              <Button type="link">排空</Button>
+            </Popconfirm>
+          )}
+          <Popconfirm
+            title="提示"
+            description={`是否重置${record.name}的密钥? 重置后runner下线，需要重新注册`}
+            onConfirm={() => resetRunnerSecret(record.id)}
+            okText="确定"
+            cancelText="取消"
+          >
+            <Button type="link">重置密钥</Button>
+          </Popconfirm>
           <Popconfirm
             title="提示"
             description={`是否删除${record.name}?`}
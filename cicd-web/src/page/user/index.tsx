@@ -13,6 +13,7 @@ import {
 } from "antd";
 import type { TableProps, TabsProps } from "antd";
 import { fetchRequest } from "../../utils/fetch";
+import ProblemMatchers from "./problem_matcher";
 
 interface User {
   id: number;
@@ -401,6 +402,11 @@ const IndexUser: React.FC = () => {
         </>
       ),
     },
+    {
+      key: "3",
+      label: "问题匹配规则",
+      children: <ProblemMatchers />,
+    },
   ];
 
   return (
@@ -0,0 +1,174 @@
+import React, { useState, useEffect } from "react";
+import {
+  Table,
+  Button,
+  message,
+  Popconfirm,
+  Form,
+  Modal,
+  Input,
+  Select,
+  Tag,
+} from "antd";
+import type { TableProps } from "antd";
+import { fetchRequest } from "../../utils/fetch";
+import { severityColors } from "../pipeline/component/annotations";
+
+interface ProblemMatcher {
+  id: number;
+  name: string;
+  pattern: string;
+  severity: string;
+}
+
+// 全局的问题匹配规则，对所有步骤的日志生效
+const ProblemMatchers: React.FC = () => {
+  const [data, setData] = useState<ProblemMatcher[]>([]);
+  const [formMatcher, setFormMatcher] = useState<ProblemMatcher>();
+  const [open, setOpen] = useState(false);
+  const [form] = Form.useForm();
+
+  useEffect(() => {
+    loadData();
+  }, []);
+
+  const loadData = async () => {
+    const data = await fetchRequest(`/api/list_problem_matcher`);
+    setData(data.list);
+  };
+
+  const deleteMatcher = async (id: number) => {
+    await fetchRequest("/api/delete_problem_matcher/" + id, {
+      method: "DELETE",
+    });
+    loadData();
+    message.success("已删除");
+  };
+
+  const onSave = async (values: ProblemMatcher) => {
+    const url = formMatcher
+      ? `/api/update_problem_matcher/${formMatcher.id}`
+      : `/api/create_problem_matcher`;
+    await fetchRequest(url, {
+      method: formMatcher ? "PUT" : "POST",
+      body: JSON.stringify(values),
+    });
+    setOpen(false);
+    loadData();
+  };
+
+  const columns: TableProps<ProblemMatcher>["columns"] = [
+    {
+      title: "名称",
+      dataIndex: "name",
+      key: "name",
+      width: 160,
+    },
+    {
+      title: "正则",
+      dataIndex: "pattern",
+      key: "pattern",
+      render: (text) => <code className="break-all">{text}</code>,
+    },
+    {
+      title: "级别",
+      dataIndex: "severity",
+      key: "severity",
+      width: 100,
+      render: (text) => (
+        <Tag color={severityColors[text || "error"]}>{text || "error"}</Tag>
+      ),
+    },
+    {
+      title: "操作",
+      key: "action",
+      width: 160,
+      render: (_, record) => (
+        <>
+          <Button
+            type="link"
+            onClick={() => {
+              setFormMatcher(record);
+              form.setFieldsValue(record);
+              setOpen(true);
+            }}
+          >
+            编辑
+          </Button>
+          <Popconfirm
+            title="提示"
+            description={`是否删除${record.name}?`}
+            onConfirm={() => deleteMatcher(record.id)}
+            okText="确定"
+            cancelText="取消"
+          >
+            <Button type="link" danger>
+              删除
+            </Button>
+          </Popconfirm>
+        </>
+      ),
+    },
+  ];
+
+  return (
+    <>
+      <Button
+        type="primary"
+        onClick={() => {
+          form.resetFields();
+          setFormMatcher(undefined);
+          setOpen(true);
+        }}
+      >
+        添加规则
+      </Button>
+      <Table<ProblemMatcher>
+        columns={columns}
+        dataSource={data}
+        style={{ marginTop: 16 }}
+        rowKey="id"
+      />
+      <Modal
+        open={open}
+        title={formMatcher ? "编辑规则" : "添加规则"}
+        okText="保存"
+        cancelText="取消"
+        okButtonProps={{ autoFocus: true, htmlType: "submit" }}
+        onCancel={() => setOpen(false)}
+        destroyOnHidden
+        modalRender={(dom) => (
+          <Form
+            layout="vertical"
+            form={form}
+            name="problem_matcher"
+            clearOnDestroy
+            onFinish={(values) => onSave(values)}
+          >
+            {dom}
+          </Form>
+        )}
+      >
+        <Form.Item name="name" label="名称" rules={[{ required: true, message: "请输入名称" }]}>
+          <Input placeholder="例如 go、eslint" />
+        </Form.Item>
+        <Form.Item
+          name="pattern"
+          label="正则"
+          tooltip="可以使用命名分组 file、line、column、severity、code、message，没有 message 时使用整行"
+          rules={[{ required: true, message: "请输入正则" }]}
+        >
+          <Input placeholder="^(?P<file>[^\s:]+):(?P<line>\d+): (?P<message>.+)$" />
+        </Form.Item>
+        <Form.Item name="severity" label="级别" tooltip="没有 severity 分组时使用">
+          <Select
+            allowClear
+            placeholder="error"
+            options={["error", "warning", "notice"].map((s) => ({ value: s, label: s }))}
+          />
+        </Form.Item>
+      </Modal>
+    </>
+  );
+};
+export default ProblemMatchers;
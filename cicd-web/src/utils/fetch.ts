@@ -51,4 +51,25 @@ export async function fetchRequest(url: string, options: RequestInit = {}) {
     message.error(error.message);
     throw error;
   }
-}
\ No newline at end of file
+}
+// 携带 token 下载文件，浏览器直接打开链接无法带上 Authorization
+export async function downloadFile(url: string, filename: string) {
+  try {
+    const response = await fetch(url, {
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    if (!response.ok) {
+      throw new Error(`下载失败，状态码：${response.status}`);
+    }
+    const blob = await response.blob();
+    const link = document.createElement('a');
+    link.href = URL.createObjectURL(blob);
+    link.download = filename;
+    link.click();
+    URL.revokeObjectURL(link.href);
+  } catch (error: any) {
+    message.error(error.message);
+  }
+}